@@ -30,8 +30,16 @@ Description=GoKubeBalancer Service
 After=network.target
 
 [Service]
+Type=notify
+# An upgrade (SIGUSR2) starts a child process that reports READY=1 and its MAINPID itself, which
+# systemd only accepts from a process other than the main one with NotifyAccess=all
+NotifyAccess=all
+# READY=1 is only sent once Kubernetes is reachable and the listeners are up, and the connection is
+# retried forever, so do not let systemd kill a slow start; systemctl status shows what it waits for
+TimeoutStartSec=infinity
 EnvironmentFile=$INSTALL_DIR/.env
 ExecStart=$INSTALL_DIR/GoKubeBalancer
+ExecReload=/bin/kill -HUP \$MAINPID
 WorkingDirectory=$INSTALL_DIR
 Restart=always
 StandardOutput=syslog
@@ -3,18 +3,30 @@ package main
 import (
 	"context"
 	"flag"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"github.com/supporttools/GoKubeBalancer/pkg/backend"
+	"github.com/supporttools/GoKubeBalancer/pkg/balancer"
 	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/health"
 	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
 	"github.com/supporttools/GoKubeBalancer/pkg/logging"
 	"github.com/supporttools/GoKubeBalancer/pkg/metrics"
 	"github.com/supporttools/GoKubeBalancer/pkg/network"
-	"k8s.io/client-go/kubernetes"
+	"github.com/supporttools/GoKubeBalancer/pkg/sockets"
+	"k8s.io/client-go/tools/cache"
 )
 
+// configWatchInterval is how often the configuration and members files are checked for changes
+const configWatchInterval = 2 * time.Second
+
+// upgradeTimeout is how long a new process started for an upgrade gets to accept connections
+const upgradeTimeout = 2 * time.Minute
+
 func main() {
 	flag.Parse()
 	if err := config.LoadConfiguration(); err != nil {
@@ -22,47 +34,141 @@ func main() {
 	}
 	logger := logging.SetupLogging()
 	logger.Debug("Debug logging enabled")
+	if err := sockets.Init(); err != nil {
+		logger.Fatalf("Failed to adopt inherited sockets: %v", err)
+	}
 
 	ctx := context.Background()
-	var clientset *kubernetes.Clientset
 
-	for {
-		logger.Info("Connecting to Kubernetes cluster...")
-		kubeConfig, err := k8sutils.GetConfig(ctx)
+	var members []k8sutils.NodeDetails
+	var clients *k8sutils.ClientManager
+	var err error
+	if config.CFG.StaticMode() {
+		logger.Info("Loading static backend members...")
+		members, err = k8sutils.GetStaticNodes()
 		if err != nil {
-			logger.Errorf("Failed to get Kubernetes config: %v", err)
-			time.Sleep(10 * time.Second) // Retry after 10 seconds
-			continue
+			logger.Fatalf("Failed to load static backend members: %v", err)
 		}
-		clientset, err = kubernetes.NewForConfig(kubeConfig)
-		if err != nil {
-			logger.Errorf("Failed to create Kubernetes clientset: %v", err)
-			time.Sleep(10 * time.Second) // Retry after 10 seconds
-			continue
-		}
-		break
+		logger.Info("Starting GoKubeBalancer in static mode...")
+	} else {
+		clients = connectKubernetes(ctx, logger)
+		logger.Info("Starting GoKubeBalancer...")
 	}
 
-	logger.Info("Retrieving worker nodes...")
-	workerNodes, err := k8sutils.GetWorkerNodes(ctx, clientset)
+	// Each listener has its own backend pool so it can run its own health check
+	var nodeInformer cache.SharedIndexInformer
+	if clients != nil {
+		logger.Info("Watching worker nodes...")
+		nodeInformer = k8sutils.NewNodeInformer(clients, 0)
+	}
+	cfg := config.CFG
+	loadBalancer, err := balancer.New(ctx, &cfg, members, clients, nodeInformer)
 	if err != nil {
-		logger.Fatalf("Failed to retrieve worker nodes: %v", err)
+		logger.Fatalf("Failed to set up listeners: %v", err)
+	}
+	if nodeInformer != nil {
+		go nodeInformer.Run(ctx.Done())
+		if !cache.WaitForCacheSync(ctx.Done(), nodeInformer.HasSynced) {
+			logger.Fatalf("Failed to sync worker nodes")
+		}
 	}
 
-	logger.Info("Starting GoKubeBalancer...")
-	backendManager := backend.NewManager(workerNodes, config.CFG.RescanInterval, clientset)
-	go backendManager.HealthChecker(ctx) // Start health checking
+	metrics.RegisterHandler("/sticky/", loadBalancer.StickyHandler("/sticky/", config.CFG.StickyAdminToken))
+	logger.Println("Starting metrics server...")
+	if err := metrics.StartMetricsServer(); err != nil {
+		logger.Fatalf("Failed to start metrics server: %v", err)
+	}
+
+	sockets.NotifyStatus("Waiting for healthy backends")
+	if err := loadBalancer.Start(); err != nil {
+		logger.Fatalf("Failed to start listeners: %v", err)
+	}
+	sockets.CloseInherited()
+	health.SetReady(true)
+	sockets.NotifyReady()
+
+	// Reload the configuration on SIGHUP or when the configuration or members file changes, hand the
+	// sockets to a new process on SIGUSR2, drain and exit on SIGTERM or SIGINT
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{syscall.SIGHUP, syscall.SIGTERM, os.Interrupt}, sockets.UpgradeSignals...)...)
+	go config.WatchFiles(ctx, configWatchInterval, func() []string {
+		current := loadBalancer.Config()
+		paths := []string{current.ConfigFile, current.BackendMembersFile}
+		for _, pool := range current.Pools {
+			paths = append(paths, pool.BackendMembersFile)
+		}
+		return paths
+	}, func() {
+		select {
+		case signals <- syscall.SIGHUP:
+		default: // A signal is already pending
+		}
+	})
+	for sig := range signals {
+		if slices.Contains(sockets.UpgradeSignals, sig) {
+			logger.Info("Upgrading: starting a new process with the listening sockets...")
+			process, err := sockets.Upgrade(upgradeTimeout)
+			if err != nil {
+				logger.Errorf("Upgrade failed, continuing to serve: %v", err)
+				continue
+			}
+			logger.Infof("New process %d is accepting connections, draining...", process.Pid)
+			// The new process answers on the shared metrics port too; leave it to the new process so
+			// /readyz does not return 503 from this one while it drains
+			metrics.StopMetricsServer()
+			shutdown(loadBalancer, logger)
+			return
+		}
+		if sig != syscall.SIGHUP {
+			logger.Infof("Received %s, shutting down...", sig)
+			sockets.NotifyStopping()
+			shutdown(loadBalancer, logger)
+			return
+		}
+		logger.Info("Reloading configuration...")
+		newConfig, err := config.ReadConfiguration()
+		if err != nil {
+			logger.Errorf("Keeping the current configuration: %v", err)
+			continue
+		}
+		if err := loadBalancer.Reload(newConfig); err != nil {
+			logger.Errorf("Keeping the current configuration: %v", err)
+			continue
+		}
+		logger.Info("Configuration reloaded")
+	}
+}
 
-	go func() {
-		logger.Println("Starting metrics server...")
-		metrics.StartMetricsServer()
-	}()
+// shutdown fails the readiness check, stops accepting connections and gives the established ones
+// until the shutdown timeout to finish before they are closed
+func shutdown(loadBalancer *balancer.Balancer, logger *logrus.Logger) {
+	health.SetReady(false)
+	timeout := loadBalancer.Config().ShutdownTimeout.Duration
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	// Assuming config.CFG defines BackendHttpPort and BackendHttpsPort
-	tcpBalancer80 := network.NewTCPBalancer(config.CFG.FrontendHttpPort, 80, backendManager)    // Use 80 for backend health checks
-	tcpBalancer443 := network.NewTCPBalancer(config.CFG.FrontendHttpsPort, 443, backendManager) // Use 443 for backend health checks
-	go tcpBalancer80.Start()
-	go tcpBalancer443.Start()
+	started := time.Now()
+	var total network.DrainStats
+	for _, stats := range loadBalancer.Shutdown(ctx) {
+		logger.Infof("Listener %s: %d connections, %d drained, %d force-closed", stats.Listener, stats.Connections, stats.Drained, stats.ForceClosed)
+		total.Connections += stats.Connections
+		total.Drained += stats.Drained
+		total.ForceClosed += stats.ForceClosed
+	}
+	logger.Infof("Shutdown complete after %s: %d connections, %d drained, %d force-closed", time.Since(started).Round(time.Millisecond), total.Connections, total.Drained, total.ForceClosed)
+}
 
-	select {} // Block forever
+// connectKubernetes retries until a Kubernetes client manager can be created
+func connectKubernetes(ctx context.Context, logger *logrus.Logger) *k8sutils.ClientManager {
+	for {
+		logger.Info("Connecting to Kubernetes cluster...")
+		clients, err := k8sutils.NewClientManager(ctx)
+		if err != nil {
+			logger.Errorf("Failed to create Kubernetes client: %v", err)
+			sockets.NotifyStatus("Waiting for the Kubernetes API: " + err.Error())
+			time.Sleep(10 * time.Second) // Retry after 10 seconds
+			continue
+		}
+		return clients
+	}
 }
@@ -2,16 +2,20 @@ package backend
 
 import (
 	"context"
-	"net/http"
-	"strings"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
 	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
 	"github.com/supporttools/GoKubeBalancer/pkg/logging"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
 )
 
 var log = logging.SetupLogging()
@@ -20,119 +24,516 @@ var log = logging.SetupLogging()
 type BackendManager struct {
 	currentIndex        uint32
 	backendList         map[string]k8sutils.NodeDetails
-	ipMap               map[string]string
+	ipMap               *stickyTable // Client IP to backend IP affinity for the sticky algorithms
 	healthMap           map[string]bool
-	clientset           *kubernetes.Clientset
+	healthCounters      map[string]healthCounter // Consecutive check results per backend IP
+	checksInFlight      map[string]bool
+	outliers            map[string]*outlierState // Passive health state per backend IP
+	outlierDetection    config.OutlierDetectionConfig
 	mutex               sync.Mutex
 	healthMutex         sync.Mutex
-	healthCheckInterval time.Duration
+	healthCheckInterval time.Duration // Guarded by healthMutex, replaced on configuration reload
+	intervalChanged     chan struct{} // Wakes the health checker to reset its ticker
+	healthCheck         *HealthCheck  // Guarded by healthMutex, replaced on configuration reload
+	nodeInformer        cache.SharedIndexInformer
+	nodeHandler         cache.ResourceEventHandlerRegistration
+	nodeSelector        labels.Selector  // Nodes of the informer that belong to this pool, guarded by mutex
+	currentWeights      map[string]int   // Smooth weighted round-robin state per backend name
+	maglev              *maglevTable     // Consistent-hash table for the current set of backends
+	now                 func() time.Time // Clock for outlier ejections, time.Now outside tests
 }
 
-// NewManager creates a new backend Manager
-func NewManager(backends []k8sutils.NodeDetails, interval time.Duration, cs *kubernetes.Clientset) *BackendManager {
+// healthCounter tracks consecutive health check results for a backend
+type healthCounter struct {
+	successes int
+	failures  int
+}
+
+// NewManager creates a new backend Manager. Client bindings of the sticky algorithms expire after
+// stickyTTL without use and are capped at stickyMaxEntries; 0 disables either limit. Backends that
+// fail connections are ejected according to outlierDetection.
+func NewManager(backends []k8sutils.NodeDetails, interval time.Duration, healthCheck *HealthCheck, stickyTTL time.Duration, stickyMaxEntries int, outlierDetection config.OutlierDetectionConfig) *BackendManager {
 	log.Println("[Backend Manager] Initializing BackendManager with provided node details and interval.")
 	backendManager := &BackendManager{
 		backendList:         make(map[string]k8sutils.NodeDetails),
-		ipMap:               make(map[string]string),
+		ipMap:               newStickyTable(stickyTTL, stickyMaxEntries),
 		healthMap:           make(map[string]bool),
-		clientset:           cs,
+		healthCounters:      make(map[string]healthCounter),
+		checksInFlight:      make(map[string]bool),
+		outliers:            make(map[string]*outlierState),
+		outlierDetection:    outlierDetection,
+		currentWeights:      make(map[string]int),
 		healthCheckInterval: interval,
+		intervalChanged:     make(chan struct{}, 1),
+		healthCheck:         healthCheck,
+		now:                 time.Now,
 	}
 
 	for _, detail := range backends {
-		// Ensure detail.IP does not include the port here
-		ipWithoutPort := strings.Split(detail.IP, ":")[0]
-		backendManager.backendList[detail.Name] = k8sutils.NodeDetails{Name: detail.Name, IP: ipWithoutPort}
-		backendManager.healthMap[ipWithoutPort] = false
-		log.Debugf("[Backend Manager] Added backend: %s with IP: %s to management pool.", detail.Name, ipWithoutPort)
+		backendManager.AddBackend(detail)
 	}
 
 	return backendManager
 }
 
+// WatchNodes registers the BackendManager with a Node informer so the informer's nodes matching selector
+// are added, updated and removed live
+func (bm *BackendManager) WatchNodes(informer cache.SharedIndexInformer, selector labels.Selector) error {
+	bm.mutex.Lock()
+	bm.nodeSelector = selector
+	bm.mutex.Unlock()
+	log.Println("[Backend Manager] Registering node event handlers.")
+	handler, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*v1.Node); ok {
+				bm.upsertNode(node)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if node, ok := newObj.(*v1.Node); ok {
+				bm.upsertNode(node)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*v1.Node); ok {
+				bm.RemoveBackend(node.Name)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	bm.mutex.Lock()
+	bm.nodeInformer = informer
+	bm.nodeHandler = handler
+	bm.mutex.Unlock()
+	return nil
+}
+
+// UnwatchNodes removes the node event handlers registered by WatchNodes
+func (bm *BackendManager) UnwatchNodes() error {
+	bm.mutex.Lock()
+	informer, handler := bm.nodeInformer, bm.nodeHandler
+	bm.nodeInformer = nil
+	bm.nodeHandler = nil
+	bm.mutex.Unlock()
+	if informer == nil {
+		return nil
+	}
+	log.Println("[Backend Manager] Removing node event handlers.")
+	return informer.RemoveEventHandler(handler)
+}
+
+// SetBackends replaces the pool with the given backends, keeping the state of backends that remain
+func (bm *BackendManager) SetBackends(backends []k8sutils.NodeDetails) {
+	keep := make(map[string]bool, len(backends))
+	for _, detail := range backends {
+		keep[detail.Name] = true
+		bm.AddBackend(detail)
+	}
+
+	bm.mutex.Lock()
+	var removed []string
+	for name := range bm.backendList {
+		if !keep[name] {
+			removed = append(removed, name)
+		}
+	}
+	bm.mutex.Unlock()
+	for _, name := range removed {
+		bm.RemoveBackend(name)
+	}
+}
+
+// SetHealthCheck replaces the active health check; checks already in flight finish with the old one
+func (bm *BackendManager) SetHealthCheck(healthCheck *HealthCheck) {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	bm.healthCheck = healthCheck
+	log.Infof("[Health Checker] Health check updated to %s.", healthCheck.URL("<backend>", 0))
+}
+
+// SetHealthCheckInterval changes how often the backends are checked, starting from the next check
+func (bm *BackendManager) SetHealthCheckInterval(interval time.Duration) {
+	bm.healthMutex.Lock()
+	changed := bm.healthCheckInterval != interval
+	bm.healthCheckInterval = interval
+	bm.healthMutex.Unlock()
+	if changed {
+		select {
+		case bm.intervalChanged <- struct{}{}:
+		default: // The health checker has yet to pick up an earlier change
+		}
+		log.Infof("[Health Checker] Health check interval updated to %s.", interval)
+	}
+}
+
+// currentHealthCheckInterval returns the interval between health checks
+func (bm *BackendManager) currentHealthCheckInterval() time.Duration {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	return bm.healthCheckInterval
+}
+
+// SetStickyLimits changes the idle TTL and size cap of the client bindings; when the cap shrinks,
+// the least recently used bindings are evicted right away
+func (bm *BackendManager) SetStickyLimits(ttl time.Duration, maxEntries int) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	bm.ipMap.setLimits(ttl, maxEntries)
+}
+
+// SetOutlierDetection replaces the outlier detection settings. Running ejections keep their end time;
+// the new settings apply to the next failures.
+func (bm *BackendManager) SetOutlierDetection(outlierDetection config.OutlierDetectionConfig) {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	bm.outlierDetection = outlierDetection
+}
+
+// SetNodeSelector changes which nodes of the informer belong to the pool: nodes that no longer match
+// are removed and newly matching ones added, while the others keep their health and sticky state
+func (bm *BackendManager) SetNodeSelector(selector labels.Selector) {
+	bm.mutex.Lock()
+	bm.nodeSelector = selector
+	informer := bm.nodeInformer
+	bm.mutex.Unlock()
+	if informer == nil {
+		return
+	}
+	for _, obj := range informer.GetStore().List() {
+		if node, ok := obj.(*v1.Node); ok {
+			bm.upsertNode(node)
+		}
+	}
+}
+
+// currentHealthCheck returns the active health check
+func (bm *BackendManager) currentHealthCheck() *HealthCheck {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	return bm.healthCheck
+}
+
+// upsertNode adds a node to the pool or updates it if its IP has changed
+func (bm *BackendManager) upsertNode(node *v1.Node) {
+	bm.mutex.Lock()
+	selector := bm.nodeSelector
+	bm.mutex.Unlock()
+	if !selector.Matches(labels.Set(node.Labels)) {
+		// Also drops nodes whose labels no longer match
+		bm.RemoveBackend(node.Name)
+		return
+	}
+	detail, ok := k8sutils.GetNodeDetails(node)
+	if !ok {
+		log.Warnf("[Backend Manager] Node %s has no InternalIP address, removing it from the pool.", node.Name)
+		bm.RemoveBackend(node.Name)
+		return
+	}
+	bm.AddBackend(detail)
+}
+
+// AddBackend adds a backend to the pool, or updates it if the backend is already known
+func (bm *BackendManager) AddBackend(detail k8sutils.NodeDetails) {
+	// Ensure detail.IP does not include the port here
+	detail.IP = hostWithoutPort(detail.IP)
+	if detail.Weight <= 0 {
+		detail.Weight = 1
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	existing, exists := bm.backendList[detail.Name]
+	if exists && existing == detail {
+		return
+	}
+	bm.backendList[detail.Name] = detail
+	if exists && backendKey(existing) == backendKey(detail) {
+		log.Debugf("[Backend Manager] Updated backend: %s with IP: %s.", detail.Name, detail.IP)
+		return
+	}
+
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	if exists {
+		log.Infof("[Backend Manager] Backend %s changed address from %s to %s.", detail.Name, backendKey(existing), backendKey(detail))
+		bm.forgetIPLocked(backendKey(existing))
+	} else {
+		log.Infof("[Backend Manager] Added backend: %s with IP: %s to management pool.", detail.Name, detail.IP)
+	}
+	bm.healthMap[backendKey(detail)] = false
+}
+
+// RemoveBackend removes a backend from the pool along with its health and client mappings
+func (bm *BackendManager) RemoveBackend(name string) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	detail, exists := bm.backendList[name]
+	if !exists {
+		return
+	}
+	delete(bm.backendList, name)
+	delete(bm.currentWeights, name)
+
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	bm.forgetIPLocked(backendKey(detail))
+	log.Infof("[Backend Manager] Removed backend: %s with IP: %s from management pool.", name, detail.IP)
+}
+
+// forgetIPLocked drops the health entry and client mappings for a backend IP; callers must hold both mutexes
+func (bm *BackendManager) forgetIPLocked(backendIP string) {
+	delete(bm.healthMap, backendIP)
+	delete(bm.healthCounters, backendIP)
+	delete(bm.outliers, backendIP)
+	bm.ipMap.deleteBackend(backendIP)
+}
+
+// BackendAddress returns the host:port to dial for a backend, honouring a per-backend port override
+func (bm *BackendManager) BackendAddress(backendIP string, defaultPort int) string {
+	if _, _, err := net.SplitHostPort(backendIP); err == nil {
+		return backendIP
+	}
+	return net.JoinHostPort(backendIP, strconv.Itoa(defaultPort))
+}
+
+// backendKey identifies a backend in the health, outlier, sticky and connection state and is what
+// the selection functions return as its IP. A backend that overrides the listener's port is keyed by
+// host:port, so several backends can run on one host.
+func backendKey(detail k8sutils.NodeDetails) string {
+	if detail.Port != 0 {
+		return net.JoinHostPort(detail.IP, strconv.Itoa(detail.Port))
+	}
+	return detail.IP
+}
+
+// hostWithoutPort strips an optional port from a host:port string
+func hostWithoutPort(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
 // HealthChecker runs a loop to check the health of all backends periodically
 func (bm *BackendManager) HealthChecker(ctx context.Context) {
 	log.Println("[Health Checker] Starting HealthChecker.")
-	ticker := time.NewTicker(bm.healthCheckInterval)
+	ticker := time.NewTicker(bm.currentHealthCheckInterval())
 	defer ticker.Stop()
 
+	// Check right away so healthy backends enter rotation without waiting for the first tick
+	bm.checkAllBackends(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("[Health Checker] Context cancelled, stopping health checks.")
 			return
+		case <-bm.intervalChanged:
+			ticker.Reset(bm.currentHealthCheckInterval())
 		case <-ticker.C:
 			log.Println("[Health Checker] Performing scheduled health checks on all backends.")
 			bm.checkAllBackends(ctx)
+			bm.expireStickyBindings()
+			bm.decayOutliers()
 		}
 	}
 }
 
-// checkAllBackends iterates over all backends and checks their health
-func (bm *BackendManager) checkAllBackends(ctx context.Context) {
+// WaitHealthy waits until at least one backend is in rotation, returning false if ctx ends first
+func (bm *BackendManager) WaitHealthy(ctx context.Context) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if bm.hasHealthyBackend() {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// WarmupTime is how long the health checker needs to bring a healthy backend into rotation
+func (bm *BackendManager) WarmupTime() time.Duration {
+	healthCheck := bm.currentHealthCheck()
+	return time.Duration(healthCheck.cfg.Rise-1)*bm.currentHealthCheckInterval() + healthCheck.cfg.Timeout.Duration + healthCheck.cfg.Jitter.Duration
+}
+
+func (bm *BackendManager) hasHealthyBackend() bool {
 	bm.healthMutex.Lock()
 	defer bm.healthMutex.Unlock()
+	for backendIP, healthy := range bm.healthMap {
+		if healthy && !bm.isEjectedLocked(backendIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthyBackends returns the IPs of the backends that are healthy and not ejected
+func (bm *BackendManager) HealthyBackends() []string {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	var backendIPs []string
+	for backendIP, healthy := range bm.healthMap {
+		if healthy && !bm.isEjectedLocked(backendIP) {
+			backendIPs = append(backendIPs, backendIP)
+		}
+	}
+	return backendIPs
+}
+
+// expireStickyBindings drops client bindings that have been idle longer than the sticky TTL
+func (bm *BackendManager) expireStickyBindings() {
+	bm.mutex.Lock()
+	expired := bm.ipMap.expire()
+	bm.mutex.Unlock()
+	if expired > 0 {
+		log.Debugf("[Backend Manager] Expired %d idle sticky bindings.", expired)
+	}
+}
+
+// checkAllBackends iterates over all backends and checks their health
+func (bm *BackendManager) checkAllBackends(ctx context.Context) {
+	bm.mutex.Lock()
+	backends := make(map[string]k8sutils.NodeDetails, len(bm.backendList))
 	for name, detail := range bm.backendList {
+		backends[name] = detail
+	}
+	bm.mutex.Unlock()
+	healthCheck := bm.currentHealthCheck()
+
+	for name, detail := range backends {
+		if !bm.startHealthCheck(backendKey(detail)) {
+			log.Debugf("[Health Checker] Previous health check for backend %s still running, skipping.", name)
+			continue
+		}
 		log.Debugf("[Health Checker] Initiating health check for backend %s.", name)
-		go bm.checkHealth(ctx, detail)
+		go func(detail k8sutils.NodeDetails) {
+			defer bm.finishHealthCheck(backendKey(detail))
+			// Spread the checks over the jitter window so they don't all fire on the same tick
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(healthCheck.jitter()):
+			}
+			bm.checkHealth(ctx, healthCheck, detail)
+		}(detail)
 	}
 }
 
-// checkHealth performs a health check by making an HTTP request to the backend's health endpoint and checking Kubernetes node status
-func (bm *BackendManager) checkHealth(ctx context.Context, detail k8sutils.NodeDetails) {
-	// Health check should always be on port 80
-	healthCheckURL := "http://" + detail.IP + ":80/healthz"
+// startHealthCheck marks a check for the backend as in flight, returning false if one already is
+func (bm *BackendManager) startHealthCheck(backendIP string) bool {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	if bm.checksInFlight[backendIP] {
+		return false
+	}
+	bm.checksInFlight[backendIP] = true
+	return true
+}
+
+func (bm *BackendManager) finishHealthCheck(backendIP string) {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	delete(bm.checksInFlight, backendIP)
+}
+
+// checkHealth performs the configured HTTP(S) health check against the backend and checks Kubernetes node status
+func (bm *BackendManager) checkHealth(ctx context.Context, healthCheck *HealthCheck, detail k8sutils.NodeDetails) {
+	healthCheckURL := healthCheck.URL(detail.IP, detail.Port)
 	log.Debugf("[Health Checker] Checking HTTP health for backend %s at %s.", detail.Name, healthCheckURL)
-	resp, err := http.Get(healthCheckURL)
-	if err != nil || resp.StatusCode != 200 {
+	if err := healthCheck.Check(ctx, detail.IP, detail.Port); err != nil {
 		log.Debugf("[Health Checker] HTTP health check failed for backend %s (%s): %v", detail.Name, healthCheckURL, err)
-		bm.setBackendHealth(detail.IP, false)
+		bm.setBackendHealth(backendKey(detail), false)
 		return
 	}
-	resp.Body.Close()
 	log.Debugf("[Health Checker] HTTP health check passed for backend %s (%s).", detail.Name, healthCheckURL)
 
-	// Check Kubernetes node state if cluster connection is available
-	if bm.clientset != nil {
-		node, err := bm.clientset.CoreV1().Nodes().Get(ctx, detail.Name, metav1.GetOptions{})
-		if err != nil {
-			log.Debugf("[Health Checker] Failed to retrieve node details for backend %s: %v", detail.Name, err)
-			bm.setBackendHealth(detail.IP, true) // Fallback to HTTP health check
-			return
-		}
+	// Check the Kubernetes node state if the pool follows the cluster's nodes. The state comes from the
+	// node informer's cache, so the checks add no load on the API server.
+	node, exists := bm.cachedNode(detail.Name)
+	if !exists {
+		log.Debugf("[Health Checker] Skipping Kubernetes node check for backend %s (%s), no node state is available.", detail.Name, detail.IP)
+		bm.setBackendHealth(backendKey(detail), true) // Fallback to HTTP health check
+		return
+	}
 
-		if k8sutils.IsNewNode(node) {
-			log.Debugf("[Health Checker] Backend %s (%s) is new and not ready for traffic.", detail.Name, detail.IP)
-			bm.setBackendHealth(detail.IP, false)
-			return
-		}
+	if k8sutils.IsNewNode(node) {
+		log.Debugf("[Health Checker] Backend %s (%s) is new and not ready for traffic.", detail.Name, detail.IP)
+		bm.setBackendHealth(backendKey(detail), false)
+		return
+	}
 
-		ready, err := k8sutils.IsNodeReady(ctx, bm.clientset, detail.Name)
-		if err != nil || !ready {
-			log.Debugf("[Health Checker] Kubernetes node readiness check failed for backend %s: %v", detail.Name, err)
-			bm.setBackendHealth(detail.IP, false)
-			return
-		}
+	if !k8sutils.IsNodeReady(node) {
+		log.Debugf("[Health Checker] Kubernetes node readiness check failed for backend %s.", detail.Name)
+		bm.setBackendHealth(backendKey(detail), false)
+		return
+	}
 
-		log.Debugf("[Health Checker] Backend %s (%s) is healthy and ready to handle traffic.", detail.Name, detail.IP)
-		bm.setBackendHealth(detail.IP, true)
-	} else {
-		log.Warnf("[Health Checker] Skipping Kubernetes node check for backend %s (%s) due to missing clientset.", detail.Name, detail.IP)
-		bm.setBackendHealth(detail.IP, true) // Fallback to HTTP health check
+	log.Debugf("[Health Checker] Backend %s (%s) is healthy and ready to handle traffic.", detail.Name, detail.IP)
+	bm.setBackendHealth(backendKey(detail), true)
+}
+
+// cachedNode returns the node of the given name from the node informer's cache, if the pool follows
+// the cluster's nodes and the node is known
+func (bm *BackendManager) cachedNode(name string) (*v1.Node, bool) {
+	bm.mutex.Lock()
+	informer := bm.nodeInformer
+	bm.mutex.Unlock()
+	if informer == nil {
+		return nil, false
 	}
+	// Nodes are cluster-scoped, so their cache key is the name
+	obj, exists, err := informer.GetStore().GetByKey(name)
+	if err != nil || !exists {
+		return nil, false
+	}
+	node, ok := obj.(*v1.Node)
+	return node, ok
 }
 
-// setBackendHealth updates the health status of a specific backend
+// setBackendHealth records a health check result and flips the backend's status once the
+// configured number of consecutive successes (rise) or failures (fall) has been reached
 func (bm *BackendManager) setBackendHealth(backendIP string, isHealthy bool) {
 	bm.healthMutex.Lock()
 	defer bm.healthMutex.Unlock()
 	oldStatus, exists := bm.healthMap[backendIP]
-	if exists {
-		log.Debugf("[Backend Manager] Backend %s health status changed from %t to %t.", backendIP, oldStatus, isHealthy)
+	if !exists {
+		// The backend was removed while its health check was in flight
+		log.Debugf("[Backend Manager] Ignoring health status for removed backend %s.", backendIP)
+		return
+	}
+
+	counter := bm.healthCounters[backendIP]
+	if isHealthy {
+		counter.successes++
+		counter.failures = 0
+	} else {
+		counter.failures++
+		counter.successes = 0
+	}
+	bm.healthCounters[backendIP] = counter
+
+	newStatus := oldStatus
+	if !oldStatus && counter.successes >= bm.healthCheck.cfg.Rise {
+		newStatus = true
+	} else if oldStatus && counter.failures >= bm.healthCheck.cfg.Fall {
+		newStatus = false
+	}
+
+	if newStatus != oldStatus {
+		log.Infof("[Backend Manager] Backend %s health status changed from %t to %t.", backendIP, oldStatus, newStatus)
 	} else {
-		log.Debugf("[Backend Manager] Setting health status for new backend %s to %t.", backendIP, isHealthy)
+		log.Debugf("[Backend Manager] Backend %s health status remains %t (successes: %d, failures: %d).", backendIP, newStatus, counter.successes, counter.failures)
 	}
-	bm.healthMap[backendIP] = isHealthy
+	bm.healthMap[backendIP] = newStatus
 }
 
 // IsBackendHealthy returns the health status of the specified backend
@@ -144,35 +545,43 @@ func (bm *BackendManager) IsBackendHealthy(backendIP string) bool {
 	if !exists {
 		log.Debugf("[Backend Manager] Backend %s not found in health map.", backendIP)
 	}
+	if exists && isHealthy && bm.isEjectedLocked(backendIP) {
+		log.Debugf("[Backend Manager] Backend %s is healthy but ejected by outlier detection.", backendIP)
+		return false
+	}
 	log.Debugf("[Backend Manager] Backend %s health status: %t.", backendIP, isHealthy)
 	return exists && isHealthy
 }
 
 // GetBackendByIP returns the IP of the backend associated with the given client IP
 func (bm *BackendManager) GetBackendByIP(ip string) string {
+	return bm.stickyBackend(ip, nil, bm.selectNewBackend)
+}
+
+// stickyBackend returns the client's existing healthy backend or binds it to one chosen by selectFn.
+// A binding to an excluded backend is moved, so retries rebind the client to the backend that works.
+func (bm *BackendManager) stickyBackend(ip string, exclude []string, selectFn func(ip string, exclude []string) string) string {
 	bm.mutex.Lock()
 	defer bm.mutex.Unlock()
-	backendIP, exists := bm.ipMap[ip]
-	if exists && bm.IsBackendHealthy(backendIP) {
+	backendIP, exists := bm.ipMap.get(ip)
+	if exists && bm.isCandidate(backendIP, exclude) {
 		log.Debugf("[Backend Manager] Found healthy backend %s for client IP %s.", backendIP, ip)
 		return backendIP
 	}
 
 	log.Warnf("[Backend Manager] No healthy backend found for client IP %s, reselecting.", ip)
-	newBackendIP := bm.selectNewBackend(ip)
+	newBackendIP := selectFn(ip, exclude)
 
-	bm.ipMap[ip] = newBackendIP
+	if newBackendIP != "" {
+		bm.ipMap.set(ip, newBackendIP)
+	}
 	return newBackendIP
 }
 
 // selectNewBackend performs a round-robin selection to find a healthy backend
-func (bm *BackendManager) selectNewBackend(ip string) string {
+func (bm *BackendManager) selectNewBackend(ip string, exclude []string) string {
 	log.Debugf("[Backend Manager] Selecting new backend for IP %s using round-robin method.", ip)
-	nodeNames := make([]string, 0, len(bm.backendList))
-
-	for name := range bm.backendList {
-		nodeNames = append(nodeNames, name)
-	}
+	nodeNames := bm.sortedBackendNamesLocked()
 
 	if len(nodeNames) == 0 {
 		log.Debugf("[Backend Manager] No backends available for selection.")
@@ -185,13 +594,28 @@ func (bm *BackendManager) selectNewBackend(ip string) string {
 		currentIndex := (atomic.LoadUint32(&bm.currentIndex) + i) % totalBackends
 		backendName := nodeNames[currentIndex]
 
-		if bm.IsBackendHealthy(bm.backendList[backendName].IP) {
+		if bm.isCandidate(backendKey(bm.backendList[backendName]), exclude) {
 			atomic.StoreUint32(&bm.currentIndex, (currentIndex+1)%totalBackends)
 			log.Debugf("[Backend Manager] New healthy backend assigned: %s for IP %s", backendName, ip)
-			return bm.backendList[backendName].IP
+			return backendKey(bm.backendList[backendName])
 		}
 	}
 
 	log.Debugf("[Backend Manager] No healthy backends available for IP %s after round-robin selection.", ip)
 	return ""
 }
+
+// isCandidate reports whether a backend is healthy and not excluded from selection
+func (bm *BackendManager) isCandidate(backendIP string, exclude []string) bool {
+	return !slices.Contains(exclude, backendIP) && bm.IsBackendHealthy(backendIP)
+}
+
+// sortedBackendNamesLocked returns the backend names in a stable order; callers must hold bm.mutex
+func (bm *BackendManager) sortedBackendNamesLocked() []string {
+	nodeNames := make([]string, 0, len(bm.backendList))
+	for name := range bm.backendList {
+		nodeNames = append(nodeNames, name)
+	}
+	sort.Strings(nodeNames)
+	return nodeNames
+}
@@ -0,0 +1,248 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testNode(name, ip string, nodeLabels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: ip}},
+		},
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// markHealthy puts backends into rotation without running health checks
+func markHealthy(bm *BackendManager, backendIPs ...string) {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	for _, backendIP := range backendIPs {
+		bm.healthMap[backendIP] = true
+	}
+}
+
+// maglevKey returns the backend names the consistent-hash table was last built for
+func maglevKey(bm *BackendManager) string {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	if bm.maglev == nil {
+		return ""
+	}
+	return bm.maglev.key
+}
+
+func TestWatchNodes(t *testing.T) {
+	worker := map[string]string{"role": "worker"}
+	client := fake.NewSimpleClientset(
+		testNode("a", "10.0.0.1", worker),
+		testNode("b", "10.0.0.2", worker),
+		testNode("control", "10.0.0.9", nil),
+	)
+	informer := informers.NewSharedInformerFactory(client, 0).Core().V1().Nodes().Informer()
+	bm := NewManager(nil, time.Second, nil, 0, 0, config.OutlierDetectionConfig{})
+	if err := bm.WatchNodes(informer, labels.SelectorFromSet(worker)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		t.Fatal("informer did not sync")
+	}
+
+	backendIP := func(name string) string {
+		bm.mutex.Lock()
+		defer bm.mutex.Unlock()
+		detail, exists := bm.backendList[name]
+		if !exists {
+			return ""
+		}
+		return backendKey(detail)
+	}
+	tracked := func(ip string) (health, sticky, outlier bool) {
+		bm.mutex.Lock()
+		defer bm.mutex.Unlock()
+		bm.healthMutex.Lock()
+		defer bm.healthMutex.Unlock()
+		_, health = bm.healthMap[ip]
+		_, outlier = bm.outliers[ip]
+		for _, binding := range bm.ipMap.list() {
+			sticky = sticky || binding.Backend == ip
+		}
+		return health, sticky, outlier
+	}
+	nodes := client.CoreV1().Nodes()
+
+	waitFor(t, "nodes a and b", func() bool { return backendIP("a") != "" && backendIP("b") != "" })
+	if ip := backendIP("control"); ip != "" {
+		t.Fatalf("node control without the selected labels was added as %s", ip)
+	}
+
+	// Bring both nodes into rotation and give them client bindings, outlier state and a Maglev table
+	markHealthy(bm, "10.0.0.1", "10.0.0.2")
+	bm.mutex.Lock()
+	bm.ipMap.set("192.0.2.1", "10.0.0.1")
+	bm.ipMap.set("192.0.2.2", "10.0.0.2")
+	bm.mutex.Unlock()
+	bm.healthMutex.Lock()
+	bm.outliers["10.0.0.1"] = &outlierState{consecutiveErrors: 1}
+	bm.outliers["10.0.0.2"] = &outlierState{consecutiveErrors: 1}
+	bm.healthMutex.Unlock()
+	bm.SelectBackend("192.0.2.3", ConsistentHash, nil)
+	if key := maglevKey(bm); key != "a,b" {
+		t.Fatalf("consistent-hash table built for %q, want a,b", key)
+	}
+
+	t.Run("update", func(t *testing.T) {
+		if _, err := nodes.Update(ctx, testNode("a", "10.0.0.11", worker), metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "new address of node a", func() bool { return backendIP("a") == "10.0.0.11" })
+		if health, sticky, outlier := tracked("10.0.0.1"); health || sticky || outlier {
+			t.Errorf("old address still tracked: health %t, sticky %t, outlier %t", health, sticky, outlier)
+		}
+		if health, _, _ := tracked("10.0.0.11"); !health {
+			t.Errorf("new address has no health entry")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := nodes.Delete(ctx, "b", metav1.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "removal of node b", func() bool { return backendIP("b") == "" })
+		if health, sticky, outlier := tracked("10.0.0.2"); health || sticky || outlier {
+			t.Errorf("deleted node still tracked: health %t, sticky %t, outlier %t", health, sticky, outlier)
+		}
+		markHealthy(bm, "10.0.0.11")
+		for _, client := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"} {
+			if got := bm.SelectBackend(client, ConsistentHash, nil); got != "10.0.0.11" {
+				t.Errorf("SelectBackend(%s) = %s, want 10.0.0.11", client, got)
+			}
+		}
+		if key := maglevKey(bm); key != "a" {
+			t.Errorf("consistent-hash table built for %q, want a", key)
+		}
+	})
+
+	t.Run("labels no longer match", func(t *testing.T) {
+		if _, err := nodes.Update(ctx, testNode("a", "10.0.0.11", nil), metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "removal of node a", func() bool { return backendIP("a") == "" })
+	})
+
+	t.Run("add", func(t *testing.T) {
+		if _, err := nodes.Create(ctx, testNode("c", "10.0.0.3", worker), metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "node c", func() bool { return backendIP("c") == "10.0.0.3" })
+		if healthy := bm.IsBackendHealthy("10.0.0.3"); healthy {
+			t.Errorf("new node is in rotation before its first health check")
+		}
+	})
+
+	if err := bm.UnwatchNodes(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nodes.Create(ctx, testNode("d", "10.0.0.4", worker), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ip := backendIP("d"); ip != "" {
+		t.Errorf("node d added after UnwatchNodes as %s", ip)
+	}
+}
+
+func TestSetNodeSelector(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		testNode("a", "10.0.0.1", map[string]string{"role": "worker", "pool": "edge"}),
+		testNode("b", "10.0.0.2", map[string]string{"role": "worker"}),
+		testNode("control", "10.0.0.9", nil),
+	)
+	informer := informers.NewSharedInformerFactory(client, 0).Core().V1().Nodes().Informer()
+	bm := NewManager(nil, time.Second, nil, 0, 0, config.OutlierDetectionConfig{})
+	if err := bm.WatchNodes(informer, labels.SelectorFromSet(labels.Set{"role": "worker"})); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		t.Fatal("informer did not sync")
+	}
+	backends := func() string {
+		bm.mutex.Lock()
+		defer bm.mutex.Unlock()
+		return strings.Join(bm.sortedBackendNamesLocked(), ",")
+	}
+	waitFor(t, "nodes a and b", func() bool { return backends() == "a,b" })
+	markHealthy(bm, "10.0.0.1", "10.0.0.2")
+	bm.GetBackendByIP("192.0.2.1")
+
+	// Narrowing the selector drops b; a keeps its health and client binding
+	bm.SetNodeSelector(labels.SelectorFromSet(labels.Set{"role": "worker", "pool": "edge"}))
+	if got := backends(); got != "a" {
+		t.Errorf("backends = %s, want a", got)
+	}
+	if !bm.IsBackendHealthy("10.0.0.1") {
+		t.Error("remaining backend left rotation")
+	}
+	if got := stickyClients(bm.ipMap); got != "192.0.2.1" {
+		t.Errorf("bindings = %s, want 192.0.2.1", got)
+	}
+
+	// Widening it adds the nodes already known to the informer, which start out unhealthy
+	bm.SetNodeSelector(labels.Everything())
+	if got := backends(); got != "a,b,control" {
+		t.Errorf("backends = %s, want a,b,control", got)
+	}
+	if bm.IsBackendHealthy("10.0.0.2") {
+		t.Error("re-added backend is in rotation before its first health check")
+	}
+}
+
+func TestSetHealthCheckInterval(t *testing.T) {
+	bm := NewManager(nil, time.Hour, nil, time.Minute, 0, config.OutlierDetectionConfig{})
+	clock := newFakeClock()
+	bm.ipMap.now = clock.Now
+	bm.ipMap.set("192.0.2.1", "10.0.0.1")
+	clock.Advance(2 * time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bm.HealthChecker(ctx)
+
+	// The running health checker switches to the new interval without waiting out the old one
+	bm.SetHealthCheckInterval(10 * time.Millisecond)
+	waitFor(t, "a scheduled pass expiring the binding", func() bool {
+		bm.mutex.Lock()
+		defer bm.mutex.Unlock()
+		return bm.ipMap.lru.Len() == 0
+	})
+}
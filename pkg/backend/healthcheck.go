@@ -0,0 +1,165 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/proxyproto"
+)
+
+// maxHealthCheckBody limits how much of a response body is read for body matching
+const maxHealthCheckBody = 64 * 1024
+
+// HealthCheck probes a backend over HTTP or HTTPS according to a HealthCheckConfig
+type HealthCheck struct {
+	cfg          config.HealthCheckConfig
+	backendPort  int // Port probed when cfg.Port is 0 and the backend has no port of its own
+	statusRanges []config.StatusRange
+	client       *http.Client
+}
+
+// NewHealthCheck builds a HealthCheck, loading the CA bundle if one is configured. With a health check
+// port of 0 every backend is probed on the port its traffic goes to: its own port, or backendPort.
+func NewHealthCheck(cfg config.HealthCheckConfig, backendPort int) (*HealthCheck, error) {
+	statusRanges, err := config.ParseStatusRanges(cfg.ExpectedStatus)
+	if err != nil {
+		return nil, fmt.Errorf("expected status: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.SNI,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = cfg.Host
+	}
+	if cfg.CAFile != "" {
+		caData, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read health check CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in health check CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: true, // Every probe opens a fresh connection like a real client would
+	}
+	if cfg.ProxyProtocol != "" {
+		transport.DialContext = proxyProtocolDialer(cfg.ProxyProtocol)
+	}
+
+	return &HealthCheck{
+		cfg:          cfg,
+		backendPort:  backendPort,
+		statusRanges: statusRanges,
+		client: &http.Client{
+			Transport: transport,
+			// Report redirects as-is so they can be matched against the expected status
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// proxyProtocolDialer returns a dial function that announces every probe connection as a LOCAL
+// connection, for backends that require a PROXY protocol header on their health check port
+func proxyProtocolDialer(version string) func(ctx context.Context, network, address string) (net.Conn, error) {
+	header := &proxyproto.Header{Version: config.ProxyProtocolVersion(version), Local: true}
+	var dialer net.Dialer
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetWriteDeadline(deadline)
+		}
+		if _, err := header.WriteTo(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("send PROXY protocol header: %w", err)
+		}
+		conn.SetWriteDeadline(time.Time{})
+		return conn, nil
+	}
+}
+
+// URL returns the URL probed for the given backend IP and the backend's own port, 0 if it has none
+func (hc *HealthCheck) URL(backendIP string, backendPort int) string {
+	port := hc.cfg.Port
+	if port == 0 {
+		port = backendPort
+	}
+	if port == 0 {
+		port = hc.backendPort
+	}
+	return hc.cfg.Scheme + "://" + net.JoinHostPort(backendIP, strconv.Itoa(port)) + hc.cfg.Path
+}
+
+// Check probes the backend and returns an error describing why it is unhealthy
+func (hc *HealthCheck) Check(ctx context.Context, backendIP string, backendPort int) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.cfg.Timeout.Duration)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hc.URL(backendIP, backendPort), nil)
+	if err != nil {
+		return err
+	}
+	if hc.cfg.Host != "" {
+		req.Host = hc.cfg.Host
+	}
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !hc.statusAccepted(resp.StatusCode) {
+		return fmt.Errorf("unexpected status code %d, expected %s", resp.StatusCode, hc.cfg.ExpectedStatus)
+	}
+
+	if hc.cfg.BodyMatch != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBody))
+		if err != nil {
+			return fmt.Errorf("read response body: %w", err)
+		}
+		if !strings.Contains(string(body), hc.cfg.BodyMatch) {
+			return fmt.Errorf("response body does not contain %q", hc.cfg.BodyMatch)
+		}
+	}
+	return nil
+}
+
+// jitter returns a random delay within the configured jitter window
+func (hc *HealthCheck) jitter() time.Duration {
+	if hc.cfg.Jitter.Duration <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(hc.cfg.Jitter.Duration)))
+}
+
+func (hc *HealthCheck) statusAccepted(statusCode int) bool {
+	for _, r := range hc.statusRanges {
+		if statusCode >= r.Min && statusCode <= r.Max {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+)
+
+func testHealthCheckConfig(port int) config.HealthCheckConfig {
+	return config.HealthCheckConfig{
+		Scheme:         "http",
+		Port:           port,
+		Path:           "/healthz",
+		ExpectedStatus: "200",
+		Timeout:        config.Seconds(1),
+	}
+}
+
+func TestHealthCheckURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		port        int
+		backendPort int
+		memberPort  int
+		want        string
+	}{
+		{name: "fixed port", port: 10254, backendPort: 443, memberPort: 8443, want: "http://10.0.0.1:10254/healthz"},
+		{name: "member port", port: 0, backendPort: 443, memberPort: 8443, want: "http://10.0.0.1:8443/healthz"},
+		{name: "backend port", port: 0, backendPort: 443, want: "http://10.0.0.1:443/healthz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc, err := NewHealthCheck(testHealthCheckConfig(tt.port), tt.backendPort)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hc.URL("10.0.0.1", tt.memberPort); got != tt.want {
+				t.Errorf("URL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthCheckMemberPorts(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthyPort := serverPort(t, healthy)
+	failingPort := serverPort(t, failing)
+
+	tests := []struct {
+		name       string
+		port       int
+		memberPort int
+		wantErr    bool
+	}{
+		{name: "own port healthy", port: 0, memberPort: healthyPort},
+		{name: "own port failing", port: 0, memberPort: failingPort, wantErr: true},
+		{name: "shared port ignores member port", port: healthyPort, memberPort: failingPort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc, err := NewHealthCheck(testHealthCheckConfig(tt.port), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = hc.Check(ctx, "127.0.0.1", tt.memberPort)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func serverPort(t *testing.T, server *httptest.Server) int {
+	t.Helper()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return portNumber
+}
+
+func TestSetBackendHealthThresholds(t *testing.T) {
+	tests := []struct {
+		name    string
+		rise    int
+		fall    int
+		results string // One check result per character: p passed, f failed
+		want    string // Health after each result: + in rotation, - out of rotation
+	}{
+		{name: "rise and fall of 1", rise: 1, fall: 1, results: "pfpf", want: "+-+-"},
+		{name: "rise of 3", rise: 3, fall: 1, results: "pppp", want: "--++"},
+		{name: "fall of 3", rise: 1, fall: 3, results: "pffff", want: "+++--"},
+		{name: "failure resets rise", rise: 2, fall: 2, results: "pfpp", want: "---+"},
+		{name: "success resets fall", rise: 1, fall: 2, results: "pfpfpff", want: "++++++-"},
+		{name: "flapping stays out", rise: 2, fall: 2, results: "pfpfpfpf", want: "--------"},
+		{name: "flapping stays in", rise: 2, fall: 2, results: "ppfpfpfp", want: "-+++++++"},
+		{name: "recovers after fall", rise: 2, fall: 2, results: "ppffpp", want: "-++--+"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testHealthCheckConfig(0)
+			cfg.Rise, cfg.Fall = tt.rise, tt.fall
+			hc, err := NewHealthCheck(cfg, 80)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bm := NewManager(nil, time.Second, hc, 0, 0, config.OutlierDetectionConfig{})
+			bm.AddBackend(k8sutils.NodeDetails{Name: "a", IP: "10.0.0.1"})
+
+			var got strings.Builder
+			for _, result := range tt.results {
+				bm.setBackendHealth("10.0.0.1", result == 'p')
+				if bm.IsBackendHealthy("10.0.0.1") {
+					got.WriteByte('+')
+				} else {
+					got.WriteByte('-')
+				}
+			}
+			if got.String() != tt.want {
+				t.Errorf("health = %s, want %s", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSetBackendHealthRemovedBackend(t *testing.T) {
+	hc, err := NewHealthCheck(testHealthCheckConfig(0), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := NewManager(nil, time.Second, hc, 0, 0, config.OutlierDetectionConfig{})
+	bm.AddBackend(k8sutils.NodeDetails{Name: "a", IP: "10.0.0.1"})
+	bm.RemoveBackend("a")
+
+	// A check that was in flight when the backend was removed must not bring it back
+	bm.setBackendHealth("10.0.0.1", true)
+	if _, exists := bm.healthMap["10.0.0.1"]; exists {
+		t.Error("health result of a removed backend was recorded")
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	const timeout = 100 * time.Millisecond
+	cfg := testHealthCheckConfig(serverPort(t, slow))
+	cfg.Timeout = config.Duration{Duration: timeout}
+	cfg.Rise, cfg.Fall = 1, 1
+	hc, err := NewHealthCheck(cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	err = hc.Check(context.Background(), "127.0.0.1", 0)
+	if elapsed := time.Since(started); err == nil || elapsed > timeout+time.Second {
+		t.Fatalf("Check() = %v after %s, want a timeout after %s", err, elapsed, timeout)
+	}
+
+	// A check that times out counts as a failure
+	bm := NewManager(nil, time.Second, hc, 0, 0, config.OutlierDetectionConfig{})
+	detail := k8sutils.NodeDetails{Name: "a", IP: "127.0.0.1"}
+	bm.AddBackend(detail)
+	markHealthy(bm, "127.0.0.1")
+	bm.checkHealth(context.Background(), hc, detail)
+	if bm.IsBackendHealthy("127.0.0.1") {
+		t.Error("backend still in rotation after its health check timed out")
+	}
+}
@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"hash/fnv"
+	"slices"
+)
+
+// maglevTableSize is the number of lookup table slots; it must be prime and much larger than the backend count
+const maglevTableSize = 65537
+
+// maglevTable is a Maglev consistent-hashing lookup table (Eisenbud et al., NSDI 2016).
+// Tables built from the same backend names are identical, so every replica maps a client to the
+// same backend, and removing a backend causes minimal disruption: almost all clients of the other
+// backends keep their backend. Excluding a backend in lookup moves none of them.
+type maglevTable struct {
+	key     string   // Names of the backends the table was built from, used to detect changes
+	entries []string // Backend name for every slot
+}
+
+// newMaglevTable populates the lookup table for the given backend names, which must be sorted
+func newMaglevTable(key string, names []string) *maglevTable {
+	table := &maglevTable{key: key, entries: make([]string, maglevTableSize)}
+	if len(names) == 0 {
+		return table
+	}
+
+	offsets := make([]uint64, len(names))
+	skips := make([]uint64, len(names))
+	next := make([]uint64, len(names))
+	for i, name := range names {
+		offsets[i] = hashString("offset", name) % maglevTableSize
+		skips[i] = hashString("skip", name)%(maglevTableSize-1) + 1
+	}
+
+	filled := 0
+	for {
+		for i, name := range names {
+			// Walk this backend's permutation until it finds a free slot
+			slot := (offsets[i] + next[i]*skips[i]) % maglevTableSize
+			for table.entries[slot] != "" {
+				next[i]++
+				slot = (offsets[i] + next[i]*skips[i]) % maglevTableSize
+			}
+			table.entries[slot] = name
+			next[i]++
+			filled++
+			if filled == maglevTableSize {
+				return table
+			}
+		}
+	}
+}
+
+// lookup returns the backend name responsible for the given key. When that backend is excluded the
+// following slots are walked, so retries for the same key still land on the same fallback backend.
+func (t *maglevTable) lookup(key string, exclude []string) string {
+	slot := hashString("", key) % maglevTableSize
+	for i := uint64(0); i < maglevTableSize; i++ {
+		name := t.entries[(slot+i)%maglevTableSize]
+		if !slices.Contains(exclude, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// hashString returns the 64-bit FNV-1a hash of s, prefixed with seed to derive independent hashes
+func hashString(seed, s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	h.Write([]byte{0})
+	h.Write([]byte(s))
+	return h.Sum64()
+}
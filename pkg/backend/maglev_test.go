@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMaglevTable(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+	}{
+		{name: "empty", names: nil},
+		{name: "single", names: []string{"a"}},
+		{name: "three", names: []string{"a", "b", "c"}},
+		{name: "ten", names: []string{"n0", "n1", "n2", "n3", "n4", "n5", "n6", "n7", "n8", "n9"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := strings.Join(tt.names, ",")
+			table := newMaglevTable(key, tt.names)
+			if len(table.entries) != maglevTableSize {
+				t.Fatalf("table has %d entries, want %d", len(table.entries), maglevTableSize)
+			}
+
+			slots := make(map[string]int)
+			for _, name := range table.entries {
+				slots[name]++
+			}
+			if len(tt.names) == 0 {
+				if slots[""] != maglevTableSize {
+					t.Fatalf("empty table has %d filled slots", maglevTableSize-slots[""])
+				}
+				if got := table.lookup("10.0.0.1", nil); got != "" {
+					t.Fatalf("lookup on empty table = %q, want none", got)
+				}
+				return
+			}
+
+			// Every slot is filled and the backends get an almost equal share
+			if slots[""] != 0 {
+				t.Fatalf("%d slots left empty", slots[""])
+			}
+			share := maglevTableSize / len(tt.names)
+			for _, name := range tt.names {
+				if slots[name] < share-1 || slots[name] > share+1 {
+					t.Errorf("backend %s has %d slots, want %d±1", name, slots[name], share)
+				}
+			}
+
+			// Tables built from the same names are identical
+			again := newMaglevTable(key, tt.names)
+			for i := range table.entries {
+				if table.entries[i] != again.entries[i] {
+					t.Fatalf("slot %d differs between builds: %q and %q", i, table.entries[i], again.entries[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMaglevLookupExclude(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	table := newMaglevTable(strings.Join(names, ","), names)
+	tests := []struct {
+		name    string
+		exclude []string
+	}{
+		{name: "none"},
+		{name: "one", exclude: []string{"a"}},
+		{name: "two", exclude: []string{"a", "b"}},
+		{name: "all", exclude: []string{"a", "b", "c"}},
+		{name: "unknown", exclude: []string{"x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				client := fmt.Sprintf("10.0.%d.%d", i/250, i%250)
+				got := table.lookup(client, tt.exclude)
+				if len(tt.exclude) == len(names) {
+					if got != "" {
+						t.Fatalf("lookup(%s) = %q with every backend excluded", client, got)
+					}
+					continue
+				}
+				for _, excluded := range tt.exclude {
+					if got == excluded {
+						t.Fatalf("lookup(%s) returned excluded backend %s", client, got)
+					}
+				}
+				if again := table.lookup(client, tt.exclude); again != got {
+					t.Fatalf("lookup(%s) is not stable: %q then %q", client, got, again)
+				}
+			}
+		})
+	}
+}
+
+func TestMaglevRemoveBackend(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e"}
+	before := newMaglevTable(strings.Join(names, ","), names)
+	remaining := []string{"a", "b", "d", "e"}
+	after := newMaglevTable(strings.Join(remaining, ","), remaining)
+
+	const clients = 10000
+	moved := 0
+	for i := 0; i < clients; i++ {
+		client := fmt.Sprintf("10.%d.%d.%d", i/65536, i/256%256, i%256)
+		old, current := before.lookup(client, nil), after.lookup(client, nil)
+		if current == "c" {
+			t.Fatalf("client %s still maps to the removed backend", client)
+		}
+		if old != "c" && old != current {
+			moved++
+		}
+	}
+	// Rebuilding the table trades perfect consistency for balance, so a small share of other clients may
+	// move when a backend is removed for good; backends out of rotation are excluded instead, see below
+	if moved > clients/50 {
+		t.Errorf("%d of %d clients of the remaining backends moved, want at most %d", moved, clients, clients/50)
+	}
+}
+
+func TestMaglevExcludedBackend(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e"}
+	table := newMaglevTable(strings.Join(names, ","), names)
+
+	const clients = 10000
+	for i := 0; i < clients; i++ {
+		client := fmt.Sprintf("10.%d.%d.%d", i/65536, i/256%256, i%256)
+		old, current := table.lookup(client, nil), table.lookup(client, []string{"c"})
+		if current == "c" {
+			t.Fatalf("client %s still maps to the excluded backend", client)
+		}
+		if old != "c" && old != current {
+			t.Fatalf("client %s of backend %s moved to %s", client, old, current)
+		}
+	}
+}
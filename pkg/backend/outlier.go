@@ -0,0 +1,99 @@
+package backend
+
+import "time"
+
+// outlierState tracks passive health signals for a backend, modelled on Envoy's outlier detection
+type outlierState struct {
+	consecutiveErrors int
+	ejections         int       // Number of recent ejections, multiplies the ejection time
+	ejectedUntil      time.Time // Zero when the backend is not ejected
+	lastChange        time.Time // End of the last ejection or last multiplier decay, used to decay the multiplier
+}
+
+// ReportSuccess records a successful connection to the backend
+func (bm *BackendManager) ReportSuccess(backendIP string) {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	if state, exists := bm.outliers[backendIP]; exists {
+		state.consecutiveErrors = 0
+	}
+}
+
+// ReportFailure records a failed connection to the backend (dial error, timeout or reset) and ejects
+// it from rotation once the consecutive error threshold is reached
+func (bm *BackendManager) ReportFailure(backendIP string, err error) {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	cfg := bm.outlierDetection
+	if cfg.ConsecutiveErrors <= 0 {
+		return
+	}
+	if _, exists := bm.healthMap[backendIP]; !exists {
+		return
+	}
+	state, exists := bm.outliers[backendIP]
+	if !exists {
+		state = &outlierState{}
+		bm.outliers[backendIP] = state
+	}
+
+	now := bm.now()
+	state.consecutiveErrors++
+	log.Debugf("[Outlier Detection] Backend %s failed (%d consecutive): %v", backendIP, state.consecutiveErrors, err)
+	if state.consecutiveErrors < cfg.ConsecutiveErrors || now.Before(state.ejectedUntil) {
+		return
+	}
+	if !bm.canEjectLocked(now) {
+		log.Warnf("[Outlier Detection] Not ejecting backend %s, maximum ejection percentage reached.", backendIP)
+		return
+	}
+
+	state.ejections++
+	ejectionTime := cfg.BaseEjectionTime.Duration * time.Duration(state.ejections)
+	if ejectionTime > cfg.MaxEjectionTime.Duration {
+		ejectionTime = cfg.MaxEjectionTime.Duration
+	}
+	state.ejectedUntil = now.Add(ejectionTime)
+	state.lastChange = state.ejectedUntil // Only time back in rotation counts towards decay
+	state.consecutiveErrors = 0
+	log.Warnf("[Outlier Detection] Ejecting backend %s for %s after %d consecutive failures: %v", backendIP, ejectionTime, cfg.ConsecutiveErrors, err)
+}
+
+// canEjectLocked reports whether one more backend may be ejected without exceeding the maximum
+// ejection percentage. Like Envoy, one backend may always be ejected, so outlier detection also
+// works for pools too small for the percentage to allow one. Callers must hold bm.healthMutex.
+func (bm *BackendManager) canEjectLocked(now time.Time) bool {
+	ejected := 0
+	for _, state := range bm.outliers {
+		if now.Before(state.ejectedUntil) {
+			ejected++
+		}
+	}
+	return ejected == 0 || (ejected+1)*100 <= bm.outlierDetection.MaxEjectionPercent*len(bm.healthMap)
+}
+
+// isEjectedLocked reports whether the backend is currently ejected; callers must hold bm.healthMutex
+func (bm *BackendManager) isEjectedLocked(backendIP string) bool {
+	state, exists := bm.outliers[backendIP]
+	return exists && bm.now().Before(state.ejectedUntil)
+}
+
+// decayOutliers lowers the ejection multiplier of backends that stayed in rotation for a full base ejection time
+func (bm *BackendManager) decayOutliers() {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	now := bm.now()
+	for backendIP, state := range bm.outliers {
+		if now.Before(state.ejectedUntil) || now.Sub(state.lastChange) < bm.outlierDetection.BaseEjectionTime.Duration {
+			continue
+		}
+		if state.ejections > 0 {
+			state.ejections--
+			state.lastChange = now
+			log.Debugf("[Outlier Detection] Backend %s ejection multiplier decayed to %d.", backendIP, state.ejections)
+		}
+		if state.ejections == 0 && state.consecutiveErrors == 0 {
+			delete(bm.outliers, backendIP)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+)
+
+var errTestDial = errors.New("connection refused")
+
+func testOutlierDetection() config.OutlierDetectionConfig {
+	return config.OutlierDetectionConfig{
+		ConsecutiveErrors:  3,
+		BaseEjectionTime:   config.Seconds(30),
+		MaxEjectionTime:    config.Seconds(100),
+		MaxEjectionPercent: 50,
+	}
+}
+
+// newOutlierManager returns a manager with the given number of healthy backends at 10.0.0.1, 10.0.0.2, ...
+// whose outlier detection runs on clock
+func newOutlierManager(backends int, od config.OutlierDetectionConfig, clock *fakeClock) *BackendManager {
+	bm := NewManager(nil, time.Second, nil, 0, 0, od)
+	bm.now = clock.Now
+	for i := 1; i <= backends; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		bm.AddBackend(k8sutils.NodeDetails{Name: fmt.Sprintf("n%d", i), IP: ip})
+		markHealthy(bm, ip)
+	}
+	return bm
+}
+
+// ejectionLeft returns how long the backend stays ejected, 0 if it is in rotation
+func ejectionLeft(bm *BackendManager, backendIP string) time.Duration {
+	bm.healthMutex.Lock()
+	defer bm.healthMutex.Unlock()
+	state, exists := bm.outliers[backendIP]
+	if !exists || !bm.now().Before(state.ejectedUntil) {
+		return 0
+	}
+	return state.ejectedUntil.Sub(bm.now())
+}
+
+func TestOutlierEjection(t *testing.T) {
+	tests := []struct {
+		name              string
+		consecutiveErrors int
+		events            string // Space separated: f failure, s success, a duration advances the clock
+		wantLeft          time.Duration
+	}{
+		{name: "below threshold", events: "f f"},
+		{name: "threshold", events: "f f f", wantLeft: 30 * time.Second},
+		{name: "success resets count", events: "f f s f f"},
+		{name: "failures spread out", events: "f 1h f 1h f", wantLeft: 30 * time.Second},
+		{name: "disabled", consecutiveErrors: -1, events: "f f f f f f"},
+		{name: "ejection ends", events: "f f f 30s"},
+		{name: "ejection running", events: "f f f 29s", wantLeft: time.Second},
+		{name: "failures while ejected do not extend it", events: "f f f 10s f f f", wantLeft: 20 * time.Second},
+		{name: "success while ejected does not end it", events: "f f f 10s s", wantLeft: 20 * time.Second},
+		{name: "second ejection is longer", events: "f f f 30s f f f", wantLeft: 60 * time.Second},
+		{name: "third ejection", events: "f f f 30s f f f 60s f f f", wantLeft: 90 * time.Second},
+		{name: "capped at max ejection time", events: "f f f 30s f f f 60s f f f 90s f f f", wantLeft: 100 * time.Second},
+		{name: "count restarts after ejection", events: "f f f 30s f f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			od := testOutlierDetection()
+			if tt.consecutiveErrors != 0 {
+				od.ConsecutiveErrors = max(tt.consecutiveErrors, 0)
+			}
+			clock := newFakeClock()
+			bm := newOutlierManager(2, od, clock)
+
+			for _, event := range strings.Fields(tt.events) {
+				switch event {
+				case "f":
+					bm.ReportFailure("10.0.0.1", errTestDial)
+				case "s":
+					bm.ReportSuccess("10.0.0.1")
+				default:
+					d, err := time.ParseDuration(event)
+					if err != nil {
+						t.Fatal(err)
+					}
+					clock.Advance(d)
+				}
+			}
+
+			if got := ejectionLeft(bm, "10.0.0.1"); got != tt.wantLeft {
+				t.Errorf("ejected for %s, want %s", got, tt.wantLeft)
+			}
+			if healthy := bm.IsBackendHealthy("10.0.0.1"); healthy != (tt.wantLeft == 0) {
+				t.Errorf("IsBackendHealthy() = %t while ejected for %s", healthy, tt.wantLeft)
+			}
+			if healthy := bm.IsBackendHealthy("10.0.0.2"); !healthy {
+				t.Error("other backend left rotation")
+			}
+		})
+	}
+}
+
+func TestOutlierDecay(t *testing.T) {
+	tests := []struct {
+		name     string
+		quiet    []time.Duration // Periods in rotation after the second ejection, each followed by a decay pass
+		wantLeft time.Duration   // Length of the next ejection
+	}{
+		{name: "no decay", quiet: nil, wantLeft: 90 * time.Second},
+		{name: "too short to decay", quiet: []time.Duration{29 * time.Second}, wantLeft: 90 * time.Second},
+		{name: "one step", quiet: []time.Duration{30 * time.Second}, wantLeft: 60 * time.Second},
+		{name: "one step per base ejection time", quiet: []time.Duration{30 * time.Second, 10 * time.Second}, wantLeft: 60 * time.Second},
+		{name: "two steps", quiet: []time.Duration{30 * time.Second, 30 * time.Second}, wantLeft: 30 * time.Second},
+		{name: "fully decayed", quiet: []time.Duration{30 * time.Second, 30 * time.Second, 30 * time.Second}, wantLeft: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			bm := newOutlierManager(2, testOutlierDetection(), clock)
+			eject := func() {
+				for i := 0; i < 3; i++ {
+					bm.ReportFailure("10.0.0.1", errTestDial)
+				}
+			}
+			eject()
+			clock.Advance(30 * time.Second)
+			eject()
+			clock.Advance(60 * time.Second)
+
+			// Decay passes while ejected or right after do nothing
+			bm.decayOutliers()
+			for _, quiet := range tt.quiet {
+				clock.Advance(quiet)
+				bm.decayOutliers()
+			}
+			eject()
+			if got := ejectionLeft(bm, "10.0.0.1"); got != tt.wantLeft {
+				t.Errorf("next ejection lasts %s, want %s", got, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestOutlierStateForgotten(t *testing.T) {
+	clock := newFakeClock()
+	bm := newOutlierManager(2, testOutlierDetection(), clock)
+	for i := 0; i < 3; i++ {
+		bm.ReportFailure("10.0.0.1", errTestDial)
+	}
+	clock.Advance(60 * time.Second)
+	bm.decayOutliers()
+	if _, exists := bm.outliers["10.0.0.1"]; exists {
+		t.Error("state of a fully decayed backend is kept")
+	}
+
+	// Failures of unknown or removed backends are ignored
+	bm.ReportFailure("10.0.0.9", errTestDial)
+	bm.RemoveBackend("n2")
+	bm.ReportFailure("10.0.0.2", errTestDial)
+	if len(bm.outliers) != 0 {
+		t.Errorf("outlier state kept for %d unknown backends", len(bm.outliers))
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	tests := []struct {
+		name        string
+		backends    int
+		percent     int
+		failing     int
+		wantEjected int
+	}{
+		{name: "single backend", backends: 1, percent: 50, failing: 1, wantEjected: 1},
+		{name: "half of two", backends: 2, percent: 50, failing: 2, wantEjected: 1},
+		{name: "half of four", backends: 4, percent: 50, failing: 4, wantEjected: 2},
+		{name: "rounded down", backends: 5, percent: 50, failing: 5, wantEjected: 2},
+		{name: "below one backend", backends: 4, percent: 10, failing: 4, wantEjected: 1},
+		{name: "zero percent still ejects one", backends: 3, percent: 0, failing: 3, wantEjected: 1},
+		{name: "all", backends: 3, percent: 100, failing: 3, wantEjected: 3},
+		{name: "under the cap", backends: 10, percent: 50, failing: 3, wantEjected: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			od := testOutlierDetection()
+			od.MaxEjectionPercent = tt.percent
+			clock := newFakeClock()
+			bm := newOutlierManager(tt.backends, od, clock)
+
+			for i := 1; i <= tt.failing; i++ {
+				for j := 0; j < od.ConsecutiveErrors; j++ {
+					bm.ReportFailure(fmt.Sprintf("10.0.0.%d", i), errTestDial)
+				}
+			}
+			if got := tt.backends - len(bm.HealthyBackends()); got != tt.wantEjected {
+				t.Errorf("%d backends ejected, want %d", got, tt.wantEjected)
+			}
+
+			// Once the ejections end, the backends held back by the cap can be ejected
+			if tt.failing > tt.wantEjected {
+				clock.Advance(30 * time.Second)
+				bm.ReportFailure(fmt.Sprintf("10.0.0.%d", tt.failing), errTestDial)
+				if ejectionLeft(bm, fmt.Sprintf("10.0.0.%d", tt.failing)) == 0 {
+					t.Error("backend held back by the cap not ejected after the other ejections ended")
+				}
+			}
+		})
+	}
+}
+
+func TestSetOutlierDetection(t *testing.T) {
+	clock := newFakeClock()
+	bm := newOutlierManager(2, testOutlierDetection(), clock)
+	for i := 0; i < 3; i++ {
+		bm.ReportFailure("10.0.0.1", errTestDial)
+	}
+
+	// A running ejection keeps its end time; the new threshold and ejection time apply to the next failures
+	od := testOutlierDetection()
+	od.ConsecutiveErrors = 1
+	od.BaseEjectionTime = config.Seconds(10)
+	bm.SetOutlierDetection(od)
+	if got := ejectionLeft(bm, "10.0.0.1"); got != 30*time.Second {
+		t.Errorf("running ejection left = %s, want 30s", got)
+	}
+	bm.ReportFailure("10.0.0.2", errTestDial)
+	if got := ejectionLeft(bm, "10.0.0.2"); got != 0 {
+		t.Errorf("second backend ejected for %s while the cap allows only one", got)
+	}
+	clock.Advance(30 * time.Second)
+	bm.ReportFailure("10.0.0.2", errTestDial)
+	if got := ejectionLeft(bm, "10.0.0.2"); got != 10*time.Second {
+		t.Errorf("ejected for %s after one failure, want 10s", got)
+	}
+
+	// Disabling outlier detection stops further ejections
+	clock.Advance(10 * time.Second)
+	bm.SetOutlierDetection(config.OutlierDetectionConfig{})
+	for i := 0; i < 5; i++ {
+		bm.ReportFailure("10.0.0.1", errTestDial)
+	}
+	if got := ejectionLeft(bm, "10.0.0.1"); got != 0 {
+		t.Errorf("ejected for %s with outlier detection disabled", got)
+	}
+}
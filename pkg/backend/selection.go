@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+)
+
+// Algorithm selects how a backend is chosen for a new client connection
+type Algorithm string
+
+const (
+	RoundRobin               Algorithm = config.AlgorithmRoundRobin
+	LeastConnections         Algorithm = config.AlgorithmLeastConnections
+	WeightedRoundRobin       Algorithm = config.AlgorithmWeightedRoundRobin
+	WeightedLeastConnections Algorithm = config.AlgorithmWeightedLeastConnections
+	ConsistentHash           Algorithm = config.AlgorithmConsistentHash
+	ConsistentHashIPPort     Algorithm = config.AlgorithmConsistentHashIPPort
+)
+
+// ConnectionCounter reports the number of active connections to a backend
+type ConnectionCounter interface {
+	ActiveConnections(backendIP string) int64
+}
+
+// SelectBackend picks a healthy backend for the client address (ip:port) using the given algorithm.
+// Backends listed in exclude are skipped, which is used to retry after a failed dial.
+func (bm *BackendManager) SelectBackend(clientAddr string, algorithm Algorithm, counter ConnectionCounter, exclude ...string) string {
+	clientIP := hostWithoutPort(clientAddr)
+	switch algorithm {
+	case ConsistentHash:
+		return bm.selectConsistentHash(clientIP, exclude)
+	case ConsistentHashIPPort:
+		return bm.selectConsistentHash(clientAddr, exclude)
+	case LeastConnections:
+		return bm.selectLeastConnections(clientIP, counter, false, exclude)
+	case WeightedRoundRobin:
+		return bm.stickyBackend(clientIP, exclude, bm.selectWeightedRoundRobin)
+	case WeightedLeastConnections:
+		return bm.selectLeastConnections(clientIP, counter, true, exclude)
+	default:
+		return bm.stickyBackend(clientIP, exclude, bm.selectNewBackend)
+	}
+}
+
+// selectLeastConnections picks the healthy backend with the fewest active connections, relative to
+// its weight when weighted is set. Ties are broken in round-robin order so idle backends share a
+// burst of new clients.
+func (bm *BackendManager) selectLeastConnections(ip string, counter ConnectionCounter, weighted bool, exclude []string) string {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	log.Debugf("[Backend Manager] Selecting new backend for IP %s using least-connections method.", ip)
+
+	nodeNames := bm.sortedBackendNamesLocked()
+	if len(nodeNames) == 0 {
+		log.Debugf("[Backend Manager] No backends available for selection.")
+		return ""
+	}
+
+	totalBackends := uint32(len(nodeNames))
+	start := atomic.LoadUint32(&bm.currentIndex)
+	bestIndex := -1
+	var bestConnections, bestWeight int64
+
+	for i := uint32(0); i < totalBackends; i++ {
+		index := (start + i) % totalBackends
+		detail := bm.backendList[nodeNames[index]]
+		if !bm.isCandidate(backendKey(detail), exclude) {
+			continue
+		}
+		connections := counter.ActiveConnections(backendKey(detail))
+		weight := int64(1)
+		if weighted {
+			weight = int64(detail.Weight)
+		}
+		// connections/weight < bestConnections/bestWeight without integer division
+		if bestIndex == -1 || connections*bestWeight < bestConnections*weight {
+			bestIndex = int(index)
+			bestConnections = connections
+			bestWeight = weight
+		}
+	}
+
+	if bestIndex == -1 {
+		log.Debugf("[Backend Manager] No healthy backends available for IP %s after least-connections selection.", ip)
+		return ""
+	}
+
+	atomic.StoreUint32(&bm.currentIndex, (uint32(bestIndex)+1)%totalBackends)
+	detail := bm.backendList[nodeNames[bestIndex]]
+	log.Debugf("[Backend Manager] Backend %s with %d active connections assigned for IP %s", detail.Name, bestConnections, ip)
+	return backendKey(detail)
+}
+
+// selectWeightedRoundRobin performs a smooth weighted round-robin selection (as used by nginx) so
+// backends receive new clients in proportion to their weight without bursts; callers must hold bm.mutex
+func (bm *BackendManager) selectWeightedRoundRobin(ip string, exclude []string) string {
+	log.Debugf("[Backend Manager] Selecting new backend for IP %s using weighted round-robin method.", ip)
+
+	var best string
+	totalWeight := 0
+	for _, name := range bm.sortedBackendNamesLocked() {
+		detail := bm.backendList[name]
+		if !bm.isCandidate(backendKey(detail), exclude) {
+			continue
+		}
+		bm.currentWeights[name] += detail.Weight
+		totalWeight += detail.Weight
+		if best == "" || bm.currentWeights[name] > bm.currentWeights[best] {
+			best = name
+		}
+	}
+
+	if best == "" {
+		log.Debugf("[Backend Manager] No healthy backends available for IP %s after weighted round-robin selection.", ip)
+		return ""
+	}
+
+	bm.currentWeights[best] -= totalWeight
+	log.Debugf("[Backend Manager] New healthy backend assigned: %s (weight %d) for IP %s", best, bm.backendList[best].Weight, ip)
+	return backendKey(bm.backendList[best])
+}
+
+// selectConsistentHash maps the key onto the backends with a Maglev table. The table is built from every
+// backend, healthy or not, and unhealthy or excluded backends are skipped by the lookup, so a health change
+// only moves the clients of that backend. No per-client state is kept.
+func (bm *BackendManager) selectConsistentHash(key string, exclude []string) string {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	names := bm.sortedBackendNamesLocked()
+	var skipped []string
+	for _, name := range names {
+		if !bm.isCandidate(backendKey(bm.backendList[name]), exclude) {
+			skipped = append(skipped, name)
+		}
+	}
+	if len(skipped) == len(names) {
+		log.Debugf("[Backend Manager] No healthy backends available for %s after consistent-hash selection.", key)
+		return ""
+	}
+
+	tableKey := strings.Join(names, ",")
+	if bm.maglev == nil || bm.maglev.key != tableKey {
+		log.Debugf("[Backend Manager] Rebuilding consistent-hash table for backends: %s", tableKey)
+		bm.maglev = newMaglevTable(tableKey, names)
+	}
+
+	name := bm.maglev.lookup(key, skipped)
+	log.Debugf("[Backend Manager] Backend %s assigned for %s by consistent hash", name, key)
+	return backendKey(bm.backendList[name])
+}
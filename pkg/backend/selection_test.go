@@ -0,0 +1,251 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+)
+
+// connectionCounts is a ConnectionCounter with fixed counts per backend IP
+type connectionCounts map[string]int64
+
+func (c connectionCounts) ActiveConnections(backendIP string) int64 {
+	return c[backendIP]
+}
+
+// newTestManager returns a manager with one healthy backend per name, at 10.0.0.1, 10.0.0.2, ...
+// in the sorted order of the names
+func newTestManager(weights map[string]int) *BackendManager {
+	bm := NewManager(nil, time.Second, nil, 0, 0, config.OutlierDetectionConfig{})
+	i := 0
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		weight, ok := weights[name]
+		if !ok {
+			continue
+		}
+		i++
+		detail := k8sutils.NodeDetails{Name: name, IP: fmt.Sprintf("10.0.0.%d", i), Weight: weight}
+		bm.AddBackend(detail)
+		bm.healthMap[backendKey(detail)] = true
+	}
+	return bm
+}
+
+func nameByIP(bm *BackendManager, ip string) string {
+	for name, detail := range bm.backendList {
+		if backendKey(detail) == ip {
+			return name
+		}
+	}
+	return "-"
+}
+
+func TestSelectLeastConnections(t *testing.T) {
+	tests := []struct {
+		name        string
+		weights     map[string]int
+		connections connectionCounts
+		unhealthy   []string
+		exclude     []string
+		algorithm   Algorithm
+		want        string // Backend name, "-" for none
+	}{
+		{
+			name:        "fewest connections",
+			weights:     map[string]int{"a": 1, "b": 1, "c": 1},
+			connections: connectionCounts{"10.0.0.1": 5, "10.0.0.2": 2, "10.0.0.3": 7},
+			algorithm:   LeastConnections,
+			want:        "b",
+		},
+		{
+			name:        "idle backend",
+			weights:     map[string]int{"a": 1, "b": 1, "c": 1},
+			connections: connectionCounts{"10.0.0.1": 5, "10.0.0.2": 2},
+			algorithm:   LeastConnections,
+			want:        "c",
+		},
+		{
+			name:        "weights ignored when unweighted",
+			weights:     map[string]int{"a": 10, "b": 1},
+			connections: connectionCounts{"10.0.0.1": 4, "10.0.0.2": 3},
+			algorithm:   LeastConnections,
+			want:        "b",
+		},
+		{
+			// 4/10 < 3/1
+			name:        "connections relative to weight",
+			weights:     map[string]int{"a": 10, "b": 1},
+			connections: connectionCounts{"10.0.0.1": 4, "10.0.0.2": 3},
+			algorithm:   WeightedLeastConnections,
+			want:        "a",
+		},
+		{
+			// 7/3 > 2/1
+			name:        "heavier backend with more load per weight",
+			weights:     map[string]int{"a": 3, "b": 1},
+			connections: connectionCounts{"10.0.0.1": 7, "10.0.0.2": 2},
+			algorithm:   WeightedLeastConnections,
+			want:        "b",
+		},
+		{
+			name:        "fewest connections unhealthy",
+			weights:     map[string]int{"a": 1, "b": 1, "c": 1},
+			connections: connectionCounts{"10.0.0.1": 5, "10.0.0.2": 2, "10.0.0.3": 7},
+			unhealthy:   []string{"10.0.0.2"},
+			algorithm:   LeastConnections,
+			want:        "a",
+		},
+		{
+			name:        "fewest connections excluded",
+			weights:     map[string]int{"a": 1, "b": 1, "c": 1},
+			connections: connectionCounts{"10.0.0.1": 5, "10.0.0.2": 2, "10.0.0.3": 7},
+			exclude:     []string{"10.0.0.2"},
+			algorithm:   LeastConnections,
+			want:        "a",
+		},
+		{
+			name:      "no healthy backend",
+			weights:   map[string]int{"a": 1, "b": 1},
+			unhealthy: []string{"10.0.0.1", "10.0.0.2"},
+			algorithm: LeastConnections,
+			want:      "-",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newTestManager(tt.weights)
+			for _, backendIP := range tt.unhealthy {
+				bm.healthMap[backendIP] = false
+			}
+			got := nameByIP(bm, bm.SelectBackend("192.0.2.1:40000", tt.algorithm, tt.connections, tt.exclude...))
+			if got != tt.want {
+				t.Errorf("SelectBackend() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectLeastConnectionsTies(t *testing.T) {
+	bm := newTestManager(map[string]int{"a": 1, "b": 1, "c": 1})
+	var got strings.Builder
+	for i := 0; i < 6; i++ {
+		got.WriteString(nameByIP(bm, bm.SelectBackend("192.0.2.1:40000", LeastConnections, connectionCounts{})))
+	}
+	// Idle backends share new clients in turn rather than the first one taking them all
+	if got.String() != "abcabc" {
+		t.Errorf("picks = %q, want %q", got.String(), "abcabc")
+	}
+}
+
+func TestSelectLeastConnectionsTracksCounts(t *testing.T) {
+	bm := newTestManager(map[string]int{"a": 1, "b": 1})
+	connections := connectionCounts{}
+	connect := func() string {
+		backendIP := bm.SelectBackend("192.0.2.1:40000", LeastConnections, connections)
+		connections[backendIP]++
+		return nameByIP(bm, backendIP)
+	}
+
+	var got strings.Builder
+	for i := 0; i < 4; i++ {
+		got.WriteString(connect())
+	}
+	if got.String() != "abab" {
+		t.Fatalf("picks = %q, want %q", got.String(), "abab")
+	}
+
+	// Closing connections to a makes it the least loaded backend for the next clients
+	connections["10.0.0.1"] -= 2
+	got.Reset()
+	for i := 0; i < 3; i++ {
+		got.WriteString(connect())
+	}
+	if got.String() != "aab" {
+		t.Errorf("picks after a's connections closed = %q, want %q", got.String(), "aab")
+	}
+}
+
+func TestSelectWeightedRoundRobin(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		rounds  int
+		exclude []string
+		want    string // Expected order of the first picks, one letter per backend name
+	}{
+		{name: "equal weights", weights: map[string]int{"a": 1, "b": 1, "c": 1}, rounds: 3, want: "abc"},
+		{name: "smooth 5-1-1", weights: map[string]int{"a": 5, "b": 1, "c": 1}, rounds: 7, want: "aabacaa"},
+		{name: "2-1", weights: map[string]int{"a": 2, "b": 1}, rounds: 6, want: "abaaba"},
+		{name: "excluded backend", weights: map[string]int{"a": 3, "b": 1}, rounds: 4, exclude: []string{"10.0.0.1"}, want: "bbbb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newTestManager(tt.weights)
+			var got strings.Builder
+			bm.mutex.Lock()
+			for i := 0; i < tt.rounds; i++ {
+				got.WriteString(nameByIP(bm, bm.selectWeightedRoundRobin("192.0.2.1", tt.exclude)))
+			}
+			bm.mutex.Unlock()
+			if got.String() != tt.want {
+				t.Errorf("picks = %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectWeightedRoundRobinProportions(t *testing.T) {
+	weights := map[string]int{"a": 5, "b": 3, "c": 2}
+	bm := newTestManager(weights)
+	picks := make(map[string]int)
+	bm.mutex.Lock()
+	for i := 0; i < 1000; i++ {
+		picks[nameByIP(bm, bm.selectWeightedRoundRobin("192.0.2.1", nil))]++
+	}
+	bm.mutex.Unlock()
+	for name, weight := range weights {
+		if picks[name] != weight*100 {
+			t.Errorf("backend %s picked %d times, want %d", name, picks[name], weight*100)
+		}
+	}
+}
+
+func TestSelectConsistentHashHealthChange(t *testing.T) {
+	bm := newTestManager(map[string]int{"a": 1, "b": 1, "c": 1, "d": 1, "e": 1})
+	clients := make([]string, 1000)
+	before := make(map[string]string, len(clients))
+	for i := range clients {
+		clients[i] = fmt.Sprintf("192.0.%d.%d", i/250, i%250)
+		before[clients[i]] = bm.SelectBackend(clients[i], ConsistentHash, nil)
+	}
+
+	// Only the clients of the backend leaving rotation move; the others keep their backend
+	bm.healthMap["10.0.0.3"] = false
+	moved := 0
+	for _, client := range clients {
+		got := bm.SelectBackend(client, ConsistentHash, nil)
+		switch {
+		case got == "10.0.0.3":
+			t.Fatalf("client %s still sent to the unhealthy backend", client)
+		case before[client] == "10.0.0.3":
+			moved++
+		case got != before[client]:
+			t.Errorf("client %s of healthy backend %s moved to %s", client, before[client], got)
+		}
+	}
+	if moved == 0 {
+		t.Fatal("no client was sent to the unhealthy backend before")
+	}
+
+	// Back in rotation, every client returns to its backend
+	bm.healthMap["10.0.0.3"] = true
+	for _, client := range clients {
+		if got := bm.SelectBackend(client, ConsistentHash, nil); got != before[client] {
+			t.Errorf("client %s sent to %s after recovery, want %s", client, got, before[client])
+		}
+	}
+}
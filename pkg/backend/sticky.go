@@ -0,0 +1,215 @@
+package backend
+
+import (
+	"container/list"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StickyBinding is a client to backend binding as exposed by the admin API
+type StickyBinding struct {
+	Client   string    `json:"client"`
+	Backend  string    `json:"backend"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// stickyTable maps client IPs to backend IPs with an idle TTL and an LRU size cap.
+// It is not safe for concurrent use; the BackendManager guards it with bm.mutex.
+type stickyTable struct {
+	ttl        time.Duration // Idle time after which a binding expires, 0 disables expiry
+	maxEntries int           // Maximum number of bindings, 0 means unlimited
+	entries    map[string]*list.Element
+	lru        *list.List       // Most recently used binding at the front
+	now        func() time.Time // Clock for last use and expiry, time.Now outside tests
+}
+
+func newStickyTable(ttl time.Duration, maxEntries int) *stickyTable {
+	return &stickyTable{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// get returns the backend bound to the client and refreshes its idle timer
+func (t *stickyTable) get(client string) (string, bool) {
+	elem, exists := t.entries[client]
+	if !exists {
+		return "", false
+	}
+	binding := elem.Value.(*StickyBinding)
+	if t.expired(binding, t.now()) {
+		t.remove(elem)
+		return "", false
+	}
+	binding.LastUsed = t.now()
+	t.lru.MoveToFront(elem)
+	return binding.Backend, true
+}
+
+// set binds the client to a backend, evicting the least recently used bindings when full
+func (t *stickyTable) set(client, backend string) {
+	if elem, exists := t.entries[client]; exists {
+		binding := elem.Value.(*StickyBinding)
+		binding.Backend = backend
+		binding.LastUsed = t.now()
+		t.lru.MoveToFront(elem)
+		return
+	}
+
+	t.entries[client] = t.lru.PushFront(&StickyBinding{Client: client, Backend: backend, LastUsed: t.now()})
+	t.evict()
+}
+
+// setLimits changes the TTL and size cap, evicting the least recently used bindings over the new cap
+func (t *stickyTable) setLimits(ttl time.Duration, maxEntries int) {
+	t.ttl = ttl
+	t.maxEntries = maxEntries
+	t.evict()
+}
+
+// evict removes the least recently used bindings while the table is over its cap
+func (t *stickyTable) evict() {
+	for t.maxEntries > 0 && t.lru.Len() > t.maxEntries {
+		oldest := t.lru.Back()
+		log.Debugf("[Backend Manager] Evicting sticky binding for client %s, table is full.", oldest.Value.(*StickyBinding).Client)
+		t.remove(oldest)
+	}
+}
+
+// lookup returns a copy of the client's binding without refreshing it
+func (t *stickyTable) lookup(client string) (StickyBinding, bool) {
+	elem, exists := t.entries[client]
+	if !exists || t.expired(elem.Value.(*StickyBinding), t.now()) {
+		return StickyBinding{}, false
+	}
+	return *elem.Value.(*StickyBinding), true
+}
+
+// delete removes the client's binding and reports whether it existed
+func (t *stickyTable) delete(client string) bool {
+	elem, exists := t.entries[client]
+	if exists {
+		t.remove(elem)
+	}
+	return exists
+}
+
+// deleteBackend removes every binding that points at the backend
+func (t *stickyTable) deleteBackend(backend string) {
+	for elem := t.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*StickyBinding).Backend == backend {
+			t.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// flush removes every binding and returns how many were removed
+func (t *stickyTable) flush() int {
+	count := t.lru.Len()
+	t.entries = make(map[string]*list.Element)
+	t.lru.Init()
+	return count
+}
+
+// expire removes bindings that have been idle longer than the TTL and returns how many were removed
+func (t *stickyTable) expire() int {
+	now := t.now()
+	count := 0
+	// The least recently used bindings are at the back, so stop at the first live one
+	for elem := t.lru.Back(); elem != nil && t.expired(elem.Value.(*StickyBinding), now); elem = t.lru.Back() {
+		t.remove(elem)
+		count++
+	}
+	return count
+}
+
+// list returns a copy of every live binding sorted by client
+func (t *stickyTable) list() []StickyBinding {
+	now := t.now()
+	bindings := make([]StickyBinding, 0, t.lru.Len())
+	for elem := t.lru.Front(); elem != nil; elem = elem.Next() {
+		binding := elem.Value.(*StickyBinding)
+		if !t.expired(binding, now) {
+			bindings = append(bindings, *binding)
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].Client < bindings[j].Client
+	})
+	return bindings
+}
+
+func (t *stickyTable) expired(binding *StickyBinding, now time.Time) bool {
+	return t.ttl > 0 && now.Sub(binding.LastUsed) > t.ttl
+}
+
+func (t *stickyTable) remove(elem *list.Element) {
+	delete(t.entries, elem.Value.(*StickyBinding).Client)
+	t.lru.Remove(elem)
+}
+
+// StickyHandler serves the sticky-session admin API:
+// GET lists all bindings or, with ?client=IP, looks up one; DELETE flushes all bindings or, with ?client=IP, removes one.
+// DELETE is refused unless adminToken is set and the request carries it as a bearer token.
+func (bm *BackendManager) StickyHandler(adminToken string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := r.URL.Query().Get("client")
+
+		switch r.Method {
+		case http.MethodGet:
+			bm.mutex.Lock()
+			var response interface{}
+			if client == "" {
+				response = bm.ipMap.list()
+			} else if binding, exists := bm.ipMap.lookup(client); exists {
+				response = binding
+			}
+			bm.mutex.Unlock()
+
+			if response == nil {
+				http.Error(w, "No binding for client "+client, http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+
+		case http.MethodDelete:
+			if adminToken == "" {
+				http.Error(w, "Removing sticky bindings is disabled, set STICKY_ADMIN_TOKEN to enable it", http.StatusForbidden)
+				return
+			}
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			bm.mutex.Lock()
+			var removed int
+			if client == "" {
+				removed = bm.ipMap.flush()
+			} else if bm.ipMap.delete(client) {
+				removed = 1
+			}
+			bm.mutex.Unlock()
+
+			log.Infof("[Backend Manager] Removed %d sticky bindings via admin API.", removed)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
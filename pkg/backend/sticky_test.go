@@ -0,0 +1,246 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+)
+
+// fakeClock is a manually advanced clock for the time-dependent state of a BackendManager
+type fakeClock struct {
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func stickyClients(t *stickyTable) string {
+	var clients []string
+	for _, binding := range t.list() {
+		clients = append(clients, binding.Client)
+	}
+	return strings.Join(clients, ",")
+}
+
+func TestStickyTableTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		idle        time.Duration // Time without use before the lookup
+		wantBackend string
+	}{
+		{name: "within TTL", ttl: time.Minute, idle: 59 * time.Second, wantBackend: "10.0.0.1"},
+		{name: "at TTL", ttl: time.Minute, idle: time.Minute, wantBackend: "10.0.0.1"},
+		{name: "expired", ttl: time.Minute, idle: 61 * time.Second},
+		{name: "expiry disabled", ttl: 0, idle: 24 * time.Hour, wantBackend: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			table := newStickyTable(tt.ttl, 0)
+			table.now = clock.Now
+			table.set("192.0.2.1", "10.0.0.1")
+			clock.Advance(tt.idle)
+
+			if _, exists := table.lookup("192.0.2.1"); exists != (tt.wantBackend != "") {
+				t.Errorf("lookup() found binding = %t, want %t", exists, tt.wantBackend != "")
+			}
+			if got, _ := table.get("192.0.2.1"); got != tt.wantBackend {
+				t.Errorf("get() = %q, want %q", got, tt.wantBackend)
+			}
+		})
+	}
+}
+
+func TestStickyTableIdleRefresh(t *testing.T) {
+	clock := newFakeClock()
+	table := newStickyTable(time.Minute, 0)
+	table.now = clock.Now
+	table.set("192.0.2.1", "10.0.0.1")
+	table.set("192.0.2.2", "10.0.0.2")
+	table.set("192.0.2.3", "10.0.0.3")
+
+	// Using a binding restarts its idle time; lookups for the admin API do not
+	clock.Advance(40 * time.Second)
+	table.get("192.0.2.1")
+	table.lookup("192.0.2.2")
+	clock.Advance(40 * time.Second)
+	if got := stickyClients(table); got != "192.0.2.1" {
+		t.Errorf("live bindings = %s, want 192.0.2.1", got)
+	}
+	if expired := table.expire(); expired != 2 {
+		t.Errorf("expire() = %d, want 2", expired)
+	}
+	if table.lru.Len() != 1 || len(table.entries) != 1 {
+		t.Errorf("%d bindings and %d entries left after expiry, want 1", table.lru.Len(), len(table.entries))
+	}
+}
+
+func TestStickyTableMaxEntries(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		clients    int
+		use        string // Client whose binding is used before the last one is added
+		want       string
+	}{
+		{name: "below cap", maxEntries: 3, clients: 3, want: "192.0.2.1,192.0.2.2,192.0.2.3"},
+		{name: "oldest evicted", maxEntries: 3, clients: 5, want: "192.0.2.3,192.0.2.4,192.0.2.5"},
+		{name: "used binding kept", maxEntries: 3, clients: 4, use: "192.0.2.1", want: "192.0.2.1,192.0.2.3,192.0.2.4"},
+		{name: "unlimited", maxEntries: 0, clients: 5, want: "192.0.2.1,192.0.2.2,192.0.2.3,192.0.2.4,192.0.2.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			table := newStickyTable(time.Hour, tt.maxEntries)
+			table.now = clock.Now
+			for i := 1; i <= tt.clients; i++ {
+				if i == tt.clients && tt.use != "" {
+					table.get(tt.use)
+				}
+				table.set(fmt.Sprintf("192.0.2.%d", i), "10.0.0.1")
+				clock.Advance(time.Second)
+			}
+			if got := stickyClients(table); got != tt.want {
+				t.Errorf("bindings = %s, want %s", got, tt.want)
+			}
+			if tt.maxEntries > 0 && len(table.entries) > tt.maxEntries {
+				t.Errorf("%d entries, want at most %d", len(table.entries), tt.maxEntries)
+			}
+		})
+	}
+}
+
+func TestSetStickyLimits(t *testing.T) {
+	bm := NewManager(nil, time.Second, nil, time.Hour, 0, config.OutlierDetectionConfig{})
+	clock := newFakeClock()
+	bm.ipMap.now = clock.Now
+	for i := 1; i <= 4; i++ {
+		bm.ipMap.set(fmt.Sprintf("192.0.2.%d", i), "10.0.0.1")
+		clock.Advance(time.Minute)
+	}
+	bm.ipMap.get("192.0.2.1")
+
+	// A smaller cap evicts the least recently used bindings right away
+	bm.SetStickyLimits(time.Hour, 2)
+	if got := stickyClients(bm.ipMap); got != "192.0.2.1,192.0.2.4" {
+		t.Errorf("bindings = %s, want 192.0.2.1,192.0.2.4", got)
+	}
+
+	// A shorter TTL applies to the bindings already in the table
+	bm.SetStickyLimits(30*time.Second, 2)
+	clock.Advance(31 * time.Second)
+	bm.expireStickyBindings()
+	if got := stickyClients(bm.ipMap); got != "" {
+		t.Errorf("bindings after the new TTL = %s, want none", got)
+	}
+}
+
+func TestNewManagerStickySettings(t *testing.T) {
+	bm := NewManager(nil, time.Second, nil, time.Minute, 2, config.OutlierDetectionConfig{})
+	clock := newFakeClock()
+	bm.ipMap.now = clock.Now
+	bm.AddBackend(k8sutils.NodeDetails{Name: "a", IP: "10.0.0.1"})
+	bm.AddBackend(k8sutils.NodeDetails{Name: "b", IP: "10.0.0.2"})
+	markHealthy(bm, "10.0.0.1", "10.0.0.2")
+
+	first := bm.GetBackendByIP("192.0.2.1")
+	bm.GetBackendByIP("192.0.2.2")
+	bm.GetBackendByIP("192.0.2.3")
+	if got := stickyClients(bm.ipMap); got != "192.0.2.2,192.0.2.3" {
+		t.Errorf("bindings = %s, want the two most recent clients", got)
+	}
+
+	// An expired client is balanced again rather than returning to its old backend
+	clock.Advance(2 * time.Minute)
+	bm.expireStickyBindings()
+	if got := stickyClients(bm.ipMap); got != "" {
+		t.Errorf("bindings after TTL = %s, want none", got)
+	}
+	if again := bm.GetBackendByIP("192.0.2.1"); again == first {
+		t.Errorf("client returned to %s after its binding expired, want the next backend in turn", again)
+	}
+}
+
+func TestStickyHandler(t *testing.T) {
+	const both = "192.0.2.1,192.0.2.2"
+	tests := []struct {
+		name          string
+		method        string
+		query         string
+		adminToken    string
+		authorization string
+		wantStatus    int
+		wantBody      string // JSON compared after decoding
+		wantClients   string // Bindings left afterwards
+	}{
+		{name: "list", method: http.MethodGet, wantStatus: http.StatusOK, wantBody: `[{"client":"192.0.2.1","backend":"10.0.0.1"},{"client":"192.0.2.2","backend":"10.0.0.2"}]`, wantClients: both},
+		{name: "list ignores token", method: http.MethodGet, adminToken: "secret", wantStatus: http.StatusOK, wantClients: both},
+		{name: "lookup", method: http.MethodGet, query: "?client=192.0.2.2", wantStatus: http.StatusOK, wantBody: `{"client":"192.0.2.2","backend":"10.0.0.2"}`, wantClients: both},
+		{name: "lookup unknown client", method: http.MethodGet, query: "?client=192.0.2.9", wantStatus: http.StatusNotFound, wantClients: both},
+		{name: "delete disabled", method: http.MethodDelete, authorization: "Bearer secret", wantStatus: http.StatusForbidden, wantClients: both},
+		{name: "delete without token", method: http.MethodDelete, adminToken: "secret", wantStatus: http.StatusUnauthorized, wantClients: both},
+		{name: "delete with wrong token", method: http.MethodDelete, adminToken: "secret", authorization: "Bearer other", wantStatus: http.StatusUnauthorized, wantClients: both},
+		{name: "delete with basic auth", method: http.MethodDelete, adminToken: "secret", authorization: "Basic secret", wantStatus: http.StatusUnauthorized, wantClients: both},
+		{name: "delete client", method: http.MethodDelete, query: "?client=192.0.2.1", adminToken: "secret", authorization: "Bearer secret", wantStatus: http.StatusOK, wantBody: `{"removed":1}`, wantClients: "192.0.2.2"},
+		{name: "delete unknown client", method: http.MethodDelete, query: "?client=192.0.2.9", adminToken: "secret", authorization: "Bearer secret", wantStatus: http.StatusOK, wantBody: `{"removed":0}`, wantClients: both},
+		{name: "flush", method: http.MethodDelete, adminToken: "secret", authorization: "Bearer secret", wantStatus: http.StatusOK, wantBody: `{"removed":2}`, wantClients: ""},
+		{name: "other method", method: http.MethodPost, adminToken: "secret", authorization: "Bearer secret", wantStatus: http.StatusMethodNotAllowed, wantClients: both},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := NewManager(nil, time.Second, nil, time.Hour, 0, config.OutlierDetectionConfig{})
+			bm.ipMap.set("192.0.2.1", "10.0.0.1")
+			bm.ipMap.set("192.0.2.2", "10.0.0.2")
+
+			req := httptest.NewRequest(tt.method, "/sticky/http"+tt.query, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			bm.StickyHandler(tt.adminToken).ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantBody != "" {
+				var got, want interface{}
+				if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+					t.Fatalf("decode response %q: %v", recorder.Body.String(), err)
+				}
+				json.Unmarshal([]byte(tt.wantBody), &want)
+				if !reflect.DeepEqual(withoutLastUsed(got), want) {
+					t.Errorf("body = %s, want %s", recorder.Body.String(), tt.wantBody)
+				}
+			}
+			if got := stickyClients(bm.ipMap); got != tt.wantClients {
+				t.Errorf("bindings afterwards = %s, want %s", got, tt.wantClients)
+			}
+		})
+	}
+}
+
+// withoutLastUsed drops the lastUsed timestamps from decoded bindings
+func withoutLastUsed(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, binding := range v {
+			withoutLastUsed(binding)
+		}
+	case map[string]interface{}:
+		delete(v, "lastUsed")
+	}
+	return v
+}
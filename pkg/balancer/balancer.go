@@ -0,0 +1,556 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/backend"
+	"github.com/supporttools/GoKubeBalancer/pkg/certs"
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+	"github.com/supporttools/GoKubeBalancer/pkg/logging"
+	"github.com/supporttools/GoKubeBalancer/pkg/network"
+	"github.com/supporttools/GoKubeBalancer/pkg/sockets"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+var log = logging.SetupLogging()
+
+// Balancer runs the configured listeners and applies configuration reloads to them without
+// dropping established connections
+type Balancer struct {
+	mutex        sync.Mutex
+	ctx          context.Context
+	cfg          *config.AppConfig // Configuration currently in effect
+	clients      *k8sutils.ClientManager
+	nodeInformer cache.SharedIndexInformer // Nil in static mode
+	pools        map[string]*pool          // Backend pools by listener or pool name
+	frontends    map[string]frontend       // TCP, HTTP or UDP balancers by frontendKey
+	draining     map[frontend]bool         // Frontends removed by a reload that still have connections
+	certStores   map[string]*certs.Store   // Certificates of TLS listeners by listener name
+	shutdown     bool                      // Set by Shutdown; reloads are refused from then on
+}
+
+// frontend accepts the client connections or datagrams of a listener and balances them or their requests
+type frontend interface {
+	Start() error
+	Update(listener config.ListenerConfig, bm *backend.BackendManager, routes []network.Route, tlsSettings network.TLS)
+	Stop()
+	Shutdown(ctx context.Context) network.DrainStats
+	OpenConnections() int
+}
+
+// newFrontend creates the TCP, HTTP or UDP balancer for a listener, depending on its mode
+func newFrontend(listener config.ListenerConfig, bm *backend.BackendManager, routes []network.Route, tlsSettings network.TLS) frontend {
+	switch {
+	case listener.UDPMode():
+		return network.NewUDPBalancer(listener, bm)
+	case listener.HTTPMode():
+		return network.NewHTTPBalancer(listener, bm, routes, tlsSettings)
+	}
+	return network.NewTCPBalancer(listener, bm, routes, tlsSettings)
+}
+
+// frontendKey identifies the socket of a listener; a TCP and a UDP listener may share an address
+func frontendKey(listener config.ListenerConfig) string {
+	return listener.Network() + "/" + listener.ListenAddress()
+}
+
+// listen opens the socket of a listener, or adopts an inherited one, before its frontend is created
+func listen(listener config.ListenerConfig) (io.Closer, error) {
+	if listener.UDPMode() {
+		return sockets.ListenPacket(listener.Name, listener.ListenAddress())
+	}
+	return sockets.Listen(listener.Name, listener.ListenAddress())
+}
+
+// serve handles the connections or datagrams arriving on a socket opened by listen until it is closed
+func serve(f frontend, socket io.Closer) {
+	switch f := f.(type) {
+	case *network.UDPBalancer:
+		f.Serve(socket.(net.PacketConn))
+	case interface{ Serve(net.Listener) }:
+		f.Serve(socket.(net.Listener))
+	}
+}
+
+// pool is the backend pool of a listener or a named pool
+type pool struct {
+	spec              poolSpec
+	backendManager    *backend.BackendManager
+	stopHealthChecker context.CancelFunc
+}
+
+// poolSpec describes where the backends of a pool come from
+type poolSpec struct {
+	name         string
+	healthCheck  config.HealthCheckConfig
+	backendPort  int                    // Port probed by a health check without a port of its own
+	static       bool                   // Backends are listed rather than discovered
+	members      []k8sutils.NodeDetails // Static backends
+	nodeSelector labels.Selector        // Discovered nodes that belong to the pool
+}
+
+// listenerPool returns the name of the pool whose backends a listener uses
+func listenerPool(listener config.ListenerConfig) string {
+	if listener.Pool != "" {
+		return listener.Pool
+	}
+	return listener.Name
+}
+
+// poolSpecs returns the pools of a configuration: one per listener without a named pool with the static
+// members or the discovered nodes matching the node selector, then the named pools
+func poolSpecs(cfg *config.AppConfig, members []k8sutils.NodeDetails) ([]poolSpec, error) {
+	nodeSelector, err := labels.Parse(cfg.NodeSelector)
+	if err != nil {
+		return nil, fmt.Errorf("node selector: %w", err)
+	}
+	requirements, _ := nodeSelector.Requirements()
+
+	specs := make([]poolSpec, 0, len(cfg.Listeners)+len(cfg.Pools))
+	for _, listener := range cfg.Listeners {
+		if listener.Pool != "" {
+			continue
+		}
+		specs = append(specs, poolSpec{
+			name:         listener.Name,
+			healthCheck:  listener.HealthCheck,
+			backendPort:  listener.BackendPort,
+			static:       cfg.StaticMode(),
+			members:      members,
+			nodeSelector: nodeSelector,
+		})
+	}
+	for _, poolConfig := range cfg.Pools {
+		spec := poolSpec{
+			name:        poolConfig.Name,
+			healthCheck: poolConfig.HealthCheck,
+			backendPort: poolConfig.BackendPort,
+			static:      poolConfig.Static(),
+		}
+		if spec.static {
+			if spec.members, err = k8sutils.LoadStaticNodes(poolConfig.BackendMembers, poolConfig.BackendMembersFile); err != nil {
+				return nil, fmt.Errorf("load backend members of pool %s: %w", poolConfig.Name, err)
+			}
+		} else {
+			poolSelector, err := labels.Parse(poolConfig.NodeSelector)
+			if err != nil {
+				return nil, fmt.Errorf("node selector of pool %s: %w", poolConfig.Name, err)
+			}
+			// A pool chooses among the nodes matching the global node selector
+			spec.nodeSelector = poolSelector.Add(requirements...)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// routes resolves the SNI or HTTP routes of a listener to their pools. Backend port and algorithm
+// default to those of the listener.
+func routes(cfg *config.AppConfig, listener config.ListenerConfig, pools map[string]*pool) []network.Route {
+	var routes []network.Route
+	for _, sniRoute := range listener.SNIRoutes {
+		routes = append(routes, poolRoute(cfg, listener, pools, sniRoute.Pool, sniRoute.Hosts, ""))
+	}
+	for _, httpRoute := range listener.HTTPRoutes {
+		routes = append(routes, poolRoute(cfg, listener, pools, httpRoute.Pool, httpRoute.Hosts, httpRoute.PathPrefix))
+	}
+	return routes
+}
+
+func poolRoute(cfg *config.AppConfig, listener config.ListenerConfig, pools map[string]*pool, poolName string, hosts []string, pathPrefix string) network.Route {
+	route := network.Route{
+		Pool:           poolName,
+		Hosts:          hosts,
+		PathPrefix:     pathPrefix,
+		BackendManager: pools[poolName].backendManager,
+		BackendPort:    listener.BackendPort,
+		Algorithm:      backend.Algorithm(listener.Algorithm),
+	}
+	for _, poolConfig := range cfg.Pools {
+		if poolConfig.Name != poolName {
+			continue
+		}
+		if poolConfig.BackendPort != 0 {
+			route.BackendPort = poolConfig.BackendPort
+		}
+		if poolConfig.Algorithm != "" {
+			route.Algorithm = backend.Algorithm(poolConfig.Algorithm)
+		}
+	}
+	return route
+}
+
+// New creates the backend pools of every listener and named pool. Static members are used when
+// clients is nil, otherwise the pools follow the nodes of nodeInformer.
+func New(ctx context.Context, cfg *config.AppConfig, members []k8sutils.NodeDetails, clients *k8sutils.ClientManager, nodeInformer cache.SharedIndexInformer) (*Balancer, error) {
+	b := &Balancer{
+		ctx:          ctx,
+		cfg:          cfg,
+		clients:      clients,
+		nodeInformer: nodeInformer,
+		pools:        make(map[string]*pool),
+		frontends:    make(map[string]frontend),
+		draining:     make(map[frontend]bool),
+	}
+	specs, err := poolSpecs(cfg, members)
+	if err != nil {
+		return nil, err
+	}
+	tlsSettings, certStores, err := b.prepareTLS(cfg)
+	if err != nil {
+		return nil, err
+	}
+	b.certStores = certStores
+	for _, spec := range specs {
+		healthCheck, err := backend.NewHealthCheck(spec.healthCheck, spec.backendPort)
+		if err != nil {
+			return nil, fmt.Errorf("health check for pool %s: %w", spec.name, err)
+		}
+		p, err := b.newPool(cfg, spec, healthCheck)
+		if err != nil {
+			return nil, err
+		}
+		b.pools[spec.name] = p
+	}
+	for _, listener := range cfg.Listeners {
+		b.frontends[frontendKey(listener)] = newFrontend(listener, b.pools[listenerPool(listener)].backendManager, routes(cfg, listener, b.pools), tlsSettings[listener.Name])
+	}
+	return b, nil
+}
+
+// prepareTLS loads the certificates of every listener that terminates TLS and returns the listeners'
+// TLS configurations and certificate stores. Stores whose certificates did not change are reused.
+func (b *Balancer) prepareTLS(cfg *config.AppConfig) (map[string]network.TLS, map[string]*certs.Store, error) {
+	tlsSettings := make(map[string]network.TLS)
+	stores := make(map[string]*certs.Store)
+	for _, listener := range cfg.Listeners {
+		if !listener.TLS.Enabled() {
+			continue
+		}
+		store, exists := b.certStores[listener.Name]
+		if !exists || !reflect.DeepEqual(store.Configs(), listener.TLS.Certificates) {
+			var err error
+			if store, err = certs.NewStore(b.ctx, listener.TLS.Certificates, b.clients); err != nil {
+				stopStores(stores, b.certStores)
+				return nil, nil, fmt.Errorf("certificates of listener %s: %w", listener.Name, err)
+			}
+		}
+		stores[listener.Name] = store
+		backendTLS, err := certs.BackendConfig(listener.TLS.Backend)
+		if err != nil {
+			stopStores(stores, b.certStores)
+			return nil, nil, fmt.Errorf("backend TLS of listener %s: %w", listener.Name, err)
+		}
+		tlsSettings[listener.Name] = network.TLS{Server: certs.ServerConfig(listener.TLS, store), Backend: backendTLS}
+	}
+	return tlsSettings, stores, nil
+}
+
+// stopStores stops the certificate stores that are not in keep
+func stopStores(stores, keep map[string]*certs.Store) {
+	for name, store := range stores {
+		if keep[name] != store {
+			store.Stop()
+		}
+	}
+}
+
+// newPool creates a backend pool with the health check interval, sticky and outlier detection settings of cfg; its
+// health checker is started by startPool. The pool is returned even if watching the nodes fails.
+func (b *Balancer) newPool(cfg *config.AppConfig, spec poolSpec, healthCheck *backend.HealthCheck) (*pool, error) {
+	p := &pool{
+		spec:           spec,
+		backendManager: backend.NewManager(spec.members, cfg.RescanInterval.Duration, healthCheck, cfg.StickyTTL.Duration, cfg.StickyMaxEntries, cfg.OutlierDetection),
+	}
+	if b.nodeInformer != nil && !spec.static {
+		if err := p.backendManager.WatchNodes(b.nodeInformer, spec.nodeSelector); err != nil {
+			return p, fmt.Errorf("watch worker nodes for pool %s: %w", spec.name, err)
+		}
+	}
+	return p, nil
+}
+
+// reusableFor reports whether the pool can be updated to spec rather than replaced, which is the
+// case unless its backends switch between static members and discovered nodes
+func (p *pool) reusableFor(spec poolSpec) bool {
+	return p.spec.static == spec.static
+}
+
+// update applies the settings of cfg and spec to a pool that is reusable for spec; a changed
+// health check is passed in healthCheck, nil otherwise
+func (p *pool) update(cfg *config.AppConfig, spec poolSpec, healthCheck *backend.HealthCheck) {
+	bm := p.backendManager
+	if healthCheck != nil {
+		bm.SetHealthCheck(healthCheck)
+	}
+	bm.SetHealthCheckInterval(cfg.RescanInterval.Duration)
+	bm.SetStickyLimits(cfg.StickyTTL.Duration, cfg.StickyMaxEntries)
+	bm.SetOutlierDetection(cfg.OutlierDetection)
+	if spec.static {
+		bm.SetBackends(spec.members)
+	} else if spec.nodeSelector.String() != p.spec.nodeSelector.String() {
+		log.Infof("[Balancer] Node selector of pool %s changed to %q.", spec.name, spec.nodeSelector)
+		bm.SetNodeSelector(spec.nodeSelector)
+	}
+	p.spec = spec
+}
+
+func (b *Balancer) startPool(p *pool) {
+	ctx, cancel := context.WithCancel(b.ctx)
+	p.stopHealthChecker = cancel
+	go p.backendManager.HealthChecker(ctx)
+}
+
+func (b *Balancer) stopPool(p *pool) {
+	p.stopHealthChecker()
+	if err := p.backendManager.UnwatchNodes(); err != nil {
+		log.Warnf("[Balancer] Failed to stop watching nodes for pool %s: %v", p.spec.name, err)
+	}
+}
+
+// Start starts health checking and, once the pools have healthy backends or had time to find them,
+// accepts connections on every listener
+func (b *Balancer) Start() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var wg sync.WaitGroup
+	for _, p := range b.pools {
+		b.startPool(p)
+		wg.Add(1)
+		go func(p *pool) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(b.ctx, p.backendManager.WarmupTime())
+			defer cancel()
+			if !p.backendManager.WaitHealthy(ctx) {
+				log.Warnf("[Balancer] No healthy backends for pool %s yet, accepting connections anyway.", p.spec.name)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	for _, frontend := range b.frontends {
+		if err := frontend.Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Config returns the configuration currently in effect
+func (b *Balancer) Config() *config.AppConfig {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.cfg
+}
+
+// Reload applies a new configuration: listeners and pools are added and removed, and the algorithm,
+// dial, idle, routing, TLS, health check, sticky, outlier detection and node selector settings and
+// static backends of existing ones are updated.
+// Established connections are left alone. Nothing is changed if the new configuration cannot be applied.
+func (b *Balancer) Reload(cfg *config.AppConfig) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.shutdown {
+		return fmt.Errorf("shutting down")
+	}
+	if cfg.StaticMode() != b.cfg.StaticMode() {
+		return fmt.Errorf("switching between static backends and Kubernetes discovery requires a restart")
+	}
+	for _, listener := range cfg.Listeners {
+		for _, current := range b.cfg.Listeners {
+			if frontendKey(current) == frontendKey(listener) && current.HTTPMode() != listener.HTTPMode() {
+				return fmt.Errorf("changing the mode of the listener on %s requires a restart", listener.ListenAddress())
+			}
+		}
+	}
+	var members []k8sutils.NodeDetails
+	if cfg.StaticMode() {
+		var err error
+		members, err = k8sutils.LoadStaticNodes(cfg.BackendMembers, cfg.BackendMembersFile)
+		if err != nil {
+			return fmt.Errorf("load static backend members: %w", err)
+		}
+	}
+
+	// Prepare everything that can fail before changing anything
+	specs, err := poolSpecs(cfg, members)
+	if err != nil {
+		return err
+	}
+	healthChecks := make(map[string]*backend.HealthCheck)
+	for _, spec := range specs {
+		if p, exists := b.pools[spec.name]; exists && p.reusableFor(spec) && p.spec.healthCheck == spec.healthCheck && p.spec.backendPort == spec.backendPort {
+			continue
+		}
+		healthCheck, err := backend.NewHealthCheck(spec.healthCheck, spec.backendPort)
+		if err != nil {
+			return fmt.Errorf("health check for pool %s: %w", spec.name, err)
+		}
+		healthChecks[spec.name] = healthCheck
+	}
+	tlsSettings, certStores, err := b.prepareTLS(cfg)
+	if err != nil {
+		return err
+	}
+	listeners := make(map[string]io.Closer)
+	for _, listener := range cfg.Listeners {
+		key := frontendKey(listener)
+		if _, exists := b.frontends[key]; exists {
+			continue
+		}
+		socket, err := listen(listener)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			stopStores(certStores, b.certStores)
+			return fmt.Errorf("listen on %s for listener %s: %w", key, listener.Name, err)
+		}
+		listeners[key] = socket
+	}
+
+	for _, setting := range config.RestartRequiredChanges(b.cfg, cfg) {
+		log.Warnf("[Balancer] Setting %s changed, it will take effect after a restart.", setting)
+	}
+
+	pools := make(map[string]*pool, len(specs))
+	for _, spec := range specs {
+		p, exists := b.pools[spec.name]
+		if exists && p.reusableFor(spec) {
+			p.update(cfg, spec, healthChecks[spec.name])
+		} else {
+			var err error
+			p, err = b.newPool(cfg, spec, healthChecks[spec.name])
+			if err != nil {
+				// Only happens if the informer refuses new handlers; keep the pool without backends
+				log.Errorf("[Balancer] %v", err)
+			}
+			b.startPool(p)
+			log.Infof("[Balancer] Added backend pool %s.", spec.name)
+		}
+		pools[spec.name] = p
+	}
+	for name, p := range b.pools {
+		if pools[name] != p {
+			b.stopPool(p)
+			log.Infof("[Balancer] Removed backend pool %s.", name)
+		}
+	}
+
+	frontends := make(map[string]frontend, len(cfg.Listeners))
+	for _, listener := range cfg.Listeners {
+		key := frontendKey(listener)
+		backendManager := pools[listenerPool(listener)].backendManager
+		listenerRoutes := routes(cfg, listener, pools)
+		frontend, exists := b.frontends[key]
+		if exists {
+			frontend.Update(listener, backendManager, listenerRoutes, tlsSettings[listener.Name])
+		} else {
+			frontend = newFrontend(listener, backendManager, listenerRoutes, tlsSettings[listener.Name])
+			go serve(frontend, listeners[key])
+		}
+		frontends[key] = frontend
+	}
+	for key, frontend := range b.frontends {
+		if _, exists := frontends[key]; !exists {
+			frontend.Stop()
+			b.draining[frontend] = true
+			log.Infof("[Balancer] Closed listener on %s, established connections are kept.", key)
+		}
+	}
+	b.forgetDrainedLocked()
+
+	stopStores(b.certStores, certStores)
+
+	b.pools = pools
+	b.frontends = frontends
+	b.certStores = certStores
+	b.cfg = cfg
+	return nil
+}
+
+// forgetDrainedLocked drops the removed frontends whose connections have all finished
+func (b *Balancer) forgetDrainedLocked() {
+	for frontend := range b.draining {
+		if frontend.OpenConnections() == 0 {
+			delete(b.draining, frontend)
+		}
+	}
+}
+
+// Shutdown stops accepting connections on every listener, stops health checking and waits for the
+// established connections to finish, closing those still open when ctx is done. Connections of
+// listeners removed by a reload are drained as well.
+func (b *Balancer) Shutdown(ctx context.Context) []network.DrainStats {
+	b.mutex.Lock()
+	b.shutdown = true
+	b.forgetDrainedLocked()
+	frontends := make([]frontend, 0, len(b.frontends)+len(b.draining))
+	for _, frontend := range b.frontends {
+		frontends = append(frontends, frontend)
+	}
+	for frontend := range b.draining {
+		frontends = append(frontends, frontend)
+	}
+	pools := b.pools
+	certStores := b.certStores
+	b.mutex.Unlock()
+
+	// Stop accepting everywhere first so no listener keeps taking connections while another drains
+	for _, frontend := range frontends {
+		frontend.Stop()
+	}
+	for _, p := range pools {
+		b.stopPool(p)
+	}
+	stopStores(certStores, nil)
+
+	// Drain without holding the mutex, so the admin API and Config keep answering
+	var wg sync.WaitGroup
+	stats := make([]network.DrainStats, 0, len(frontends))
+	var statsMutex sync.Mutex
+	for _, f := range frontends {
+		wg.Add(1)
+		go func(f frontend) {
+			defer wg.Done()
+			listenerStats := f.Shutdown(ctx)
+			statsMutex.Lock()
+			stats = append(stats, listenerStats)
+			statsMutex.Unlock()
+		}(f)
+	}
+	wg.Wait()
+	return stats
+}
+
+// StickyHandler serves the sticky-session admin API of each pool at prefix followed by the listener or pool name;
+// a listener using a named pool serves that pool's bindings. Removing bindings requires adminToken.
+func (b *Balancer) StickyHandler(prefix, adminToken string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		b.mutex.Lock()
+		for _, listener := range b.cfg.Listeners {
+			if listener.Name == name {
+				name = listenerPool(listener)
+				break
+			}
+		}
+		p, exists := b.pools[name]
+		b.mutex.Unlock()
+		if !exists {
+			http.Error(w, "No listener or pool named "+name, http.StatusNotFound)
+			return
+		}
+		p.backendManager.StickyHandler(adminToken).ServeHTTP(w, r)
+	})
+}
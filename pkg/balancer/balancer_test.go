@@ -0,0 +1,448 @@
+package balancer
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+	"github.com/supporttools/GoKubeBalancer/pkg/network"
+)
+
+// usedPorts holds the ports freePort returned, which the kernel may hand out again once their probe is closed
+var usedPorts = make(map[int]bool)
+
+// freePort returns a TCP port on 127.0.0.1 that nothing listens on and that no earlier call returned
+func freePort(t *testing.T) int {
+	t.Helper()
+	for {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+		if !usedPorts[port] {
+			usedPorts[port] = true
+			return port
+		}
+	}
+}
+
+// healthServerPort returns the port of a server answering every health check on 127.0.0.1
+func healthServerPort(t *testing.T) int {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// testListener returns a listener on 127.0.0.1 with the given mode and port
+func testListener(name, mode string, port, healthPort int) config.ListenerConfig {
+	return config.ListenerConfig{
+		Name:         name,
+		Mode:         mode,
+		BindAddress:  "127.0.0.1",
+		FrontendPort: port,
+		BackendPort:  80,
+		Algorithm:    config.AlgorithmRoundRobin,
+		HealthCheck: config.HealthCheckConfig{
+			Scheme:         "http",
+			Port:           healthPort,
+			Path:           "/healthz",
+			ExpectedStatus: "200",
+			Timeout:        config.Seconds(1),
+			Rise:           1,
+			Fall:           1,
+		},
+		DialTimeout: config.Seconds(1),
+		FlowTimeout: config.Seconds(30),
+	}
+}
+
+// startTestBalancer starts a balancer for cfg with static backends and shuts it down when the test ends
+func startTestBalancer(t *testing.T, cfg *config.AppConfig) *Balancer {
+	t.Helper()
+	cfg.RescanInterval = config.Seconds(60)
+	members, err := k8sutils.LoadStaticNodes(cfg.BackendMembers, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(context.Background(), cfg, members, nil, nil)
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	if err := b.Start(); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		b.Shutdown(ctx)
+	})
+	return b
+}
+
+// accepting reports whether a TCP listener accepts connections on port
+func accepting(port int) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func TestReload(t *testing.T) {
+	healthPort := healthServerPort(t)
+	tests := []struct {
+		name        string
+		change      func(cfg *config.AppConfig, port int)
+		wantErr     string
+		wantKept    []string // Listeners and pools whose frontend and pool are reused
+		wantAdded   []string // Listeners and pools that are created
+		wantRemoved []string // Listeners and pools that are gone
+	}{
+		{
+			name:     "unchanged",
+			change:   func(cfg *config.AppConfig, port int) {},
+			wantKept: []string{"a", "b"},
+		},
+		{
+			name: "settings of a listener",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.Listeners[0].Algorithm = config.AlgorithmLeastConnections
+				cfg.Listeners[0].BackendPort = 8080
+				cfg.Listeners[0].DialRetries = 5
+				cfg.Listeners[0].HealthCheck.Path = "/readyz"
+				cfg.BackendMembers = "127.0.0.1,127.0.0.2"
+			},
+			wantKept: []string{"a", "b"},
+		},
+		{
+			name: "pool settings",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.RescanInterval = config.Seconds(30)
+				cfg.StickyTTL = config.Seconds(60)
+				cfg.StickyMaxEntries = 10
+				cfg.OutlierDetection.ConsecutiveErrors = 3
+				cfg.NodeSelector = "role=edge"
+			},
+			wantKept: []string{"a", "b"},
+		},
+		{
+			name: "invalid node selector",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.NodeSelector = "role in ("
+				cfg.Listeners = append(cfg.Listeners, testListener("c", config.ModeTCP, port, healthPort))
+			},
+			wantErr:     "node selector",
+			wantKept:    []string{"a", "b"},
+			wantRemoved: []string{"c"},
+		},
+		{
+			name: "added listener",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.Listeners = append(cfg.Listeners, testListener("c", config.ModeTCP, port, healthPort))
+			},
+			wantKept:  []string{"a", "b"},
+			wantAdded: []string{"c"},
+		},
+		{
+			name: "removed listener",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.Listeners = cfg.Listeners[:1]
+			},
+			wantKept:    []string{"a"},
+			wantRemoved: []string{"b"},
+		},
+		{
+			// The frontend on the address is kept, the listener's pool is replaced
+			name: "renamed listener",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.Listeners[1].Name = "c"
+			},
+			wantKept:    []string{"a"},
+			wantAdded:   []string{"c"},
+			wantRemoved: []string{"b"},
+		},
+		{
+			name: "added pool",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.Pools = append(cfg.Pools, config.PoolConfig{Name: "staging", BackendMembers: "127.0.0.3", HealthCheck: cfg.Listeners[0].HealthCheck})
+				cfg.Listeners[1].SNIRoutes = []config.SNIRoute{{Hosts: []string{"*.staging.example.com"}, Pool: "staging"}}
+			},
+			wantKept:  []string{"a", "b"},
+			wantAdded: []string{"staging"},
+		},
+		{
+			name: "mode change",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.Listeners[0].Mode = config.ModeHTTP
+				cfg.Listeners = append(cfg.Listeners, testListener("c", config.ModeTCP, port, healthPort))
+			},
+			wantErr:     "changing the mode of the listener",
+			wantKept:    []string{"a", "b"},
+			wantRemoved: []string{"c"},
+		},
+		{
+			name: "switch to discovery",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.BackendMembers = ""
+				cfg.Listeners = cfg.Listeners[:1]
+			},
+			wantErr:  "requires a restart",
+			wantKept: []string{"a", "b"},
+		},
+		{
+			name: "invalid health check",
+			change: func(cfg *config.AppConfig, port int) {
+				cfg.Listeners[0].HealthCheck.ExpectedStatus = "ok"
+				cfg.Listeners = append(cfg.Listeners, testListener("c", config.ModeTCP, port, healthPort))
+			},
+			wantErr:     "health check for pool a",
+			wantKept:    []string{"a", "b"},
+			wantRemoved: []string{"c"},
+		},
+		{
+			name: "port in use",
+			change: func(cfg *config.AppConfig, port int) {
+				busy, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { busy.Close() })
+				cfg.Listeners = append(cfg.Listeners,
+					testListener("c", config.ModeTCP, port, healthPort),
+					testListener("d", config.ModeTCP, busy.Addr().(*net.TCPAddr).Port, healthPort))
+			},
+			wantErr:     "listener d",
+			wantKept:    []string{"a", "b"},
+			wantRemoved: []string{"c", "d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.AppConfig{BackendMembers: "127.0.0.1", Listeners: []config.ListenerConfig{
+				testListener("a", config.ModeTCP, freePort(t), healthPort),
+				testListener("b", config.ModeTCP, freePort(t), healthPort),
+			}}
+			b := startTestBalancer(t, cfg)
+			frontends := make(map[string]frontend)
+			for _, listener := range cfg.Listeners {
+				frontends[listener.Name] = b.frontends[frontendKey(listener)]
+			}
+			pools := make(map[string]*pool)
+			for name, p := range b.pools {
+				pools[name] = p
+			}
+
+			next := *cfg
+			next.Listeners = append([]config.ListenerConfig{}, cfg.Listeners...)
+			addedPort := freePort(t)
+			tt.change(&next, addedPort)
+			err := b.Reload(&next)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Reload() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				if b.Config() != cfg {
+					t.Errorf("Reload() replaced the configuration after an error")
+				}
+				if accepting(addedPort) {
+					t.Errorf("Reload() left a new listener open after an error")
+				}
+			} else if err != nil {
+				t.Fatalf("Reload() unexpected error: %v", err)
+			} else if b.Config() != &next {
+				t.Errorf("Reload() did not activate the configuration")
+			}
+
+			listeners := make(map[string]config.ListenerConfig)
+			for _, listener := range b.Config().Listeners {
+				listeners[listener.Name] = listener
+			}
+			for _, name := range tt.wantKept {
+				if p := b.pools[name]; p == nil || p != pools[name] {
+					t.Errorf("pool %s was replaced", name)
+				}
+				if listener, exists := listeners[name]; exists && b.frontends[frontendKey(listener)] != frontends[name] {
+					t.Errorf("frontend of listener %s was replaced", name)
+				}
+			}
+			for _, name := range tt.wantAdded {
+				if b.pools[name] == nil || pools[name] != nil {
+					t.Errorf("pool %s was not added", name)
+				}
+				if listener, exists := listeners[name]; exists && !accepting(listener.FrontendPort) {
+					t.Errorf("listener %s does not accept connections", name)
+				}
+			}
+			for _, name := range tt.wantRemoved {
+				if b.pools[name] != nil {
+					t.Errorf("pool %s was not removed", name)
+				}
+			}
+			if len(b.frontends) != len(b.Config().Listeners) {
+				t.Errorf("%d frontends for %d listeners", len(b.frontends), len(b.Config().Listeners))
+			}
+			for _, listener := range cfg.Listeners {
+				if _, exists := b.frontends[frontendKey(listener)]; !exists && accepting(listener.FrontendPort) {
+					t.Errorf("removed listener %s still accepts connections", listener.Name)
+				}
+			}
+			if p := b.pools["a"]; err == nil && p.spec.healthCheck != next.Listeners[0].HealthCheck {
+				t.Errorf("pool a health check = %+v, want %+v", p.spec.healthCheck, next.Listeners[0].HealthCheck)
+			}
+		})
+	}
+}
+
+// echoServerPort returns the port of a TCP server on 127.0.0.1 that echoes what it reads
+func echoServerPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestShutdownDrainsRemovedListeners(t *testing.T) {
+	healthPort := healthServerPort(t)
+	echoPort := echoServerPort(t)
+	cfg := &config.AppConfig{BackendMembers: "127.0.0.1", Listeners: []config.ListenerConfig{
+		testListener("a", config.ModeTCP, freePort(t), healthPort),
+		testListener("b", config.ModeTCP, freePort(t), healthPort),
+	}}
+	cfg.Listeners[1].BackendPort = echoPort
+	b := startTestBalancer(t, cfg)
+
+	// Hold a connection through listener b across the reload that removes it
+	client, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.Listeners[1].FrontendPort)), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("no echo through listener b: %v", err)
+	}
+
+	next := *cfg
+	next.Listeners = cfg.Listeners[:1]
+	if err := b.Reload(&next); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	done := make(chan []network.DrainStats)
+	go func() { done <- b.Shutdown(ctx) }()
+
+	// The balancer keeps answering while the connection drains
+	answered := make(chan struct{})
+	go func() {
+		b.Config()
+		close(answered)
+	}()
+	select {
+	case <-answered:
+	case <-time.After(250 * time.Millisecond):
+		t.Errorf("Config() blocked while Shutdown was draining")
+	}
+
+	stats := <-done
+	var got *network.DrainStats
+	for i := range stats {
+		if stats[i].Listener == "b" {
+			got = &stats[i]
+		}
+	}
+	if want := (network.DrainStats{Listener: "b", Connections: 1, ForceClosed: 1}); got == nil || *got != want {
+		t.Errorf("Shutdown() stats of listener b = %+v, want %+v", got, want)
+	}
+	if err := b.Reload(cfg); err == nil {
+		t.Errorf("Reload() after Shutdown() returned no error")
+	}
+}
+
+func TestSharedPool(t *testing.T) {
+	healthPort := healthServerPort(t)
+	echoPort := echoServerPort(t)
+	tcpListener := testListener("tcp", config.ModeTCP, freePort(t), healthPort)
+	tcpListener.BackendPort = echoPort
+	tcpListener.Pool = "edge"
+	udpListener := testListener("quic", config.ModeUDP, freePort(t), healthPort)
+	udpListener.Pool = "edge"
+	cfg := &config.AppConfig{
+		BackendMembers: "127.0.0.1",
+		Listeners:      []config.ListenerConfig{tcpListener, udpListener},
+		Pools:          []config.PoolConfig{{Name: "edge", BackendMembers: "127.0.0.1", HealthCheck: tcpListener.HealthCheck}},
+	}
+	b := startTestBalancer(t, cfg)
+	if len(b.pools) != 1 || b.pools["edge"] == nil {
+		t.Fatalf("pools = %v, want only edge", b.pools)
+	}
+
+	// A binding made through the TCP listener is the one the UDP listener uses
+	client, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tcpListener.FrontendPort)), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("no echo through listener tcp: %v", err)
+	}
+	client.Close()
+	stickyStatus := func(name string) int {
+		recorder := httptest.NewRecorder()
+		b.StickyHandler("/sticky/", "").ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/sticky/"+name+"?client=127.0.0.1", nil))
+		return recorder.Code
+	}
+	for _, name := range []string{"edge", "tcp", "quic"} {
+		if status := stickyStatus(name); status != http.StatusOK {
+			t.Errorf("binding of the client via /sticky/%s: status %d, want %d", name, status, http.StatusOK)
+		}
+	}
+
+	// Without a named pool the UDP listener gets a pool of its own
+	next := *cfg
+	next.Listeners = append([]config.ListenerConfig{}, cfg.Listeners...)
+	next.Listeners[1].Pool = ""
+	if err := b.Reload(&next); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if len(b.pools) != 2 || b.pools["quic"] == nil {
+		t.Fatalf("pools after reload = %v, want edge and quic", b.pools)
+	}
+	if status := stickyStatus("quic"); status != http.StatusNotFound {
+		t.Errorf("binding of the client via /sticky/quic after reload: status %d, want %d", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,47 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+)
+
+// ServerConfig returns the TLS configuration that terminates client connections with the store's certificates
+func ServerConfig(t config.TLSConfig, store *Store) *tls.Config {
+	minVersion := uint16(tls.VersionTLS12)
+	if t.MinVersion == config.TLSVersion13 {
+		minVersion = tls.VersionTLS13
+	}
+	return &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: store.GetCertificate,
+	}
+}
+
+// BackendConfig returns the TLS configuration for connections to the backends, or nil if they are
+// plain TCP. Without a configured server name, TCP listeners send the client's server name and HTTP
+// listeners the backend address, since their backend connections are shared between hosts.
+func BackendConfig(t config.BackendTLSConfig) (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		caPEM, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read backend CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in backend CA file %s", t.CAFile)
+		}
+	}
+	return tlsConfig, nil
+}
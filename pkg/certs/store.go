@@ -0,0 +1,233 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+	"github.com/supporttools/GoKubeBalancer/pkg/logging"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var log = logging.SetupLogging()
+
+// fileWatchInterval is how often certificate and key files are checked for changes
+const fileWatchInterval = 10 * time.Second
+
+// Store holds the certificates of a TLS listener and replaces them when their files or Secrets
+// change. A certificate that fails to load keeps its previous version.
+type Store struct {
+	mutex        sync.RWMutex
+	configs      []config.CertificateConfig
+	certificates []*tls.Certificate // In configuration order, the first one is the default
+	stop         context.CancelFunc
+}
+
+// secretSource fetches and watches the Secrets holding certificates
+type secretSource struct {
+	get      func(ctx context.Context, namespace, name string) (*v1.Secret, error)
+	informer func(namespace, name string) cache.SharedIndexInformer
+}
+
+// clientSecrets reads Secrets through clients, returning nil without Kubernetes discovery
+func clientSecrets(clients *k8sutils.ClientManager) *secretSource {
+	if clients == nil {
+		return nil
+	}
+	return &secretSource{
+		get: func(ctx context.Context, namespace, name string) (*v1.Secret, error) {
+			return k8sutils.GetSecret(ctx, clients, namespace, name)
+		},
+		informer: func(namespace, name string) cache.SharedIndexInformer {
+			return k8sutils.NewSecretInformer(clients, namespace, name)
+		},
+	}
+}
+
+// NewStore loads every certificate and keeps them current until Stop is called. Secrets are read
+// through clients, which may be nil if no certificate comes from a Secret.
+func NewStore(ctx context.Context, configs []config.CertificateConfig, clients *k8sutils.ClientManager) (*Store, error) {
+	return newStore(ctx, configs, clientSecrets(clients))
+}
+
+func newStore(ctx context.Context, configs []config.CertificateConfig, secrets *secretSource) (*Store, error) {
+	s := &Store{configs: configs, certificates: make([]*tls.Certificate, len(configs))}
+	for i, certificateConfig := range configs {
+		var certificate *tls.Certificate
+		var err error
+		if certificateConfig.Secret != "" {
+			certificate, err = loadSecret(ctx, secrets, certificateConfig)
+		} else {
+			certificate, err = loadFiles(certificateConfig)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.certificates[i] = certificate
+	}
+
+	ctx, s.stop = context.WithCancel(ctx)
+	go config.WatchFiles(ctx, fileWatchInterval, s.files, s.reloadFiles)
+	for i, certificateConfig := range configs {
+		if certificateConfig.Secret == "" {
+			continue
+		}
+		if err := s.watchSecret(ctx, secrets, i); err != nil {
+			s.stop()
+			return nil, fmt.Errorf("watch secret %s: %w", certificateConfig.Secret, err)
+		}
+	}
+	return s, nil
+}
+
+// Configs returns the certificate configuration the store was created with
+func (s *Store) Configs() []config.CertificateConfig {
+	return s.configs
+}
+
+// Stop stops watching the certificate files and Secrets
+func (s *Store) Stop() {
+	s.stop()
+}
+
+// GetCertificate returns the first certificate valid for the client's server name and supported
+// by the client, or the default certificate if there is none. It is used as tls.Config.GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, certificate := range s.certificates {
+		if hello.SupportsCertificate(certificate) == nil {
+			return certificate, nil
+		}
+	}
+	return s.certificates[0], nil
+}
+
+func (s *Store) set(i int, certificate *tls.Certificate) {
+	s.mutex.Lock()
+	s.certificates[i] = certificate
+	s.mutex.Unlock()
+}
+
+// files returns the certificate and key files to watch
+func (s *Store) files() []string {
+	var paths []string
+	for _, certificateConfig := range s.configs {
+		paths = append(paths, certificateConfig.CertFile, certificateConfig.KeyFile)
+	}
+	return paths
+}
+
+// reloadFiles reloads every certificate read from files
+func (s *Store) reloadFiles() {
+	for i, certificateConfig := range s.configs {
+		if certificateConfig.Secret != "" {
+			continue
+		}
+		certificate, err := loadFiles(certificateConfig)
+		if err != nil {
+			log.Errorf("[TLS] Keeping the previous certificate: %v", err)
+			continue
+		}
+		s.mutex.RLock()
+		unchanged := bytes.Equal(s.certificates[i].Leaf.Raw, certificate.Leaf.Raw)
+		s.mutex.RUnlock()
+		if unchanged {
+			continue
+		}
+		s.set(i, certificate)
+		log.Infof("[TLS] Reloaded certificate %s.", certificateConfig.CertFile)
+	}
+}
+
+// watchSecret replaces certificate i whenever its Secret is updated
+func (s *Store) watchSecret(ctx context.Context, secrets *secretSource, i int) error {
+	certificateConfig := s.configs[i]
+	namespace, name := certificateConfig.SecretName()
+	update := func(obj interface{}) {
+		secret, ok := obj.(*v1.Secret)
+		if !ok {
+			return
+		}
+		certificate, err := parseSecret(secret)
+		if err != nil {
+			log.Errorf("[TLS] Keeping the previous certificate from secret %s: %v", certificateConfig.Secret, err)
+			return
+		}
+		s.set(i, certificate)
+		log.Debugf("[TLS] Loaded certificate from secret %s.", certificateConfig.Secret)
+	}
+
+	informer := secrets.informer(namespace, name)
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, newObj interface{}) { update(newObj) },
+		DeleteFunc: func(interface{}) {
+			log.Warnf("[TLS] Secret %s was deleted, keeping its last certificate.", certificateConfig.Secret)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	go informer.Run(ctx.Done())
+	return nil
+}
+
+func loadFiles(certificateConfig config.CertificateConfig) (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certificateConfig.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(certificateConfig.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	certificate, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %w", certificateConfig.CertFile, err)
+	}
+	return certificate, nil
+}
+
+func loadSecret(ctx context.Context, secrets *secretSource, certificateConfig config.CertificateConfig) (*tls.Certificate, error) {
+	if secrets == nil {
+		return nil, fmt.Errorf("secret %s requires Kubernetes discovery", certificateConfig.Secret)
+	}
+	namespace, name := certificateConfig.SecretName()
+	secret, err := secrets.get(ctx, namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("get secret %s: %w", certificateConfig.Secret, err)
+	}
+	certificate, err := parseSecret(secret)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %w", certificateConfig.Secret, err)
+	}
+	return certificate, nil
+}
+
+// parseSecret reads the certificate of a kubernetes.io/tls Secret
+func parseSecret(secret *v1.Secret) (*tls.Certificate, error) {
+	return parseKeyPair(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey])
+}
+
+// parseKeyPair parses a PEM certificate chain and key, keeping the parsed leaf for certificate selection
+func parseKeyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if certificate.Leaf == nil {
+		if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &certificate, nil
+}
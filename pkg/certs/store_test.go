@@ -0,0 +1,266 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// testKeyPair returns a PEM certificate named commonName for the given DNS names and its key
+func testKeyPair(t *testing.T, commonName string, dnsNames ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeKeyPair writes a certificate and key to files in dir and returns their configuration
+func writeKeyPair(t *testing.T, dir, name string, certPEM, keyPEM []byte) config.CertificateConfig {
+	t.Helper()
+	certificateConfig := config.CertificateConfig{CertFile: filepath.Join(dir, name+".crt"), KeyFile: filepath.Join(dir, name+".key")}
+	if err := os.WriteFile(certificateConfig.CertFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certificateConfig.KeyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certificateConfig
+}
+
+// certificateFiles writes a new certificate named commonName to files in dir and returns their configuration
+func certificateFiles(t *testing.T, dir, commonName string, dnsNames ...string) config.CertificateConfig {
+	t.Helper()
+	certPEM, keyPEM := testKeyPair(t, commonName, dnsNames...)
+	return writeKeyPair(t, dir, commonName, certPEM, keyPEM)
+}
+
+// servedName returns the common name of the certificate the store offers a TLS 1.3 client asking for serverName
+func servedName(t *testing.T, s *Store, serverName string) string {
+	t.Helper()
+	certificate, err := s.GetCertificate(&tls.ClientHelloInfo{
+		ServerName:        serverName,
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate.Leaf.Subject.CommonName
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStoreGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	configs := []config.CertificateConfig{
+		certificateFiles(t, dir, "default", "example.com"),
+		certificateFiles(t, dir, "app", "app.example.com"),
+		certificateFiles(t, dir, "wildcard", "*.example.com"),
+	}
+	s, err := NewStore(context.Background(), configs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{serverName: "example.com", want: "default"},
+		{serverName: "app.example.com", want: "app"},
+		{serverName: "APP.example.com", want: "app"},
+		{serverName: "api.example.com", want: "wildcard"},
+		{serverName: "a.b.example.com", want: "default"},
+		{serverName: "other.org", want: "default"},
+		{serverName: "", want: "default"},
+	}
+	for _, tt := range tests {
+		if got := servedName(t, s, tt.serverName); got != tt.want {
+			t.Errorf("certificate for %q = %s, want %s", tt.serverName, got, tt.want)
+		}
+	}
+}
+
+func TestStoreFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM := testKeyPair(t, "v1", "app.example.com")
+	certificateConfig := writeKeyPair(t, dir, "app", certPEM, keyPEM)
+	s, err := NewStore(context.Background(), []config.CertificateConfig{certificateConfig}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	// A renewed certificate is used for new connections
+	certPEM, keyPEM = testKeyPair(t, "v2", "app.example.com")
+	writeKeyPair(t, dir, "app", certPEM, keyPEM)
+	s.reloadFiles()
+	if got := servedName(t, s, "app.example.com"); got != "v2" {
+		t.Fatalf("certificate after renewal = %s, want v2", got)
+	}
+
+	// A certificate that does not load, such as one whose key is not written yet, keeps the previous one
+	newCertPEM, _ := testKeyPair(t, "v3", "app.example.com")
+	writeKeyPair(t, dir, "app", newCertPEM, keyPEM)
+	s.reloadFiles()
+	if got := servedName(t, s, "app.example.com"); got != "v2" {
+		t.Errorf("certificate after a mismatched key = %s, want v2", got)
+	}
+	os.Remove(certificateConfig.CertFile)
+	s.reloadFiles()
+	if got := servedName(t, s, "app.example.com"); got != "v2" {
+		t.Errorf("certificate after removing the file = %s, want v2", got)
+	}
+}
+
+// fakeSecrets reads Secrets from a fake clientset
+func fakeSecrets(client *fake.Clientset) *secretSource {
+	return &secretSource{
+		get: func(ctx context.Context, namespace, name string) (*v1.Secret, error) {
+			return client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		informer: func(namespace, name string) cache.SharedIndexInformer {
+			return informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace)).Core().V1().Secrets().Informer()
+		},
+	}
+}
+
+func tlsSecret(t *testing.T, commonName string) *v1.Secret {
+	t.Helper()
+	certPEM, keyPEM := testKeyPair(t, commonName, "app.example.com")
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ingress", Name: "app-tls"},
+		Type:       v1.SecretTypeTLS,
+		Data:       map[string][]byte{v1.TLSCertKey: certPEM, v1.TLSPrivateKeyKey: keyPEM},
+	}
+}
+
+func TestStoreSecret(t *testing.T) {
+	client := fake.NewSimpleClientset(tlsSecret(t, "v1"))
+	secrets := client.CoreV1().Secrets("ingress")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := newStore(ctx, []config.CertificateConfig{{Secret: "ingress/app-tls"}}, fakeSecrets(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if got := servedName(t, s, "app.example.com"); got != "v1" {
+		t.Fatalf("certificate = %s, want v1", got)
+	}
+
+	if _, err := secrets.Update(ctx, tlsSecret(t, "v2"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the updated certificate", func() bool { return servedName(t, s, "app.example.com") == "v2" })
+
+	// An update that does not parse and the deletion of the Secret keep the last certificate
+	broken := tlsSecret(t, "v3")
+	broken.Data[v1.TLSPrivateKeyKey] = []byte("not a key")
+	if _, err := secrets.Update(ctx, broken, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.Delete(ctx, "app-tls", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := servedName(t, s, "app.example.com"); got != "v2" {
+		t.Errorf("certificate after a broken update and deletion = %s, want v2", got)
+	}
+}
+
+func TestNewStoreSecretErrors(t *testing.T) {
+	stopped := make(chan struct{})
+	close(stopped)
+	tests := []struct {
+		name    string
+		secrets func(client *fake.Clientset) *secretSource
+		wantErr string
+	}{
+		{
+			name:    "without discovery",
+			secrets: func(*fake.Clientset) *secretSource { return nil },
+			wantErr: "secret ingress/app-tls requires Kubernetes discovery",
+		},
+		{
+			name: "missing secret",
+			secrets: func(client *fake.Clientset) *secretSource {
+				client.CoreV1().Secrets("ingress").Delete(context.Background(), "app-tls", metav1.DeleteOptions{})
+				return fakeSecrets(client)
+			},
+			wantErr: "get secret ingress/app-tls",
+		},
+		{
+			name: "informer refuses handlers",
+			secrets: func(client *fake.Clientset) *secretSource {
+				source := fakeSecrets(client)
+				newInformer := source.informer
+				source.informer = func(namespace, name string) cache.SharedIndexInformer {
+					informer := newInformer(namespace, name)
+					informer.Run(stopped)
+					return informer
+				}
+				return source
+			},
+			wantErr: "watch secret ingress/app-tls",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tlsSecret(t, "v1"))
+			_, err := newStore(context.Background(), []config.CertificateConfig{{Secret: "ingress/app-tls"}}, tt.secrets(client))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("newStore() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,65 +1,347 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // AppConfig structure for environment-based configurations.
 type AppConfig struct {
-	Debug              bool          `json:"debug"`
-	MetricsPort        int           `json:"metricsPort"`
-	InsecureSkipVerify bool          `json:"insecureSkipVerify"`
-	FrontendHttpPort   int           `json:"frontendHttpPort"`
-	FrontendHttpsPort  int           `json:"frontendHttpsPort"`
-	BackendHttpPort    int           `json:"backendHttpPort"`
-	BackendHttpsPort   int           `json:"backendHttpsPort"`
-	NodeSelector       string        `json:"nodeSelector"`
-	NewNodeThreshold   time.Duration `json:"newNodeThreshold"`
-	RescanInterval     time.Duration `json:"rescanInterval"`
-	RancherAPI         string        `json:"rancherAPI"`
-	RancherKey         string        `json:"rancherKey"`
-	RancherCluster     string        `json:"rancherCluster"`
+	Debug               bool                   `json:"debug"`
+	MetricsPort         int                    `json:"metricsPort"`
+	InsecureSkipVerify  bool                   `json:"insecureSkipVerify"`
+	FrontendHttpPort    int                    `json:"frontendHttpPort"`
+	FrontendHttpsPort   int                    `json:"frontendHttpsPort"`
+	BackendHttpPort     int                    `json:"backendHttpPort"`
+	BackendHttpsPort    int                    `json:"backendHttpsPort"`
+	DialTimeout         Duration               `json:"dialTimeout"`
+	DialRetries         int                    `json:"dialRetries"`
+	IdleTimeout         Duration               `json:"idleTimeout"`
+	FlowTimeout         Duration               `json:"flowTimeout"`
+	ShutdownTimeout     Duration               `json:"shutdownTimeout"`
+	ProxyProtocol       string                 `json:"proxyProtocol"`
+	AcceptProxyProtocol bool                   `json:"acceptProxyProtocol"`
+	TrustedProxies      []string               `json:"trustedProxies"`
+	StickyTTL           Duration               `json:"stickyTTL"`
+	StickyMaxEntries    int                    `json:"stickyMaxEntries"`
+	StickyAdminToken    string                 `json:"stickyAdminToken"`
+	NodeSelector        string                 `json:"nodeSelector"`
+	WeightSource        string                 `json:"weightSource"`
+	WeightKey           string                 `json:"weightKey"`
+	NewNodeThreshold    Duration               `json:"newNodeThreshold"`
+	RescanInterval      Duration               `json:"rescanInterval"`
+	KubeconfigSource    string                 `json:"kubeconfigSource"`
+	Kubeconfig          string                 `json:"kubeconfig"`
+	KubeContext         string                 `json:"kubeContext"`
+	RancherAPI          string                 `json:"rancherAPI"`
+	RancherKey          string                 `json:"rancherKey"`
+	RancherCluster      string                 `json:"rancherCluster"`
+	BackendMembers      string                 `json:"backendMembers"`
+	BackendMembersFile  string                 `json:"backendMembersFile"`
+	HealthCheck         HealthCheckConfig      `json:"healthCheck"`
+	OutlierDetection    OutlierDetectionConfig `json:"outlierDetection"`
+	Listeners           []ListenerConfig       `json:"listeners"`
+	Pools               []PoolConfig           `json:"pools"`
+	ConfigFile          string                 `json:"-"` // Configuration file the settings were read from, if any
+}
+
+// OutlierDetectionConfig controls passive health checking based on real connection failures.
+type OutlierDetectionConfig struct {
+	ConsecutiveErrors  int      `json:"consecutiveErrors"`  // Failures in a row before ejection, 0 disables outlier detection
+	BaseEjectionTime   Duration `json:"baseEjectionTime"`   // Ejection time, multiplied by the number of recent ejections
+	MaxEjectionTime    Duration `json:"maxEjectionTime"`    // Upper bound for a single ejection
+	MaxEjectionPercent int      `json:"maxEjectionPercent"` // Maximum share of a pool that may be ejected at once, but at least one backend
+}
+
+// HealthCheckConfig defines the active health check run against every backend of a listener.
+type HealthCheckConfig struct {
+	Scheme             string   `json:"scheme"`             // http or https
+	Port               int      `json:"port"`               // Port on the backend to probe, 0 for the port its traffic goes to
+	Path               string   `json:"path"`               // Request path
+	Host               string   `json:"host"`               // Host header, defaults to the backend address
+	SNI                string   `json:"sni"`                // TLS server name, defaults to Host
+	CAFile             string   `json:"caFile"`             // PEM bundle used to verify the backend certificate
+	InsecureSkipVerify bool     `json:"insecureSkipVerify"` // Skip verification of the backend certificate
+	ExpectedStatus     string   `json:"expectedStatus"`     // Accepted status codes and ranges, e.g. "200-299,301"
+	BodyMatch          string   `json:"bodyMatch"`          // Substring the response body must contain
+	Timeout            Duration `json:"timeout"`            // Maximum duration of a single probe
+	Rise               int      `json:"rise"`               // Consecutive successes before a backend is marked healthy
+	Fall               int      `json:"fall"`               // Consecutive failures before a backend is marked unhealthy
+	Jitter             Duration `json:"jitter"`             // Maximum random delay added before each probe
+	ProxyProtocol      string   `json:"proxyProtocol"`      // PROXY protocol version announcing probes as LOCAL connections, empty for none
+}
+
+// StatusRange is an inclusive range of accepted HTTP status codes.
+type StatusRange struct {
+	Min int
+	Max int
 }
 
 var CFG AppConfig
 
-// LoadConfiguration loads configuration from environment variables.
-func LoadConfiguration() error {
-	CFG.Debug = parseEnvBool("DEBUG", false)                                                   // Assuming false as the default value
-	CFG.MetricsPort = parseEnvInt("METRICS_PORT", 9099)                                        // Assuming 9099 as the default port
-	CFG.InsecureSkipVerify = parseEnvBool("INSECURE_SKIP_VERIFY", false)                       // Assuming false as the default value
-	CFG.FrontendHttpPort = parseEnvInt("FRONTEND_HTTP_PORT", 80)                               // Assuming 80 as the default port
-	CFG.FrontendHttpsPort = parseEnvInt("FRONTEND_HTTPS_PORT", 443)                            // Assuming 443 as the default port
-	CFG.BackendHttpPort = parseEnvInt("BACKEND_HTTP_PORT", 80)                                 // Assuming 80 as the default port
-	CFG.BackendHttpsPort = parseEnvInt("BACKEND_HTTPS_PORT", 443)                              // Assuming 443 as the default port
-	CFG.NodeSelector = getEnvOrDefault("NODE_SELECTOR", "node-role.kubernetes.io/worker=true") // Node Selector for selecting backend members
-	CFG.RancherAPI = getEnvOrDefault("RANCHER_API", "https://rancher.example.com")             // Rancher API URL
-	CFG.RancherKey = getEnvOrDefault("RANCHER_KEY", "")                                        // Rancher API Key access:secret
-	CFG.RancherCluster = getEnvOrDefault("RANCHER_CLUSTER", "local")                           // Rancher cluster name
-	CFG.NewNodeThreshold = time.Duration(parseEnvInt("NEW_NODE_THRESHOLD", 15)) * time.Minute  // Assuming 60 minutes as the default threshold, this gives the node time to warm up before being considered healthy
-	CFG.RescanInterval = time.Duration(parseEnvInt("RESCAN_INTERVAL", 5)) * time.Second        // Time interval for rescanning the backend members
+// Supported load balancing algorithms.
+const (
+	AlgorithmRoundRobin               = "round-robin"
+	AlgorithmLeastConnections         = "least-connections"
+	AlgorithmWeightedRoundRobin       = "weighted-round-robin"
+	AlgorithmWeightedLeastConnections = "weighted-least-connections"
+	AlgorithmConsistentHash           = "consistent-hash"
+	AlgorithmConsistentHashIPPort     = "consistent-hash-ip-port"
+)
 
-	// Validate the configuration
-	if err := ValidateConfiguration(&CFG); err != nil {
-		log.Fatalf("Invalid configuration: %v", err)
-		os.Exit(1)
+// Supported PROXY protocol versions; an empty value disables the header.
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// Supported sources for backend weights.
+const (
+	WeightSourceNone       = "none"
+	WeightSourceAnnotation = "annotation"
+	WeightSourceLabel      = "label"
+	WeightSourceCPU        = "cpu"
+)
+
+// Supported sources for the Kubernetes client configuration.
+const (
+	KubeconfigSourceRancher    = "rancher"
+	KubeconfigSourceInCluster  = "in-cluster"
+	KubeconfigSourceKubeconfig = "kubeconfig"
+)
+
+// defaultConfig returns the settings used when neither the configuration file, the environment nor flags set them.
+func defaultConfig() AppConfig {
+	return AppConfig{
+		MetricsPort:       9099,
+		FrontendHttpPort:  80,
+		FrontendHttpsPort: 443,
+		BackendHttpPort:   80,
+		BackendHttpsPort:  443,
+		DialTimeout:       Seconds(5),
+		DialRetries:       2,
+		IdleTimeout:       Seconds(300),
+		FlowTimeout:       Seconds(30),
+		ShutdownTimeout:   Seconds(30),
+		StickyTTL:         Seconds(1800),
+		StickyMaxEntries:  100000,
+		NodeSelector:      "node-role.kubernetes.io/worker=true",
+		WeightSource:      WeightSourceNone,
+		WeightKey:         "gokubebalancer.io/weight",
+		KubeconfigSource:  KubeconfigSourceRancher,
+		RancherAPI:        "https://rancher.example.com",
+		RancherCluster:    "local",
+		NewNodeThreshold:  Duration{15 * time.Minute}, // This gives the node time to warm up before being considered healthy
+		RescanInterval:    Seconds(5),
+		HealthCheck: HealthCheckConfig{
+			Scheme:         "http",
+			Port:           80,
+			Path:           "/healthz",
+			ExpectedStatus: "200",
+			Timeout:        Seconds(3),
+			Rise:           2,
+			Fall:           3,
+			Jitter:         Duration{time.Second},
+		},
+		OutlierDetection: OutlierDetectionConfig{
+			ConsecutiveErrors:  5,
+			BaseEjectionTime:   Seconds(30),
+			MaxEjectionTime:    Seconds(300),
+			MaxEjectionPercent: 50,
+		},
+	}
+}
+
+// LoadConfiguration reads the configuration and makes it the active configuration in CFG.
+func LoadConfiguration() error {
+	cfg, err := ReadConfiguration()
+	if err != nil {
+		return err
 	}
+	CFG = *cfg
 	log.Printf("Configuration validated")
 	return nil
 }
 
-func getBackendMembers() []string {
-	backendMembers := os.Getenv("BACKEND_MEMBERS")
-	if backendMembers == "" {
-		log.Println("No backend members configured")
-		return nil
+// ReadConfiguration reads and validates the configuration without activating it. Each setting is taken from
+// the first source that sets it: command line flags, environment variables, the configuration file, then
+// the built-in defaults.
+func ReadConfiguration() (*AppConfig, error) {
+	cfg := defaultConfig()
+	cfg.ConfigFile = configFilePath()
+
+	var listeners, pools []json.RawMessage
+	if cfg.ConfigFile != "" {
+		fileListeners, filePools, err := loadConfigFile(cfg.ConfigFile, &cfg)
+		if err != nil {
+			return nil, err
+		}
+		listeners, pools = fileListeners, filePools
+	}
+
+	loadEnvironment(&cfg)
+	applyFlags(&cfg)
+
+	// LISTENERS replaces the listeners of the configuration file
+	if spec, exists := os.LookupEnv("LISTENERS"); exists && spec != "" {
+		if err := decodeStrict([]byte(spec), &listeners); err != nil {
+			return nil, fmt.Errorf("LISTENERS: %w", err)
+		}
+	}
+	// POOLS replaces the pools of the configuration file
+	if spec, exists := os.LookupEnv("POOLS"); exists && spec != "" {
+		if err := decodeStrict([]byte(spec), &pools); err != nil {
+			return nil, fmt.Errorf("POOLS: %w", err)
+		}
+	}
+	var err error
+	cfg.Listeners, err = buildListeners(&cfg, listeners, ListenerConfig{
+		BindAddress:         "0.0.0.0",
+		Algorithm:           AlgorithmRoundRobin,
+		HealthCheck:         cfg.HealthCheck,
+		DialTimeout:         cfg.DialTimeout,
+		DialRetries:         cfg.DialRetries,
+		IdleTimeout:         cfg.IdleTimeout,
+		FlowTimeout:         cfg.FlowTimeout,
+		ProxyProtocol:       cfg.ProxyProtocol,
+		AcceptProxyProtocol: cfg.AcceptProxyProtocol,
+		TrustedProxies:      cfg.TrustedProxies,
+		WarmPool:            WarmPoolConfig{MaxIdle: Seconds(30)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cfg.Pools, err = buildPools(pools, PoolConfig{HealthCheck: cfg.HealthCheck})
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the configuration
+	if err := ValidateConfiguration(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
-	return []string{backendMembers}
-	// TODO: Parse backend members from a file or a list of IPs
+	return &cfg, nil
+}
+
+// loadEnvironment overrides the current settings with the environment variables that are set.
+func loadEnvironment(cfg *AppConfig) {
+	cfg.Debug = parseEnvBool("DEBUG", cfg.Debug)                                                           // Enable debug logging
+	cfg.MetricsPort = parseEnvInt("METRICS_PORT", cfg.MetricsPort)                                         // Port of the metrics and admin server
+	cfg.InsecureSkipVerify = parseEnvBool("INSECURE_SKIP_VERIFY", cfg.InsecureSkipVerify)                  // Skip TLS verification of the Rancher API
+	cfg.FrontendHttpPort = parseEnvInt("FRONTEND_HTTP_PORT", cfg.FrontendHttpPort)                         // Frontend port of the default http listener
+	cfg.FrontendHttpsPort = parseEnvInt("FRONTEND_HTTPS_PORT", cfg.FrontendHttpsPort)                      // Frontend port of the default https listener
+	cfg.BackendHttpPort = parseEnvInt("BACKEND_HTTP_PORT", cfg.BackendHttpPort)                            // Backend port of the default http listener
+	cfg.BackendHttpsPort = parseEnvInt("BACKEND_HTTPS_PORT", cfg.BackendHttpsPort)                         // Backend port of the default https listener
+	cfg.DialTimeout = parseEnvDuration("DIAL_TIMEOUT", cfg.DialTimeout)                                    // Default timeout for each backend dial attempt
+	cfg.DialRetries = parseEnvInt("DIAL_RETRIES", cfg.DialRetries)                                         // Default number of other healthy backends to try when a dial fails
+	cfg.IdleTimeout = parseEnvDuration("IDLE_TIMEOUT", cfg.IdleTimeout)                                    // Default idle time before a proxied connection is closed, 0 disables
+	cfg.FlowTimeout = parseEnvDuration("FLOW_TIMEOUT", cfg.FlowTimeout)                                    // Default idle time before a UDP flow is forgotten
+	cfg.ShutdownTimeout = parseEnvDuration("SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout)                        // Time connections get to finish on shutdown before they are closed
+	cfg.ProxyProtocol = getEnvOrDefault("PROXY_PROTOCOL", cfg.ProxyProtocol)                               // Default PROXY protocol version sent to the backends, empty for none
+	cfg.AcceptProxyProtocol = parseEnvBool("ACCEPT_PROXY_PROTOCOL", cfg.AcceptProxyProtocol)               // Read a PROXY protocol header from clients by default
+	cfg.TrustedProxies = parseEnvList("TRUSTED_PROXIES", cfg.TrustedProxies)                               // Default addresses and CIDRs allowed to send PROXY protocol headers
+	cfg.StickyTTL = parseEnvDuration("STICKY_TTL", cfg.StickyTTL)                                          // Idle time before a client loses its backend binding, 0 disables expiry
+	cfg.StickyMaxEntries = parseEnvInt("STICKY_MAX_ENTRIES", cfg.StickyMaxEntries)                         // Maximum number of client bindings, least recently used are evicted first
+	cfg.StickyAdminToken = getEnvOrDefault("STICKY_ADMIN_TOKEN", cfg.StickyAdminToken)                     // Bearer token required to remove sticky bindings via the admin API, empty disables removal
+	cfg.NodeSelector = getEnvOrDefault("NODE_SELECTOR", cfg.NodeSelector)                                  // Node Selector for selecting backend members
+	cfg.WeightSource = getEnvOrDefault("WEIGHT_SOURCE", cfg.WeightSource)                                  // Where node weights come from: none, annotation, label or cpu
+	cfg.WeightKey = getEnvOrDefault("WEIGHT_KEY", cfg.WeightKey)                                           // Annotation or label holding the node weight
+	cfg.KubeconfigSource = getEnvOrDefault("KUBECONFIG_SOURCE", cfg.KubeconfigSource)                      // Where the Kubernetes client configuration comes from: rancher, in-cluster or kubeconfig
+	cfg.Kubeconfig = getEnvOrDefault("KUBECONFIG", cfg.Kubeconfig)                                         // Kubeconfig path(s) for the kubeconfig source, defaults to ~/.kube/config
+	cfg.KubeContext = getEnvOrDefault("KUBE_CONTEXT", cfg.KubeContext)                                     // Kubeconfig context for the kubeconfig source, defaults to the current context
+	cfg.RancherAPI = getEnvOrDefault("RANCHER_API", cfg.RancherAPI)                                        // Rancher API URL
+	cfg.RancherKey = getEnvOrDefault("RANCHER_KEY", cfg.RancherKey)                                        // Rancher API Key access:secret
+	cfg.RancherCluster = getEnvOrDefault("RANCHER_CLUSTER", cfg.RancherCluster)                            // Rancher cluster name
+	cfg.NewNodeThreshold = parseLegacyEnvDuration("NEW_NODE_THRESHOLD", cfg.NewNodeThreshold, time.Minute) // Age before a new node is considered healthy
+	cfg.RescanInterval = parseLegacyEnvDuration("RESCAN_INTERVAL", cfg.RescanInterval, time.Second)        // Time interval for rescanning the backend members
+	cfg.BackendMembers = getEnvOrDefault("BACKEND_MEMBERS", cfg.BackendMembers)                            // Static list of backend members, disables Kubernetes discovery
+	cfg.BackendMembersFile = getEnvOrDefault("BACKEND_MEMBERS_FILE", cfg.BackendMembersFile)               // File containing static backend members
+
+	// Health checks default to HEALTH_CHECK_* and can be overridden per listener
+	cfg.HealthCheck = loadHealthCheck("HEALTH_CHECK_", cfg.HealthCheck)
+
+	od := &cfg.OutlierDetection
+	od.ConsecutiveErrors = parseEnvInt("OUTLIER_CONSECUTIVE_ERRORS", od.ConsecutiveErrors)     // Connection failures in a row before a backend is ejected, 0 disables
+	od.BaseEjectionTime = parseEnvDuration("OUTLIER_BASE_EJECTION_TIME", od.BaseEjectionTime)  // First ejection lasts this long, repeated ejections last longer
+	od.MaxEjectionTime = parseEnvDuration("OUTLIER_MAX_EJECTION_TIME", od.MaxEjectionTime)     // Cap for escalating ejections
+	od.MaxEjectionPercent = parseEnvInt("OUTLIER_MAX_EJECTION_PERCENT", od.MaxEjectionPercent) // Never eject more than this share of a pool
+}
+
+// loadHealthCheck reads the health check variables with the given prefix, falling back to defaults
+func loadHealthCheck(prefix string, defaults HealthCheckConfig) HealthCheckConfig {
+	return HealthCheckConfig{
+		Scheme:             getEnvOrDefault(prefix+"SCHEME", defaults.Scheme),
+		Port:               parseEnvInt(prefix+"PORT", defaults.Port),
+		Path:               getEnvOrDefault(prefix+"PATH", defaults.Path),
+		Host:               getEnvOrDefault(prefix+"HOST", defaults.Host),
+		SNI:                getEnvOrDefault(prefix+"SNI", defaults.SNI),
+		CAFile:             getEnvOrDefault(prefix+"CA_FILE", defaults.CAFile),
+		InsecureSkipVerify: parseEnvBool(prefix+"INSECURE_SKIP_VERIFY", defaults.InsecureSkipVerify),
+		ExpectedStatus:     getEnvOrDefault(prefix+"EXPECTED_STATUS", defaults.ExpectedStatus),
+		BodyMatch:          getEnvOrDefault(prefix+"BODY_MATCH", defaults.BodyMatch),
+		Timeout:            parseEnvDuration(prefix+"TIMEOUT", defaults.Timeout),
+		Rise:               parseEnvInt(prefix+"RISE", defaults.Rise),
+		Fall:               parseEnvInt(prefix+"FALL", defaults.Fall),
+		Jitter:             parseEnvDuration(prefix+"JITTER", defaults.Jitter),
+		ProxyProtocol:      getEnvOrDefault(prefix+"PROXY_PROTOCOL", defaults.ProxyProtocol),
+	}
+}
+
+// ParseCIDRs parses a list of CIDRs; plain IP addresses match only themselves
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		if ip := net.ParseIP(item); ip != nil {
+			if ipv4 := ip.To4(); ipv4 != nil {
+				ip = ipv4
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ParseStatusRanges parses a comma separated list of status codes and ranges such as "200-299,301"
+func ParseStatusRanges(spec string) ([]StatusRange, error) {
+	var ranges []StatusRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		minStr, maxStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			maxStr = minStr
+		}
+		low, errLow := strconv.Atoi(strings.TrimSpace(minStr))
+		high, errHigh := strconv.Atoi(strings.TrimSpace(maxStr))
+		if errLow != nil || errHigh != nil || low < 100 || high > 599 || low > high {
+			return nil, fmt.Errorf("invalid status code range %q", part)
+		}
+		ranges = append(ranges, StatusRange{Min: low, Max: high})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no status codes given")
+	}
+	return ranges, nil
+}
+
+// StaticMode reports whether backends come from BACKEND_MEMBERS instead of Kubernetes discovery.
+func (cfg *AppConfig) StaticMode() bool {
+	return cfg.BackendMembers != "" || cfg.BackendMembersFile != ""
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -82,6 +364,50 @@ func parseEnvInt(key string, defaultValue int) int {
 	return intValue
 }
 
+// parseEnvDuration reads a duration such as "5s" or "1h30m", keeping defaultValue when unset or invalid.
+// Plain numbers are seconds, as in the configuration file.
+func parseEnvDuration(key string, defaultValue Duration) Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return Seconds(seconds)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Error parsing %s as duration: %v. Using default value: %s", key, err, defaultValue.Duration)
+		return defaultValue
+	}
+	return Duration{duration}
+}
+
+// parseLegacyEnvDuration reads a duration variable that earlier versions took as a plain number of units.
+// Plain numbers keep their old unit but are deprecated in favour of durations such as "15m".
+func parseLegacyEnvDuration(key string, defaultValue Duration, unit time.Duration) Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	if intValue, err := strconv.Atoi(value); err == nil {
+		duration := time.Duration(intValue) * unit
+		log.Printf("Deprecated plain number in %s read as %s. Use a duration such as %q instead", key, duration, duration.String())
+		return Duration{duration}
+	}
+	return parseEnvDuration(key, defaultValue)
+}
+
+// parseEnvList reads a comma or whitespace separated list
+func parseEnvList(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
 func parseEnvBool(key string, defaultValue bool) bool {
 	value, exists := os.LookupEnv(key)
 	if !exists {
@@ -109,6 +435,105 @@ func validateNonEmpty(field, value string) error {
 	return nil
 }
 
+func validateKubeconfigSource(source string) error {
+	switch source {
+	case KubeconfigSourceRancher, KubeconfigSourceInCluster, KubeconfigSourceKubeconfig:
+		return nil
+	}
+	return fmt.Errorf("invalid kubeconfigSource %q; must be one of %s, %s or %s", source, KubeconfigSourceRancher, KubeconfigSourceInCluster, KubeconfigSourceKubeconfig)
+}
+
+func validateAlgorithm(field, algorithm string) error {
+	switch algorithm {
+	case AlgorithmRoundRobin, AlgorithmLeastConnections, AlgorithmWeightedRoundRobin, AlgorithmWeightedLeastConnections,
+		AlgorithmConsistentHash, AlgorithmConsistentHashIPPort:
+		return nil
+	}
+	return fmt.Errorf("invalid %s %q; must be one of %s, %s, %s, %s, %s or %s", field, algorithm,
+		AlgorithmRoundRobin, AlgorithmLeastConnections, AlgorithmWeightedRoundRobin, AlgorithmWeightedLeastConnections,
+		AlgorithmConsistentHash, AlgorithmConsistentHashIPPort)
+}
+
+// ProxyProtocolVersion returns the numeric PROXY protocol version of a setting, 0 if it is disabled
+func ProxyProtocolVersion(version string) int {
+	switch version {
+	case ProxyProtocolV1:
+		return 1
+	case ProxyProtocolV2:
+		return 2
+	}
+	return 0
+}
+
+func validateProxyProtocol(field, version string) error {
+	switch version {
+	case "", ProxyProtocolV1, ProxyProtocolV2:
+		return nil
+	}
+	return fmt.Errorf("invalid %s %q; must be %s, %s or empty", field, version, ProxyProtocolV1, ProxyProtocolV2)
+}
+
+func validateWeightSource(source string) error {
+	switch source {
+	case WeightSourceNone, WeightSourceAnnotation, WeightSourceLabel, WeightSourceCPU:
+		return nil
+	}
+	return fmt.Errorf("invalid weightSource %q; must be one of %s, %s, %s or %s", source, WeightSourceNone, WeightSourceAnnotation, WeightSourceLabel, WeightSourceCPU)
+}
+
+func validateHealthCheck(field string, hc HealthCheckConfig) error {
+	if hc.Scheme != "http" && hc.Scheme != "https" {
+		return fmt.Errorf("invalid %s.scheme %q; must be http or https", field, hc.Scheme)
+	}
+	if hc.Port != 0 {
+		// 0 probes each backend on the port its traffic goes to
+		if err := validatePort(hc.Port); err != nil {
+			return fmt.Errorf("%s.port: %w", field, err)
+		}
+	}
+	if !strings.HasPrefix(hc.Path, "/") {
+		return fmt.Errorf("invalid %s.path %q; must start with /", field, hc.Path)
+	}
+	if _, err := ParseStatusRanges(hc.ExpectedStatus); err != nil {
+		return fmt.Errorf("%s.expectedStatus: %w", field, err)
+	}
+	if hc.Timeout.Duration <= 0 {
+		return fmt.Errorf("%s.timeout must be positive", field)
+	}
+	if hc.Rise < 1 {
+		return fmt.Errorf("%s.rise must be at least 1", field)
+	}
+	if hc.Fall < 1 {
+		return fmt.Errorf("%s.fall must be at least 1", field)
+	}
+	if hc.Jitter.Duration < 0 {
+		return fmt.Errorf("%s.jitter cannot be negative", field)
+	}
+	if err := validateProxyProtocol(field+".proxyProtocol", hc.ProxyProtocol); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateOutlierDetection(field string, od OutlierDetectionConfig) error {
+	if od.ConsecutiveErrors < 0 {
+		return fmt.Errorf("%s.consecutiveErrors cannot be negative", field)
+	}
+	if od.ConsecutiveErrors == 0 {
+		return nil
+	}
+	if od.BaseEjectionTime.Duration <= 0 {
+		return fmt.Errorf("%s.baseEjectionTime must be positive", field)
+	}
+	if od.MaxEjectionTime.Duration < od.BaseEjectionTime.Duration {
+		return fmt.Errorf("%s.maxEjectionTime must be at least baseEjectionTime", field)
+	}
+	if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+		return fmt.Errorf("%s.maxEjectionPercent must be between 0 and 100", field)
+	}
+	return nil
+}
+
 func ValidateConfiguration(cfg *AppConfig) error {
 	if err := validatePort(cfg.MetricsPort); err != nil {
 		return err
@@ -116,14 +541,21 @@ func ValidateConfiguration(cfg *AppConfig) error {
 	if err := validateNonEmpty("nodeSelector", cfg.NodeSelector); err != nil {
 		return err
 	}
-	if err := validateNonEmpty("rancherAPI", cfg.RancherAPI); err != nil {
-		return err
+	if !cfg.StaticMode() {
+		if err := validateKubeconfigSource(cfg.KubeconfigSource); err != nil {
+			return err
+		}
 	}
-	if err := validateNonEmpty("rancherKey", cfg.RancherKey); err != nil {
-		return err
-	}
-	if err := validateNonEmpty("rancherCluster", cfg.RancherCluster); err != nil {
-		return err
+	if !cfg.StaticMode() && cfg.KubeconfigSource == KubeconfigSourceRancher {
+		if err := validateNonEmpty("rancherAPI", cfg.RancherAPI); err != nil {
+			return err
+		}
+		if err := validateNonEmpty("rancherKey", cfg.RancherKey); err != nil {
+			return err
+		}
+		if err := validateNonEmpty("rancherCluster", cfg.RancherCluster); err != nil {
+			return err
+		}
 	}
 	if err := validateNonEmpty("frontendHttpPort", strconv.Itoa(cfg.FrontendHttpPort)); err != nil {
 		return err
@@ -137,5 +569,35 @@ func ValidateConfiguration(cfg *AppConfig) error {
 	if err := validateNonEmpty("backendHttpsPort", strconv.Itoa(cfg.BackendHttpsPort)); err != nil {
 		return err
 	}
+	if cfg.ShutdownTimeout.Duration < 0 {
+		return fmt.Errorf("shutdownTimeout cannot be negative")
+	}
+	if cfg.RescanInterval.Duration <= 0 {
+		return fmt.Errorf("rescanInterval must be positive")
+	}
+	if err := validateProxyProtocol("proxyProtocol", cfg.ProxyProtocol); err != nil {
+		return err
+	}
+	if _, err := ParseCIDRs(cfg.TrustedProxies); err != nil {
+		return fmt.Errorf("trustedProxies: %w", err)
+	}
+	if cfg.StickyTTL.Duration < 0 {
+		return fmt.Errorf("stickyTTL cannot be negative")
+	}
+	if cfg.StickyMaxEntries < 0 {
+		return fmt.Errorf("stickyMaxEntries cannot be negative")
+	}
+	if err := validateOutlierDetection("outlierDetection", cfg.OutlierDetection); err != nil {
+		return err
+	}
+	if err := validateWeightSource(cfg.WeightSource); err != nil {
+		return err
+	}
+	if err := validateListeners(cfg.Listeners, cfg.StaticMode()); err != nil {
+		return err
+	}
+	if err := validatePools(cfg); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,282 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a configuration file for the test and points CONFIG_FILE at it
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	return path
+}
+
+// setFlags parses args as the command line until the test ends
+func setFlags(t *testing.T, args ...string) {
+	t.Helper()
+	saved := flag.CommandLine
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	saved.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	flag.CommandLine = flags
+	t.Cleanup(func() { flag.CommandLine = saved })
+}
+
+// readTestConfiguration reads the configuration in static mode, so no Kubernetes settings are needed
+func readTestConfiguration(t *testing.T) (*AppConfig, error) {
+	t.Helper()
+	if _, exists := os.LookupEnv("BACKEND_MEMBERS"); !exists {
+		t.Setenv("BACKEND_MEMBERS", "10.0.0.1")
+	}
+	return ReadConfiguration()
+}
+
+func TestParseEnvDuration(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		legacy time.Duration
+		want   time.Duration
+	}{
+		{name: "duration", value: "1m30s", want: 90 * time.Second},
+		{name: "plain number", value: "45", want: 45 * time.Second},
+		{name: "zero", value: "0", want: 0},
+		{name: "invalid", value: "soon", want: 7 * time.Second},
+		{name: "legacy minutes", value: "20", legacy: time.Minute, want: 20 * time.Minute},
+		{name: "legacy seconds", value: "10", legacy: time.Second, want: 10 * time.Second},
+		{name: "legacy duration", value: "90s", legacy: time.Minute, want: 90 * time.Second},
+		{name: "legacy invalid", value: "soon", legacy: time.Minute, want: 7 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_DURATION", tt.value)
+			var got Duration
+			if tt.legacy != 0 {
+				got = parseLegacyEnvDuration("TEST_DURATION", Seconds(7), tt.legacy)
+			} else {
+				got = parseEnvDuration("TEST_DURATION", Seconds(7))
+			}
+			if got.Duration != tt.want {
+				t.Errorf("TEST_DURATION=%s read as %s, want %s", tt.value, got.Duration, tt.want)
+			}
+		})
+	}
+}
+
+// checkConfigError fails the test unless err contains wantErr, or is nil when wantErr is empty
+func checkConfigError(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	if wantErr == "" {
+		if err != nil {
+			t.Fatalf("ReadConfiguration() unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Fatalf("ReadConfiguration() error = %v, want error containing %q", err, wantErr)
+	}
+}
+
+func TestReadConfigurationPrecedence(t *testing.T) {
+	const file = `
+metricsPort: 9100
+dialTimeout: 10s
+nodeSelector: role=file
+listeners:
+  - name: inherits
+    frontendPort: 8080
+    backendPort: 80
+  - name: own
+    frontendPort: 8081
+    backendPort: 80
+    dialTimeout: 1s
+`
+	tests := []struct {
+		name             string
+		file             string
+		env              map[string]string
+		flags            []string
+		wantMetricsPort  int
+		wantDialTimeout  time.Duration
+		wantNodeSelector string
+		wantListenerDial map[string]time.Duration
+	}{
+		{
+			name:             "defaults",
+			wantMetricsPort:  9099,
+			wantDialTimeout:  5 * time.Second,
+			wantNodeSelector: "node-role.kubernetes.io/worker=true",
+			wantListenerDial: map[string]time.Duration{"http": 5 * time.Second, "https": 5 * time.Second},
+		},
+		{
+			name:             "file",
+			file:             file,
+			wantMetricsPort:  9100,
+			wantDialTimeout:  10 * time.Second,
+			wantNodeSelector: "role=file",
+			wantListenerDial: map[string]time.Duration{"inherits": 10 * time.Second, "own": time.Second},
+		},
+		{
+			name:             "environment before file",
+			file:             file,
+			env:              map[string]string{"METRICS_PORT": "9200", "DIAL_TIMEOUT": "20s"},
+			wantMetricsPort:  9200,
+			wantDialTimeout:  20 * time.Second,
+			wantNodeSelector: "role=file",
+			wantListenerDial: map[string]time.Duration{"inherits": 20 * time.Second, "own": time.Second},
+		},
+		{
+			name:             "flags before environment",
+			file:             file,
+			env:              map[string]string{"METRICS_PORT": "9200", "DIAL_TIMEOUT": "20s", "NODE_SELECTOR": "role=env"},
+			flags:            []string{"-metrics-port", "9300", "-dial-timeout", "30s"},
+			wantMetricsPort:  9300,
+			wantDialTimeout:  30 * time.Second,
+			wantNodeSelector: "role=env",
+			wantListenerDial: map[string]time.Duration{"inherits": 30 * time.Second, "own": time.Second},
+		},
+		{
+			name:             "LISTENERS replaces the file listeners",
+			file:             file,
+			env:              map[string]string{"LISTENERS": `[{"name": "env", "frontendPort": 9000, "backendPort": 9000}]`},
+			wantMetricsPort:  9100,
+			wantDialTimeout:  10 * time.Second,
+			wantNodeSelector: "role=file",
+			wantListenerDial: map[string]time.Duration{"env": 10 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.file != "" {
+				writeConfigFile(t, tt.file)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			setFlags(t, tt.flags...)
+
+			cfg, err := readTestConfiguration(t)
+			if err != nil {
+				t.Fatalf("ReadConfiguration() unexpected error: %v", err)
+			}
+			if cfg.MetricsPort != tt.wantMetricsPort {
+				t.Errorf("MetricsPort = %d, want %d", cfg.MetricsPort, tt.wantMetricsPort)
+			}
+			if cfg.DialTimeout.Duration != tt.wantDialTimeout {
+				t.Errorf("DialTimeout = %s, want %s", cfg.DialTimeout.Duration, tt.wantDialTimeout)
+			}
+			if cfg.NodeSelector != tt.wantNodeSelector {
+				t.Errorf("NodeSelector = %q, want %q", cfg.NodeSelector, tt.wantNodeSelector)
+			}
+			if len(cfg.Listeners) != len(tt.wantListenerDial) {
+				t.Fatalf("got %d listeners, want %d", len(cfg.Listeners), len(tt.wantListenerDial))
+			}
+			for _, listener := range cfg.Listeners {
+				want, exists := tt.wantListenerDial[listener.Name]
+				if !exists {
+					t.Errorf("unexpected listener %s", listener.Name)
+				} else if listener.DialTimeout.Duration != want {
+					t.Errorf("listener %s DialTimeout = %s, want %s", listener.Name, listener.DialTimeout.Duration, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadConfigurationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "unknown setting", file: "metricsPorts: 9100", wantErr: `unknown field "metricsPorts"`},
+		{name: "wrong type", file: "metricsPort: high", wantErr: "metricsPort: cannot use string as int"},
+		{name: "invalid duration", file: "dialTimeout: soon", wantErr: `invalid duration "soon"`},
+		{name: "invalid YAML", file: "listeners: [", wantErr: "config.yaml"},
+		{name: "unknown listener field", file: "listeners: [{name: web, frontendPort: 80, backendPort: 80, port: 80}]", wantErr: `listeners[0]: json: unknown field "port"`},
+		{name: "wrong listener type", file: "listeners: [{name: web, frontendPort: eighty, backendPort: 80}]", wantErr: "listeners[0]: frontendPort: cannot use string as int"},
+		{name: "unknown health check field", file: "healthCheck: {interval: 5s}", wantErr: `unknown field "interval"`},
+		{name: "unknown pool field", file: "pools: [{name: a, members: x}]", wantErr: `pools[0]: json: unknown field "members"`},
+		{name: "unknown LISTENERS field", env: map[string]string{"LISTENERS": `[{"name": "web", "frontend": 80}]`}, wantErr: `listeners[0]: json: unknown field "frontend"`},
+		{name: "invalid LISTENERS", env: map[string]string{"LISTENERS": `{"name": "web"}`}, wantErr: "LISTENERS: json: cannot unmarshal object"},
+		{name: "unknown POOLS field", env: map[string]string{"POOLS": `[{"name": "a", "port": 1}]`}, wantErr: `pools[0]: json: unknown field "port"`},
+		{name: "invalid setting", file: "weightSource: random", wantErr: `invalid configuration: invalid weightSource "random"`},
+		{name: "zero rescan interval", file: "rescanInterval: 0s", wantErr: "invalid configuration: rescanInterval must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.file != "" {
+				writeConfigFile(t, tt.file)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			setFlags(t)
+
+			_, err := readTestConfiguration(t)
+			checkConfigError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestReadConfigurationMissingFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	setFlags(t)
+
+	_, err := readTestConfiguration(t)
+	checkConfigError(t, err, "read configuration file")
+}
+
+func TestReadConfigurationKubeconfigSource(t *testing.T) {
+	rancher := map[string]string{
+		"RANCHER_API":     "https://rancher.example.com",
+		"RANCHER_KEY":     "token-abc:secret",
+		"RANCHER_CLUSTER": "local",
+	}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		flags   []string
+		want    string
+		wantErr string
+	}{
+		{name: "rancher", env: rancher, want: KubeconfigSourceRancher},
+		{name: "rancher without key", env: map[string]string{"RANCHER_KEY": ""}, wantErr: "rancherKey cannot be empty"},
+		{name: "rancher without API", env: map[string]string{"RANCHER_API": "", "RANCHER_KEY": "token-abc:secret"}, wantErr: "rancherAPI cannot be empty"},
+		{name: "rancher without cluster", env: map[string]string{"RANCHER_CLUSTER": "", "RANCHER_KEY": "token-abc:secret"}, wantErr: "rancherCluster cannot be empty"},
+		{name: "in-cluster needs no Rancher settings", env: map[string]string{"KUBECONFIG_SOURCE": "in-cluster", "RANCHER_API": ""}, want: KubeconfigSourceInCluster},
+		{name: "kubeconfig needs no Rancher settings", env: map[string]string{"KUBECONFIG_SOURCE": "kubeconfig", "RANCHER_CLUSTER": ""}, want: KubeconfigSourceKubeconfig},
+		{name: "flag overrides environment", env: map[string]string{"KUBECONFIG_SOURCE": "rancher"}, flags: []string{"-kubeconfig-source=in-cluster"}, want: KubeconfigSourceInCluster},
+		{name: "unknown source", env: map[string]string{"KUBECONFIG_SOURCE": "file"}, wantErr: `invalid kubeconfigSource "file"`},
+		{name: "ignored in static mode", env: map[string]string{"KUBECONFIG_SOURCE": "file", "BACKEND_MEMBERS": "10.0.0.1"}, want: "file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BACKEND_MEMBERS", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			setFlags(t, tt.flags...)
+
+			cfg, err := ReadConfiguration()
+			checkConfigError(t, err, tt.wantErr)
+			if err == nil && cfg.KubeconfigSource != tt.want {
+				t.Errorf("kubeconfigSource = %q, want %q", cfg.KubeconfigSource, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that is written to JSON as a string such as "5s".
+// When reading, strings are parsed with time.ParseDuration and plain numbers are taken as seconds.
+type Duration struct {
+	time.Duration
+}
+
+// Seconds is a convenience constructor for a Duration of n seconds
+func Seconds(n int) Duration {
+	return Duration{time.Duration(n) * time.Second}
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		d.Duration = time.Duration(v * float64(time.Second))
+		return nil
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		d.Duration = parsed
+		return nil
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// configFilePath returns the configuration file named by the -config flag or CONFIG_FILE, if any
+func configFilePath() string {
+	if flagSet("config") {
+		return *configFile
+	}
+	return getEnvOrDefault("CONFIG_FILE", "")
+}
+
+// loadConfigFile reads a YAML or JSON configuration file into cfg. The listeners and pools are returned
+// undecoded so that their omitted fields can later be filled from the final defaults.
+func loadConfigFile(path string, cfg *AppConfig) ([]json.RawMessage, []json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read configuration file: %w", err)
+	}
+	// YAML is a superset of JSON, so both formats are converted the same way
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	file := struct {
+		*AppConfig
+		Listeners []json.RawMessage `json:"listeners"` // Shadows AppConfig.Listeners
+		Pools     []json.RawMessage `json:"pools"`     // Shadows AppConfig.Pools
+	}{AppConfig: cfg}
+	if err := decodeStrict(jsonData, &file); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file.Listeners, file.Pools, nil
+}
+
+// decodeStrict decodes JSON into v, rejecting unknown fields and naming the field of type errors
+func decodeStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fmt.Errorf("%s: cannot use %s as %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package config
+
+import "flag"
+
+// Command line flags for the most common settings; they take precedence over the environment and the configuration file.
+var (
+	configFile         = flag.String("config", "", "Path to a YAML or JSON configuration file (CONFIG_FILE)")
+	debugFlag          = flag.Bool("debug", false, "Enable debug logging (DEBUG)")
+	metricsPort        = flag.Int("metrics-port", 0, "Port of the metrics and admin server (METRICS_PORT)")
+	frontendHttpPort   = flag.Int("frontend-http-port", 0, "Frontend port of the default http listener (FRONTEND_HTTP_PORT)")
+	frontendHttpsPort  = flag.Int("frontend-https-port", 0, "Frontend port of the default https listener (FRONTEND_HTTPS_PORT)")
+	backendHttpPort    = flag.Int("backend-http-port", 0, "Backend port of the default http listener (BACKEND_HTTP_PORT)")
+	backendHttpsPort   = flag.Int("backend-https-port", 0, "Backend port of the default https listener (BACKEND_HTTPS_PORT)")
+	dialTimeout        = flag.Duration("dial-timeout", 0, "Default timeout for each backend dial attempt (DIAL_TIMEOUT)")
+	dialRetries        = flag.Int("dial-retries", 0, "Default number of other backends to try when a dial fails (DIAL_RETRIES)")
+	idleTimeout        = flag.Duration("idle-timeout", 0, "Default idle time before a proxied connection is closed (IDLE_TIMEOUT)")
+	shutdownTimeout    = flag.Duration("shutdown-timeout", 0, "Time connections get to finish on shutdown before they are closed (SHUTDOWN_TIMEOUT)")
+	nodeSelector       = flag.String("node-selector", "", "Label selector for the backend nodes (NODE_SELECTOR)")
+	kubeconfigSource   = flag.String("kubeconfig-source", "", "Kubernetes client configuration source: rancher, in-cluster or kubeconfig (KUBECONFIG_SOURCE)")
+	kubeconfig         = flag.String("kubeconfig", "", "Kubeconfig path(s) for the kubeconfig source (KUBECONFIG)")
+	kubeContext        = flag.String("kube-context", "", "Kubeconfig context for the kubeconfig source (KUBE_CONTEXT)")
+	backendMembers     = flag.String("backend-members", "", "Static list of backend members (BACKEND_MEMBERS)")
+	backendMembersFile = flag.String("backend-members-file", "", "File containing static backend members (BACKEND_MEMBERS_FILE)")
+)
+
+// applyFlags overrides the current settings with the flags given on the command line.
+func applyFlags(cfg *AppConfig) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "debug":
+			cfg.Debug = *debugFlag
+		case "metrics-port":
+			cfg.MetricsPort = *metricsPort
+		case "frontend-http-port":
+			cfg.FrontendHttpPort = *frontendHttpPort
+		case "frontend-https-port":
+			cfg.FrontendHttpsPort = *frontendHttpsPort
+		case "backend-http-port":
+			cfg.BackendHttpPort = *backendHttpPort
+		case "backend-https-port":
+			cfg.BackendHttpsPort = *backendHttpsPort
+		case "dial-timeout":
+			cfg.DialTimeout = Duration{*dialTimeout}
+		case "dial-retries":
+			cfg.DialRetries = *dialRetries
+		case "idle-timeout":
+			cfg.IdleTimeout = Duration{*idleTimeout}
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = Duration{*shutdownTimeout}
+		case "node-selector":
+			cfg.NodeSelector = *nodeSelector
+		case "kubeconfig-source":
+			cfg.KubeconfigSource = *kubeconfigSource
+		case "kubeconfig":
+			cfg.Kubeconfig = *kubeconfig
+		case "kube-context":
+			cfg.KubeContext = *kubeContext
+		case "backend-members":
+			cfg.BackendMembers = *backendMembers
+		case "backend-members-file":
+			cfg.BackendMembersFile = *backendMembersFile
+		}
+	})
+}
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
@@ -0,0 +1,322 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// ListenerConfig defines a frontend port and how its connections are balanced across the backends.
+type ListenerConfig struct {
+	Name                string             `json:"name"`
+	Mode                string             `json:"mode"`                // tcp (default) to splice connections, http to proxy requests, udp to relay datagrams
+	BindAddress         string             `json:"bindAddress"`         // Address to listen on, defaults to 0.0.0.0
+	FrontendPort        int                `json:"frontendPort"`        // Port to accept client connections on
+	BackendPort         int                `json:"backendPort"`         // Port to connect to on the backends
+	Algorithm           string             `json:"algorithm"`           // Load balancing algorithm
+	HealthCheck         HealthCheckConfig  `json:"healthCheck"`         // Active health check for this listener's backend pool
+	Pool                string             `json:"pool"`                // Named pool to use instead of a backend pool of its own, e.g. shared by a tcp and a udp listener
+	DialTimeout         Duration           `json:"dialTimeout"`         // Timeout for each backend dial attempt
+	DialRetries         int                `json:"dialRetries"`         // Other backends to try after a failed dial
+	IdleTimeout         Duration           `json:"idleTimeout"`         // Close connections without traffic for this long, 0 disables
+	FlowTimeout         Duration           `json:"flowTimeout"`         // Forget UDP flows without datagrams in either direction for this long
+	ProxyProtocol       string             `json:"proxyProtocol"`       // PROXY protocol header sent to the backends: v1, v2 or empty for none
+	ProxyProtocolTLVs   []ProxyProtocolTLV `json:"proxyProtocolTLVs"`   // Extra TLVs sent in v2 headers
+	AcceptProxyProtocol bool               `json:"acceptProxyProtocol"` // Read the client address from a PROXY protocol header sent by a trusted proxy
+	TrustedProxies      []string           `json:"trustedProxies"`      // Addresses and CIDRs of the proxies allowed to connect when acceptProxyProtocol is set
+	SNIRoutes           []SNIRoute         `json:"sniRoutes"`           // Send TLS connections to other pools by server name, unmatched connections use this listener's backends
+	TLS                 TLSConfig          `json:"tls"`                 // Terminate TLS with these certificates instead of passing it through
+	HTTPRoutes          []HTTPRoute        `json:"httpRoutes"`          // Send requests to other pools by host and path in http mode, unmatched requests use this listener's backends
+	Redirect            RedirectConfig     `json:"redirect"`            // Answer requests in http mode with a redirect to HTTPS instead of proxying them
+	WarmPool            WarmPoolConfig     `json:"warmPool"`            // Keep pre-dialed connections to the backends in tcp mode
+}
+
+// WarmPoolConfig keeps idle connections to every healthy backend of a tcp listener, so client
+// connections do not wait for the TCP handshake with the backend.
+type WarmPoolConfig struct {
+	Size    int      `json:"size"`    // Idle connections kept per backend, 0 disables the pool
+	MaxIdle Duration `json:"maxIdle"` // Replace pooled connections unused for this long, keep below the backends' idle timeout
+}
+
+// RedirectConfig makes an http listener redirect requests to HTTPS on the same host.
+type RedirectConfig struct {
+	HTTPS      bool                `json:"https"`      // Redirect requests to https://
+	StatusCode int                 `json:"statusCode"` // 301 or 308 (default)
+	Port       int                 `json:"port"`       // HTTPS port used in the location, defaults to 443
+	Exceptions []RedirectException `json:"exceptions"` // Requests that are proxied to the backends instead
+}
+
+// RedirectException matches requests that are not redirected, e.g. ACME HTTP-01 challenges.
+type RedirectException struct {
+	Hosts      []string `json:"hosts"`      // Hosts as in SNIRoute; empty matches any host
+	PathPrefix string   `json:"pathPrefix"` // Matches this path and the paths below it; empty matches any path
+}
+
+// Listener modes.
+const (
+	ModeTCP  = "tcp"
+	ModeHTTP = "http"
+	ModeUDP  = "udp"
+)
+
+// MetricsSocketName names the socket of the metrics server among the sockets passed to an upgraded process.
+// Listeners cannot use it, so their sockets are never mistaken for it.
+const MetricsSocketName = "metrics"
+
+// ProxyProtocolTLV is a static type-length-value extension added to PROXY protocol v2 headers.
+type ProxyProtocolTLV struct {
+	Type  int    `json:"type"`  // TLV type, 0xE0 to 0xEF are reserved for custom use
+	Value string `json:"value"` // Raw value
+}
+
+// buildListeners decodes the listener definitions, filling omitted fields from defaults. Without any
+// definitions the classic http and https listeners are built from the frontend and backend port settings.
+// Port settings given in the environment or as flags also apply to defined listeners named http and https.
+func buildListeners(cfg *AppConfig, raw []json.RawMessage, defaults ListenerConfig) ([]ListenerConfig, error) {
+	if len(raw) == 0 {
+		httpListener := defaults
+		httpListener.Name = "http"
+		httpListener.FrontendPort = cfg.FrontendHttpPort
+		httpListener.BackendPort = cfg.BackendHttpPort
+		httpListener.Algorithm = getEnvOrDefault("HTTP_ALGORITHM", defaults.Algorithm)
+		httpListener.HealthCheck = loadHealthCheck("HTTP_HEALTH_CHECK_", defaults.HealthCheck)
+
+		httpsListener := defaults
+		httpsListener.Name = "https"
+		httpsListener.FrontendPort = cfg.FrontendHttpsPort
+		httpsListener.BackendPort = cfg.BackendHttpsPort
+		httpsListener.Algorithm = getEnvOrDefault("HTTPS_ALGORITHM", defaults.Algorithm)
+		httpsListener.HealthCheck = loadHealthCheck("HTTPS_HEALTH_CHECK_", defaults.HealthCheck)
+
+		return []ListenerConfig{httpListener, httpsListener}, nil
+	}
+
+	listeners := make([]ListenerConfig, 0, len(raw))
+	for i, item := range raw {
+		listener := defaults
+		if err := decodeStrict(item, &listener); err != nil {
+			return nil, fmt.Errorf("listeners[%d]: %w", i, err)
+		}
+		applyPortOverrides(cfg, &listener)
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// applyPortOverrides sets the ports of the listeners named http and https from the port environment
+// variables and flags, which take precedence over the listener definitions
+func applyPortOverrides(cfg *AppConfig, listener *ListenerConfig) {
+	switch listener.Name {
+	case "http":
+		if portOverridden("FRONTEND_HTTP_PORT", "frontend-http-port") {
+			listener.FrontendPort = cfg.FrontendHttpPort
+		}
+		if portOverridden("BACKEND_HTTP_PORT", "backend-http-port") {
+			listener.BackendPort = cfg.BackendHttpPort
+		}
+	case "https":
+		if portOverridden("FRONTEND_HTTPS_PORT", "frontend-https-port") {
+			listener.FrontendPort = cfg.FrontendHttpsPort
+		}
+		if portOverridden("BACKEND_HTTPS_PORT", "backend-https-port") {
+			listener.BackendPort = cfg.BackendHttpsPort
+		}
+	}
+}
+
+// portOverridden reports whether a port was set by its environment variable or flag
+func portOverridden(key, flagName string) bool {
+	_, exists := os.LookupEnv(key)
+	return exists || flagSet(flagName)
+}
+
+// HTTPMode reports whether the listener proxies HTTP requests rather than TCP connections
+func (l ListenerConfig) HTTPMode() bool {
+	return l.Mode == ModeHTTP
+}
+
+// UDPMode reports whether the listener relays UDP datagrams
+func (l ListenerConfig) UDPMode() bool {
+	return l.Mode == ModeUDP
+}
+
+// Network returns the network the listener binds to, udp or tcp
+func (l ListenerConfig) Network() string {
+	if l.UDPMode() {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// ListenAddress returns the host:port the listener binds to
+func (l ListenerConfig) ListenAddress() string {
+	return net.JoinHostPort(l.BindAddress, fmt.Sprint(l.FrontendPort))
+}
+
+func validateListeners(listeners []ListenerConfig, staticMode bool) error {
+	if len(listeners) == 0 {
+		return fmt.Errorf("listeners: at least one listener is required")
+	}
+	names := make(map[string]bool)
+	addresses := make(map[string]string)
+	for i, listener := range listeners {
+		field := fmt.Sprintf("listeners[%d]", i)
+		if err := validateNonEmpty(field+".name", listener.Name); err != nil {
+			return err
+		}
+		if names[listener.Name] {
+			return fmt.Errorf("%s.name: duplicate listener name %q", field, listener.Name)
+		}
+		if listener.Name == MetricsSocketName {
+			return fmt.Errorf("%s.name: %q is reserved for the metrics server", field, listener.Name)
+		}
+		names[listener.Name] = true
+		if listener.BindAddress != "" && net.ParseIP(listener.BindAddress) == nil {
+			return fmt.Errorf("%s.bindAddress: invalid IP address %q", field, listener.BindAddress)
+		}
+		if err := validatePort(listener.FrontendPort); err != nil {
+			return fmt.Errorf("%s.frontendPort: %w", field, err)
+		}
+		// TCP and UDP listeners may share an address, e.g. HTTPS and QUIC on port 443
+		socket := listener.Network() + "/" + listener.ListenAddress()
+		if other, exists := addresses[socket]; exists {
+			return fmt.Errorf("%s: %s is already used by listener %q", field, socket, other)
+		}
+		addresses[socket] = listener.Name
+		if err := validatePort(listener.BackendPort); err != nil {
+			return fmt.Errorf("%s.backendPort: %w", field, err)
+		}
+		if err := validateAlgorithm(field+".algorithm", listener.Algorithm); err != nil {
+			return err
+		}
+		if err := validateHealthCheck(field+".healthCheck", listener.HealthCheck); err != nil {
+			return err
+		}
+		if listener.DialTimeout.Duration <= 0 {
+			return fmt.Errorf("%s.dialTimeout must be positive", field)
+		}
+		if listener.DialRetries < 0 {
+			return fmt.Errorf("%s.dialRetries cannot be negative", field)
+		}
+		if listener.IdleTimeout.Duration < 0 {
+			return fmt.Errorf("%s.idleTimeout cannot be negative", field)
+		}
+		if listener.FlowTimeout.Duration <= 0 {
+			return fmt.Errorf("%s.flowTimeout must be positive", field)
+		}
+		switch listener.Mode {
+		case "", ModeTCP:
+			if len(listener.HTTPRoutes) > 0 {
+				return fmt.Errorf("%s.httpRoutes require mode %s", field, ModeHTTP)
+			}
+			if listener.Redirect.HTTPS {
+				return fmt.Errorf("%s.redirect requires mode %s", field, ModeHTTP)
+			}
+		case ModeHTTP:
+			if listener.ProxyProtocol != "" {
+				return fmt.Errorf("%s.proxyProtocol is not supported in mode %s; the client address is sent in X-Forwarded-For", field, ModeHTTP)
+			}
+			if len(listener.SNIRoutes) > 0 {
+				return fmt.Errorf("%s.sniRoutes are not supported in mode %s; use httpRoutes", field, ModeHTTP)
+			}
+		case ModeUDP:
+			if listener.ProxyProtocol != "" || listener.AcceptProxyProtocol {
+				return fmt.Errorf("%s: the PROXY protocol is not supported in mode %s", field, ModeUDP)
+			}
+			if len(listener.SNIRoutes) > 0 || len(listener.HTTPRoutes) > 0 {
+				return fmt.Errorf("%s: routes are not supported in mode %s", field, ModeUDP)
+			}
+			if listener.TLS.Enabled() {
+				return fmt.Errorf("%s.tls is not supported in mode %s", field, ModeUDP)
+			}
+			if listener.Redirect.HTTPS {
+				return fmt.Errorf("%s.redirect requires mode %s", field, ModeHTTP)
+			}
+		default:
+			return fmt.Errorf("invalid %s.mode %q; must be %s, %s or %s", field, listener.Mode, ModeTCP, ModeHTTP, ModeUDP)
+		}
+		if err := validateProxyProtocol(field+".proxyProtocol", listener.ProxyProtocol); err != nil {
+			return err
+		}
+		if len(listener.ProxyProtocolTLVs) > 0 && listener.ProxyProtocol != ProxyProtocolV2 {
+			return fmt.Errorf("%s.proxyProtocolTLVs require proxyProtocol %s", field, ProxyProtocolV2)
+		}
+		if _, err := ParseCIDRs(listener.TrustedProxies); err != nil {
+			return fmt.Errorf("%s.trustedProxies: %w", field, err)
+		}
+		if listener.AcceptProxyProtocol && len(listener.TrustedProxies) == 0 {
+			return fmt.Errorf("%s.acceptProxyProtocol requires trustedProxies", field)
+		}
+		if err := validateTLS(field+".tls", listener.TLS, staticMode); err != nil {
+			return err
+		}
+		if err := validateRedirect(field+".redirect", listener.Redirect); err != nil {
+			return err
+		}
+		if listener.Redirect.HTTPS && listener.TLS.Enabled() {
+			return fmt.Errorf("%s.redirect cannot be combined with tls", field)
+		}
+		if err := validateWarmPool(field+".warmPool", listener); err != nil {
+			return err
+		}
+		for j, tlv := range listener.ProxyProtocolTLVs {
+			if tlv.Type < 1 || tlv.Type > 255 {
+				return fmt.Errorf("%s.proxyProtocolTLVs[%d].type must be between 1 and 255", field, j)
+			}
+			if len(tlv.Value) > 0xFFFF {
+				return fmt.Errorf("%s.proxyProtocolTLVs[%d].value cannot be longer than 65535 bytes", field, j)
+			}
+		}
+	}
+	return nil
+}
+
+func validateWarmPool(field string, listener ListenerConfig) error {
+	if listener.WarmPool.Size < 0 {
+		return fmt.Errorf("%s.size cannot be negative", field)
+	}
+	if listener.WarmPool.Size == 0 {
+		return nil
+	}
+	if listener.HTTPMode() || listener.UDPMode() {
+		return fmt.Errorf("%s requires mode %s", field, ModeTCP)
+	}
+	if listener.WarmPool.MaxIdle.Duration <= 0 {
+		return fmt.Errorf("%s.maxIdle must be positive", field)
+	}
+	return nil
+}
+
+func validateRedirect(field string, redirect RedirectConfig) error {
+	if !redirect.HTTPS {
+		if redirect.StatusCode != 0 || redirect.Port != 0 || len(redirect.Exceptions) > 0 {
+			return fmt.Errorf("%s: https must be enabled", field)
+		}
+		return nil
+	}
+	switch redirect.StatusCode {
+	case 0, 301, 308:
+	default:
+		return fmt.Errorf("invalid %s.statusCode %d; must be 301 or 308", field, redirect.StatusCode)
+	}
+	if redirect.Port != 0 {
+		if err := validatePort(redirect.Port); err != nil {
+			return fmt.Errorf("%s.port: %w", field, err)
+		}
+	}
+	for i, exception := range redirect.Exceptions {
+		exceptionField := fmt.Sprintf("%s.exceptions[%d]", field, i)
+		if len(exception.Hosts) == 0 && exception.PathPrefix == "" {
+			return fmt.Errorf("%s: hosts or pathPrefix is required", exceptionField)
+		}
+		if err := validateRouteHosts(exceptionField+".hosts", exception.Hosts); err != nil {
+			return err
+		}
+		if exception.PathPrefix != "" && !strings.HasPrefix(exception.PathPrefix, "/") {
+			return fmt.Errorf("invalid %s.pathPrefix %q; must start with /", exceptionField, exception.PathPrefix)
+		}
+	}
+	return nil
+}
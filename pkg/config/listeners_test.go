@@ -0,0 +1,116 @@
+package config
+
+import "testing"
+
+func TestListenerPortOverrides(t *testing.T) {
+	const file = `
+frontendHttpPort: 8000
+listeners:
+  - name: http
+    frontendPort: 8080
+    backendPort: 8081
+  - name: https
+    frontendPort: 8443
+    backendPort: 8444
+  - name: other
+    frontendPort: 9000
+    backendPort: 9001
+`
+	tests := []struct {
+		name  string
+		env   map[string]string
+		flags []string
+		want  map[string][2]int
+	}{
+		{
+			name: "file",
+			want: map[string][2]int{"http": {8080, 8081}, "https": {8443, 8444}, "other": {9000, 9001}},
+		},
+		{
+			name: "environment",
+			env:  map[string]string{"FRONTEND_HTTP_PORT": "80", "BACKEND_HTTPS_PORT": "443"},
+			want: map[string][2]int{"http": {80, 8081}, "https": {8443, 443}, "other": {9000, 9001}},
+		},
+		{
+			name:  "flags",
+			flags: []string{"-frontend-https-port", "4443", "-backend-http-port", "8082"},
+			want:  map[string][2]int{"http": {8080, 8082}, "https": {4443, 8444}, "other": {9000, 9001}},
+		},
+		{
+			name:  "flags before environment",
+			env:   map[string]string{"FRONTEND_HTTP_PORT": "80"},
+			flags: []string{"-frontend-http-port", "81"},
+			want:  map[string][2]int{"http": {81, 8081}, "https": {8443, 8444}, "other": {9000, 9001}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfigFile(t, file)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			setFlags(t, tt.flags...)
+
+			cfg, err := readTestConfiguration(t)
+			if err != nil {
+				t.Fatalf("ReadConfiguration() unexpected error: %v", err)
+			}
+			for _, listener := range cfg.Listeners {
+				got := [2]int{listener.FrontendPort, listener.BackendPort}
+				if got != tt.want[listener.Name] {
+					t.Errorf("listener %s ports = %v, want %v", listener.Name, got, tt.want[listener.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	tests := []struct {
+		name      string
+		listeners string
+		wantErr   string
+	}{
+		{name: "minimal", listeners: `[{name: kube-api, frontendPort: 6443, backendPort: 6443}]`},
+		{name: "TCP and UDP on one port", listeners: `[{name: https, frontendPort: 443, backendPort: 443}, {name: quic, mode: udp, frontendPort: 443, backendPort: 443}]`},
+		{name: "health check on the backend port", listeners: `[{name: web, frontendPort: 80, backendPort: 8080, healthCheck: {port: 0}}]`},
+		{name: "missing name", listeners: `[{frontendPort: 80, backendPort: 80}]`, wantErr: "listeners[0].name cannot be empty"},
+		{name: "duplicate name", listeners: `[{name: web, frontendPort: 80, backendPort: 80}, {name: web, frontendPort: 81, backendPort: 80}]`, wantErr: `listeners[1].name: duplicate listener name "web"`},
+		{name: "name of the metrics socket", listeners: `[{name: metrics, frontendPort: 9100, backendPort: 9100}]`, wantErr: `listeners[0].name: "metrics" is reserved for the metrics server`},
+		{name: "invalid bind address", listeners: `[{name: web, bindAddress: localhost, frontendPort: 80, backendPort: 80}]`, wantErr: `listeners[0].bindAddress: invalid IP address "localhost"`},
+		{name: "missing frontend port", listeners: `[{name: web, backendPort: 80}]`, wantErr: "listeners[0].frontendPort: invalid port number 0"},
+		{name: "backend port out of range", listeners: `[{name: web, frontendPort: 80, backendPort: 65536}]`, wantErr: "listeners[0].backendPort: invalid port number 65536"},
+		{name: "shared TCP port", listeners: `[{name: a, frontendPort: 80, backendPort: 80}, {name: b, frontendPort: 80, backendPort: 81}]`, wantErr: `listeners[1]: tcp/0.0.0.0:80 is already used by listener "a"`},
+		{name: "invalid algorithm", listeners: `[{name: web, frontendPort: 80, backendPort: 80, algorithm: random}]`, wantErr: `invalid listeners[0].algorithm "random"`},
+		{name: "invalid mode", listeners: `[{name: web, mode: sctp, frontendPort: 80, backendPort: 80}]`, wantErr: `invalid listeners[0].mode "sctp"`},
+		{name: "invalid health check", listeners: `[{name: web, frontendPort: 80, backendPort: 80, healthCheck: {path: healthz}}]`, wantErr: `invalid listeners[0].healthCheck.path "healthz"`},
+		{name: "zero dial timeout", listeners: `[{name: web, frontendPort: 80, backendPort: 80, dialTimeout: 0s}]`, wantErr: "listeners[0].dialTimeout must be positive"},
+		{name: "negative dial retries", listeners: `[{name: web, frontendPort: 80, backendPort: 80, dialRetries: -1}]`, wantErr: "listeners[0].dialRetries cannot be negative"},
+		{name: "HTTP routes in TCP mode", listeners: `[{name: web, frontendPort: 80, backendPort: 80, httpRoutes: [{pathPrefix: /, pool: web}]}]`, wantErr: "listeners[0].httpRoutes require mode http"},
+		{name: "PROXY protocol in HTTP mode", listeners: `[{name: web, mode: http, frontendPort: 80, backendPort: 80, proxyProtocol: v1}]`, wantErr: "listeners[0].proxyProtocol is not supported in mode http"},
+		{name: "SNI routes in HTTP mode", listeners: `[{name: web, mode: http, frontendPort: 80, backendPort: 80, sniRoutes: [{hosts: ["*"], pool: web}]}]`, wantErr: "listeners[0].sniRoutes are not supported in mode http"},
+		{name: "TLS in UDP mode", listeners: `[{name: dns, mode: udp, frontendPort: 53, backendPort: 53, tls: {certificates: [{certFile: a.crt, keyFile: a.key}]}}]`, wantErr: "listeners[0].tls is not supported in mode udp"},
+		{name: "invalid PROXY protocol", listeners: `[{name: web, frontendPort: 80, backendPort: 80, proxyProtocol: v3}]`, wantErr: `invalid listeners[0].proxyProtocol "v3"`},
+		{name: "TLVs without v2", listeners: `[{name: web, frontendPort: 80, backendPort: 80, proxyProtocol: v1, proxyProtocolTLVs: [{type: 0xE0, value: x}]}]`, wantErr: "listeners[0].proxyProtocolTLVs require proxyProtocol v2"},
+		{name: "TLV type out of range", listeners: `[{name: web, frontendPort: 80, backendPort: 80, proxyProtocol: v2, proxyProtocolTLVs: [{type: 256, value: x}]}]`, wantErr: "listeners[0].proxyProtocolTLVs[0].type must be between 1 and 255"},
+		{name: "accept PROXY protocol without trusted proxies", listeners: `[{name: web, frontendPort: 80, backendPort: 80, acceptProxyProtocol: true}]`, wantErr: "listeners[0].acceptProxyProtocol requires trustedProxies"},
+		{name: "invalid trusted proxy", listeners: `[{name: web, frontendPort: 80, backendPort: 80, trustedProxies: [10.0.0.0/33]}]`, wantErr: `listeners[0].trustedProxies: invalid CIDR "10.0.0.0/33"`},
+		{name: "warm pool in HTTP mode", listeners: `[{name: web, mode: http, frontendPort: 80, backendPort: 80, warmPool: {size: 2}}]`, wantErr: "listeners[0].warmPool requires mode tcp"},
+		{name: "negative warm pool", listeners: `[{name: web, frontendPort: 80, backendPort: 80, warmPool: {size: -1}}]`, wantErr: "listeners[0].warmPool.size cannot be negative"},
+		{name: "redirect in TCP mode", listeners: `[{name: web, frontendPort: 80, backendPort: 80, redirect: {https: true}}]`, wantErr: "listeners[0].redirect requires mode http"},
+		{name: "redirect settings without https", listeners: `[{name: web, mode: http, frontendPort: 80, backendPort: 80, redirect: {statusCode: 301}}]`, wantErr: "listeners[0].redirect: https must be enabled"},
+		{name: "redirect status code", listeners: `[{name: web, mode: http, frontendPort: 80, backendPort: 80, redirect: {https: true, statusCode: 302}}]`, wantErr: "invalid listeners[0].redirect.statusCode 302"},
+		{name: "empty redirect exception", listeners: `[{name: web, mode: http, frontendPort: 80, backendPort: 80, redirect: {https: true, exceptions: [{}]}}]`, wantErr: "listeners[0].redirect.exceptions[0]: hosts or pathPrefix is required"},
+		{name: "redirect with TLS", listeners: `[{name: web, mode: http, frontendPort: 443, backendPort: 80, redirect: {https: true}, tls: {certificates: [{certFile: a.crt, keyFile: a.key}]}}]`, wantErr: "listeners[0].redirect cannot be combined with tls"},
+		{name: "empty list keeps the default listeners", listeners: `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfigFile(t, "listeners: "+tt.listeners)
+			setFlags(t)
+
+			_, err := readTestConfiguration(t)
+			checkConfigError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PoolConfig defines a named set of backends that listeners can route connections to.
+type PoolConfig struct {
+	Name               string            `json:"name"`
+	BackendMembers     string            `json:"backendMembers"`     // Static backends, e.g. the nodes of another cluster
+	BackendMembersFile string            `json:"backendMembersFile"` // File containing static backends
+	NodeSelector       string            `json:"nodeSelector"`       // Label selector choosing among the discovered nodes, used without static backends
+	BackendPort        int               `json:"backendPort"`        // Port to connect to on the backends, defaults to the listener's backend port
+	Algorithm          string            `json:"algorithm"`          // Load balancing algorithm, defaults to the listener's algorithm
+	HealthCheck        HealthCheckConfig `json:"healthCheck"`        // Active health check for the pool's backends
+}
+
+// SNIRoute sends TLS connections whose server name matches one of Hosts to a pool.
+type SNIRoute struct {
+	Hosts []string `json:"hosts"` // Server names; "*.example.com" matches every name below example.com and "*" matches any name
+	Pool  string   `json:"pool"`  // Name of the pool
+}
+
+// HTTPRoute sends HTTP requests whose host matches one of Hosts and whose path starts with PathPrefix to a pool.
+type HTTPRoute struct {
+	Hosts      []string `json:"hosts"`      // Hosts as in SNIRoute; empty matches any host
+	PathPrefix string   `json:"pathPrefix"` // Matches this path and the paths below it; empty matches any path
+	Pool       string   `json:"pool"`       // Name of the pool
+}
+
+// Static reports whether the pool lists its backends rather than discovering them
+func (p PoolConfig) Static() bool {
+	return p.BackendMembers != "" || p.BackendMembersFile != ""
+}
+
+// buildPools decodes the pool definitions, filling omitted fields from defaults
+func buildPools(raw []json.RawMessage, defaults PoolConfig) ([]PoolConfig, error) {
+	pools := make([]PoolConfig, 0, len(raw))
+	for i, item := range raw {
+		pool := defaults
+		if err := decodeStrict(item, &pool); err != nil {
+			return nil, fmt.Errorf("pools[%d]: %w", i, err)
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+func validatePools(cfg *AppConfig) error {
+	names := make(map[string]bool)
+	for _, listener := range cfg.Listeners {
+		names[listener.Name] = true
+	}
+	for i, pool := range cfg.Pools {
+		field := fmt.Sprintf("pools[%d]", i)
+		if err := validateNonEmpty(field+".name", pool.Name); err != nil {
+			return err
+		}
+		if names[pool.Name] {
+			return fmt.Errorf("%s.name: %q is already used by another pool or listener", field, pool.Name)
+		}
+		names[pool.Name] = true
+		if cfg.StaticMode() && !pool.Static() {
+			return fmt.Errorf("%s: backendMembers or backendMembersFile is required with static backends", field)
+		}
+		if pool.Static() && pool.NodeSelector != "" {
+			return fmt.Errorf("%s.nodeSelector cannot be combined with static backends", field)
+		}
+		if pool.BackendPort != 0 {
+			if err := validatePort(pool.BackendPort); err != nil {
+				return fmt.Errorf("%s.backendPort: %w", field, err)
+			}
+		}
+		if pool.Algorithm != "" {
+			if err := validateAlgorithm(field+".algorithm", pool.Algorithm); err != nil {
+				return err
+			}
+		}
+		if err := validateHealthCheck(field+".healthCheck", pool.HealthCheck); err != nil {
+			return err
+		}
+		if pool.HealthCheck.Port == 0 && pool.BackendPort == 0 {
+			return fmt.Errorf("%s.healthCheck.port: 0 probes the backend port, so backendPort is required", field)
+		}
+	}
+
+	pools := make(map[string]bool, len(cfg.Pools))
+	for _, pool := range cfg.Pools {
+		pools[pool.Name] = true
+	}
+	for i, listener := range cfg.Listeners {
+		if listener.Pool != "" && !pools[listener.Pool] {
+			return fmt.Errorf("listeners[%d].pool: no pool named %q", i, listener.Pool)
+		}
+		for j, route := range listener.SNIRoutes {
+			field := fmt.Sprintf("listeners[%d].sniRoutes[%d]", i, j)
+			if !pools[route.Pool] {
+				return fmt.Errorf("%s.pool: no pool named %q", field, route.Pool)
+			}
+			if len(route.Hosts) == 0 {
+				return fmt.Errorf("%s.hosts: at least one host is required", field)
+			}
+			if err := validateRouteHosts(field+".hosts", route.Hosts); err != nil {
+				return err
+			}
+		}
+		for j, route := range listener.HTTPRoutes {
+			field := fmt.Sprintf("listeners[%d].httpRoutes[%d]", i, j)
+			if !pools[route.Pool] {
+				return fmt.Errorf("%s.pool: no pool named %q", field, route.Pool)
+			}
+			if len(route.Hosts) == 0 && route.PathPrefix == "" {
+				return fmt.Errorf("%s: hosts or pathPrefix is required", field)
+			}
+			if err := validateRouteHosts(field+".hosts", route.Hosts); err != nil {
+				return err
+			}
+			if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+				return fmt.Errorf("invalid %s.pathPrefix %q; must start with /", field, route.PathPrefix)
+			}
+		}
+	}
+	return nil
+}
+
+func validateRouteHosts(field string, hosts []string) error {
+	for _, host := range hosts {
+		if host == "" || strings.Contains(strings.TrimPrefix(host, "*"), "*") || (host != "*" && strings.HasPrefix(host, "*") && !strings.HasPrefix(host, "*.")) {
+			return fmt.Errorf("invalid %s entry %q; wildcards are only allowed as * or *.domain", field, host)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidatePools(t *testing.T) {
+	tests := []struct {
+		name      string
+		pools     string
+		sniRoutes string
+		webRoutes string
+		quicPool  string
+		wantErr   string
+	}{
+		{
+			name:      "routes to a pool",
+			pools:     `[{name: staging, backendMembers: "10.1.0.1", healthCheck: {port: 10254}}]`,
+			sniRoutes: `[{hosts: ["*.staging.example.com", "staging.example.com"], pool: staging}]`,
+			webRoutes: `[{pathPrefix: /api, pool: staging}]`,
+		},
+		{name: "listener using a pool", pools: `[{name: edge, backendMembers: "10.1.0.1", backendPort: 443}]`, quicPool: "edge"},
+		{name: "health check on the pool port", pools: `[{name: staging, backendMembers: "10.1.0.1", backendPort: 8443, healthCheck: {port: 0}}]`},
+		{name: "missing name", pools: `[{backendMembers: "10.1.0.1"}]`, wantErr: "pools[0].name cannot be empty"},
+		{name: "name of a listener", pools: `[{name: https, backendMembers: "10.1.0.1"}]`, wantErr: `pools[0].name: "https" is already used by another pool or listener`},
+		{name: "duplicate name", pools: `[{name: a, backendMembers: "10.1.0.1"}, {name: a, backendMembers: "10.1.0.2"}]`, wantErr: `pools[1].name: "a" is already used`},
+		{name: "discovered pool in static mode", pools: `[{name: staging, nodeSelector: env=staging}]`, wantErr: "pools[0]: backendMembers or backendMembersFile is required with static backends"},
+		{name: "node selector with static backends", pools: `[{name: staging, backendMembers: "10.1.0.1", nodeSelector: env=staging}]`, wantErr: "pools[0].nodeSelector cannot be combined with static backends"},
+		{name: "invalid backend port", pools: `[{name: staging, backendMembers: "10.1.0.1", backendPort: 70000}]`, wantErr: "pools[0].backendPort: invalid port number 70000"},
+		{name: "invalid algorithm", pools: `[{name: staging, backendMembers: "10.1.0.1", algorithm: random}]`, wantErr: `invalid pools[0].algorithm "random"`},
+		{name: "health check port 0 without backend port", pools: `[{name: staging, backendMembers: "10.1.0.1", healthCheck: {port: 0}}]`, wantErr: "pools[0].healthCheck.port: 0 probes the backend port, so backendPort is required"},
+		{name: "SNI route to unknown pool", sniRoutes: `[{hosts: ["*"], pool: missing}]`, wantErr: `listeners[1].sniRoutes[0].pool: no pool named "missing"`},
+		{name: "SNI route to a listener", sniRoutes: `[{hosts: ["*"], pool: http}]`, wantErr: `listeners[1].sniRoutes[0].pool: no pool named "http"`},
+		{name: "SNI route without hosts", pools: `[{name: staging, backendMembers: "10.1.0.1"}]`, sniRoutes: `[{pool: staging}]`, wantErr: "listeners[1].sniRoutes[0].hosts: at least one host is required"},
+		{name: "SNI route with inner wildcard", pools: `[{name: staging, backendMembers: "10.1.0.1"}]`, sniRoutes: `[{hosts: ["api.*.example.com"], pool: staging}]`, wantErr: `invalid listeners[1].sniRoutes[0].hosts entry "api.*.example.com"`},
+		{name: "SNI route with partial wildcard", pools: `[{name: staging, backendMembers: "10.1.0.1"}]`, sniRoutes: `[{hosts: ["*example.com"], pool: staging}]`, wantErr: `invalid listeners[1].sniRoutes[0].hosts entry "*example.com"`},
+		{name: "HTTP route to unknown pool", webRoutes: `[{pathPrefix: /, pool: missing}]`, wantErr: `listeners[2].httpRoutes[0].pool: no pool named "missing"`},
+		{name: "listener using an unknown pool", quicPool: "missing", wantErr: `listeners[3].pool: no pool named "missing"`},
+		{name: "listener using another listener's pool", quicPool: "https", wantErr: `listeners[3].pool: no pool named "https"`},
+		{name: "HTTP route matching everything", pools: `[{name: staging, backendMembers: "10.1.0.1"}]`, webRoutes: `[{pool: staging}]`, wantErr: "listeners[2].httpRoutes[0]: hosts or pathPrefix is required"},
+		{name: "HTTP route with relative path", pools: `[{name: staging, backendMembers: "10.1.0.1"}]`, webRoutes: `[{pathPrefix: api, pool: staging}]`, wantErr: `invalid listeners[2].httpRoutes[0].pathPrefix "api"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfigFile(t, fmt.Sprintf(`
+pools: %s
+listeners:
+  - {name: http, frontendPort: 80, backendPort: 80}
+  - {name: https, frontendPort: 443, backendPort: 443, sniRoutes: %s}
+  - {name: web, mode: http, frontendPort: 8080, backendPort: 80, httpRoutes: %s}
+  - {name: quic, mode: udp, frontendPort: 443, backendPort: 443, pool: %q}
+`, orEmpty(tt.pools), orEmpty(tt.sniRoutes), orEmpty(tt.webRoutes), tt.quicPool))
+			setFlags(t)
+
+			_, err := readTestConfiguration(t)
+			checkConfigError(t, err, tt.wantErr)
+		})
+	}
+}
+
+// orEmpty returns list, or an empty YAML list if it is not set
+func orEmpty(list string) string {
+	if list == "" {
+		return "[]"
+	}
+	return list
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// reloadableFields are the AppConfig fields whose changes can be applied without a restart
+var reloadableFields = map[string]bool{
+	"FrontendHttpPort":    true,
+	"FrontendHttpsPort":   true,
+	"BackendHttpPort":     true,
+	"BackendHttpsPort":    true,
+	"DialTimeout":         true,
+	"DialRetries":         true,
+	"IdleTimeout":         true,
+	"FlowTimeout":         true,
+	"ShutdownTimeout":     true,
+	"ProxyProtocol":       true,
+	"AcceptProxyProtocol": true,
+	"TrustedProxies":      true,
+	"BackendMembers":      true,
+	"BackendMembersFile":  true,
+	"NodeSelector":        true,
+	"RescanInterval":      true,
+	"StickyTTL":           true,
+	"StickyMaxEntries":    true,
+	"OutlierDetection":    true,
+	"HealthCheck":         true,
+	"Listeners":           true,
+	"Pools":               true,
+	"ConfigFile":          true,
+}
+
+// RestartRequiredChanges returns the names of the settings that differ between two configurations
+// but only take effect after a restart.
+func RestartRequiredChanges(old, new *AppConfig) []string {
+	var changed []string
+	oldValue := reflect.ValueOf(*old)
+	newValue := reflect.ValueOf(*new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		if reloadableFields[field.Name] {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// WatchFiles polls the non-empty paths returned by paths and calls onChange when one of them is modified,
+// created or removed. It returns when ctx is cancelled.
+func WatchFiles(ctx context.Context, interval time.Duration, paths func() []string, onChange func()) {
+	type fileState struct {
+		modTime time.Time
+		size    int64
+		exists  bool
+	}
+	stat := func(path string) fileState {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fileState{}
+		}
+		return fileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+	}
+
+	states := make(map[string]fileState)
+	for _, path := range paths() {
+		if path != "" {
+			states[path] = stat(path)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed := false
+		current := make(map[string]fileState)
+		for _, path := range paths() {
+			if path == "" {
+				continue
+			}
+			state := stat(path)
+			current[path] = state
+			if previous, known := states[path]; known && previous != state {
+				log.Printf("Detected change to %s", path)
+				changed = true
+			}
+		}
+		states = current
+		if changed {
+			onChange()
+		}
+	}
+}
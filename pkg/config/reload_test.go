@@ -0,0 +1,63 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRestartRequiredChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *AppConfig)
+		want   []string
+	}{
+		{name: "unchanged", change: func(cfg *AppConfig) {}},
+		{
+			name: "listeners and pools",
+			change: func(cfg *AppConfig) {
+				cfg.Listeners = append(cfg.Listeners, ListenerConfig{Name: "kube-api", FrontendPort: 6443, BackendPort: 6443})
+				cfg.Pools = []PoolConfig{{Name: "staging", BackendMembers: "10.1.0.1"}}
+			},
+		},
+		{
+			name: "reloadable settings",
+			change: func(cfg *AppConfig) {
+				cfg.FrontendHttpPort = 8080
+				cfg.DialTimeout = Seconds(1)
+				cfg.TrustedProxies = []string{"10.0.0.0/8"}
+				cfg.BackendMembers = "10.0.0.2"
+				cfg.HealthCheck.Path = "/readyz"
+				cfg.ConfigFile = "/etc/gokubebalancer/other.yaml"
+				cfg.NodeSelector = "node-pool=edge"
+				cfg.RescanInterval = Seconds(10)
+				cfg.StickyTTL = Duration{time.Hour}
+				cfg.StickyMaxEntries = 10
+				cfg.OutlierDetection.ConsecutiveErrors = 10
+			},
+		},
+		{name: "metrics port", change: func(cfg *AppConfig) { cfg.MetricsPort = 9100 }, want: []string{"metricsPort"}},
+		{
+			name: "restart-only settings",
+			change: func(cfg *AppConfig) {
+				cfg.Debug = true
+				cfg.WeightSource = WeightSourceCPU
+				cfg.KubeconfigSource = KubeconfigSourceInCluster
+			},
+			want: []string{"debug", "weightSource", "kubeconfigSource"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := defaultConfig()
+			old.Listeners = []ListenerConfig{{Name: "http", FrontendPort: 80, BackendPort: 80}}
+			new := old
+			new.Listeners = append([]ListenerConfig{}, old.Listeners...)
+			tt.change(&new)
+
+			if got := RestartRequiredChanges(&old, &new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RestartRequiredChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TLSConfig makes a listener terminate TLS instead of passing it through to the backends.
+type TLSConfig struct {
+	Certificates []CertificateConfig `json:"certificates"` // Chosen by the client's server name, the first one is the default
+	MinVersion   string              `json:"minVersion"`   // Oldest TLS version accepted from clients: 1.2 (default) or 1.3
+	Backend      BackendTLSConfig    `json:"backend"`      // Re-encrypt the connections to the backends
+}
+
+// CertificateConfig is a certificate and its key, read from PEM files or a kubernetes.io/tls Secret.
+type CertificateConfig struct {
+	CertFile string `json:"certFile"` // PEM certificate chain
+	KeyFile  string `json:"keyFile"`  // PEM private key
+	Secret   string `json:"secret"`   // Secret as namespace/name, instead of the files
+}
+
+// BackendTLSConfig controls TLS between the balancer and the backends of a terminating listener.
+type BackendTLSConfig struct {
+	Enabled            bool   `json:"enabled"`            // Connect to the backends over TLS, otherwise plain TCP
+	ServerName         string `json:"serverName"`         // Server name to send and verify, defaults to the client's server name in tcp mode and to the backend address in http mode
+	CAFile             string `json:"caFile"`             // PEM bundle used to verify the backend certificates
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"` // Skip verification of the backend certificates
+}
+
+// Supported minimum TLS versions.
+const (
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+// Enabled reports whether the listener terminates TLS
+func (t TLSConfig) Enabled() bool {
+	return len(t.Certificates) > 0
+}
+
+// SecretName splits the Secret reference into its namespace and name
+func (c CertificateConfig) SecretName() (string, string) {
+	namespace, name, _ := strings.Cut(c.Secret, "/")
+	return namespace, name
+}
+
+func validateTLS(field string, t TLSConfig, staticMode bool) error {
+	if !t.Enabled() {
+		if t.MinVersion != "" || t.Backend != (BackendTLSConfig{}) {
+			return fmt.Errorf("%s.certificates: at least one certificate is required", field)
+		}
+		return nil
+	}
+	for i, certificate := range t.Certificates {
+		certificateField := fmt.Sprintf("%s.certificates[%d]", field, i)
+		if certificate.Secret != "" {
+			if certificate.CertFile != "" || certificate.KeyFile != "" {
+				return fmt.Errorf("%s: use either secret or certFile and keyFile", certificateField)
+			}
+			if staticMode {
+				return fmt.Errorf("%s.secret requires Kubernetes discovery", certificateField)
+			}
+			if namespace, name := certificate.SecretName(); namespace == "" || name == "" || strings.Contains(name, "/") {
+				return fmt.Errorf("invalid %s.secret %q; must be namespace/name", certificateField, certificate.Secret)
+			}
+			continue
+		}
+		if certificate.CertFile == "" || certificate.KeyFile == "" {
+			return fmt.Errorf("%s: certFile and keyFile or secret are required", certificateField)
+		}
+	}
+	switch t.MinVersion {
+	case "", TLSVersion12, TLSVersion13:
+	default:
+		return fmt.Errorf("invalid %s.minVersion %q; must be %s or %s", field, t.MinVersion, TLSVersion12, TLSVersion13)
+	}
+	return nil
+}
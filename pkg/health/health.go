@@ -3,6 +3,7 @@ package health
 import (
 	"encoding/json"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/supporttools/GoKubeBalancer/pkg/logging"
 )
@@ -36,8 +37,22 @@ func HealthzHandler() http.Handler {
 	})
 }
 
+// ready is reported by /readyz; it is set once the listeners accept connections and cleared on shutdown
+var ready atomic.Bool
+
+// SetReady changes the readiness reported by /readyz
+func SetReady(isReady bool) {
+	ready.Store(isReady)
+	logger.Infof("Readiness set to %t", isReady)
+}
+
 func ReadyzHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("not ready"))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	})
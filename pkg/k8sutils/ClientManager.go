@@ -0,0 +1,114 @@
+package k8sutils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/metrics"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// minRefreshInterval limits how often the kubeconfig is regenerated after authentication failures
+const minRefreshInterval = 30 * time.Second
+
+// ClientManager owns the Kubernetes clientset and regenerates it when the API server rejects its credentials
+type ClientManager struct {
+	clientset    atomic.Pointer[kubernetes.Clientset]
+	refreshMutex sync.Mutex
+	refreshing   atomic.Bool
+	lastRefresh  time.Time
+	getConfig    func(ctx context.Context) (*rest.Config, error) // Source of the client configuration, GetConfig outside tests
+}
+
+// NewClientManager builds the initial clientset from the configured kubeconfig source
+func NewClientManager(ctx context.Context) (*ClientManager, error) {
+	return newClientManager(ctx, GetConfig)
+}
+
+func newClientManager(ctx context.Context, getConfig func(ctx context.Context) (*rest.Config, error)) (*ClientManager, error) {
+	cm := &ClientManager{getConfig: getConfig}
+	clientset, err := cm.buildClientset(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cm.clientset.Store(clientset)
+	cm.lastRefresh = time.Now()
+	return cm, nil
+}
+
+// Clientset returns the current clientset
+func (cm *ClientManager) Clientset() *kubernetes.Clientset {
+	return cm.clientset.Load()
+}
+
+// Refresh regenerates the kubeconfig and atomically swaps in a new clientset
+func (cm *ClientManager) Refresh(ctx context.Context) error {
+	cm.refreshMutex.Lock()
+	defer cm.refreshMutex.Unlock()
+
+	log.Info("Refreshing Kubernetes credentials...")
+	cm.lastRefresh = time.Now()
+	clientset, err := cm.buildClientset(ctx)
+	if err != nil {
+		log.Errorf("Failed to refresh Kubernetes credentials: %v", err)
+		metrics.RecordKubeconfigRefresh("failure")
+		return err
+	}
+	cm.clientset.Store(clientset)
+	log.Info("Kubernetes credentials refreshed successfully.")
+	metrics.RecordKubeconfigRefresh("success")
+	return nil
+}
+
+// buildClientset creates a clientset whose transport reports authentication failures back to the manager
+func (cm *ClientManager) buildClientset(ctx context.Context) (*kubernetes.Clientset, error) {
+	restConfig, err := cm.getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	restConfig.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &authFailureRoundTripper{next: rt, onFailure: cm.triggerRefresh}
+	})
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// triggerRefresh starts a background refresh unless one is running or one happened recently
+func (cm *ClientManager) triggerRefresh(statusCode int) {
+	if !cm.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer cm.refreshing.Store(false)
+
+		cm.refreshMutex.Lock()
+		wait := minRefreshInterval - time.Since(cm.lastRefresh)
+		cm.refreshMutex.Unlock()
+		if wait > 0 {
+			log.Debugf("Kubernetes API returned %d, last refresh was too recent; skipping.", statusCode)
+			return
+		}
+
+		log.Warnf("Kubernetes API returned %d, regenerating kubeconfig.", statusCode)
+		if err := cm.Refresh(context.Background()); err != nil {
+			log.Errorf("Kubeconfig regeneration failed: %v", err)
+		}
+	}()
+}
+
+// authFailureRoundTripper calls onFailure for every 401 or 403 response
+type authFailureRoundTripper struct {
+	next      http.RoundTripper
+	onFailure func(statusCode int)
+}
+
+func (rt *authFailureRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.next.RoundTrip(req)
+	if err == nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		rt.onFailure(resp.StatusCode)
+	}
+	return resp, err
+}
@@ -0,0 +1,168 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// testAPIServer answers node requests carrying the valid bearer token and rejects all others with
+// rejectStatus. Requests for the node named "slow" wait until release is closed.
+type testAPIServer struct {
+	*httptest.Server
+	validToken   atomic.Value
+	rejectStatus int
+	started      chan struct{}
+	release      chan struct{}
+}
+
+func newTestAPIServer(t *testing.T, rejectStatus int) *testAPIServer {
+	t.Helper()
+	s := &testAPIServer{rejectStatus: rejectStatus, started: make(chan struct{}, 1), release: make(chan struct{})}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if name == "slow" {
+			s.started <- struct{}{}
+			<-s.release
+		}
+		if r.Header.Get("Authorization") != "Bearer "+s.validToken.Load().(string) {
+			w.WriteHeader(s.rejectStatus)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"kind": "Node", "apiVersion": "v1", "metadata": {"name": %q}}`, name)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// countingConfig returns a config source that hands out token-1, token-2, ... and counts its calls
+func countingConfig(server *testAPIServer, calls *atomic.Int32) func(context.Context) (*rest.Config, error) {
+	return func(context.Context) (*rest.Config, error) {
+		n := calls.Add(1)
+		return &rest.Config{Host: server.URL, BearerToken: fmt.Sprintf("token-%d", n)}, nil
+	}
+}
+
+func getNode(clientset *kubernetes.Clientset, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	return err
+}
+
+func TestClientManagerRefresh(t *testing.T) {
+	tests := []struct {
+		name          string
+		rejectStatus  int
+		recentRefresh bool // The last refresh is within minRefreshInterval
+		wantRefresh   bool
+	}{
+		{name: "unauthorized", rejectStatus: http.StatusUnauthorized, wantRefresh: true},
+		{name: "forbidden", rejectStatus: http.StatusForbidden, wantRefresh: true},
+		{name: "other error", rejectStatus: http.StatusInternalServerError},
+		{name: "throttled", rejectStatus: http.StatusUnauthorized, recentRefresh: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestAPIServer(t, tt.rejectStatus)
+			server.validToken.Store("token-2") // Only the regenerated credentials are accepted
+			var calls atomic.Int32
+			cm, err := newClientManager(context.Background(), countingConfig(server, &calls))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.recentRefresh {
+				cm.lastRefresh = time.Now().Add(-minRefreshInterval)
+			}
+			initial := cm.Clientset()
+
+			// Several rejected requests trigger at most one refresh
+			for i := 0; i < 3; i++ {
+				if err := getNode(initial, "a"); err == nil {
+					t.Fatal("request with stale credentials succeeded")
+				}
+			}
+
+			if !tt.wantRefresh {
+				time.Sleep(100 * time.Millisecond)
+				if n := calls.Load(); n != 1 {
+					t.Fatalf("config requested %d times, want no refresh", n)
+				}
+				if cm.Clientset() != initial {
+					t.Fatal("clientset replaced without a refresh")
+				}
+				return
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for cm.Clientset() == initial {
+				if time.Now().After(deadline) {
+					t.Fatal("clientset was not replaced")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err := getNode(cm.Clientset(), "a"); err != nil {
+				t.Fatalf("request after refresh failed: %v", err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			if n := calls.Load(); n != 2 {
+				t.Errorf("config requested %d times, want 2", n)
+			}
+		})
+	}
+}
+
+func TestClientManagerSwapInFlight(t *testing.T) {
+	server := newTestAPIServer(t, http.StatusUnauthorized)
+	server.validToken.Store("token-1")
+	var calls atomic.Int32
+	cm, err := newClientManager(context.Background(), countingConfig(server, &calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := cm.Clientset()
+
+	// A request with the first clientset is in flight while other goroutines read the clientset
+	// and a refresh swaps it
+	slowErr := make(chan error, 1)
+	go func() { slowErr <- getNode(cm.Clientset(), "slow") }()
+	<-server.started
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = cm.Clientset()
+		}()
+	}
+	server.validToken.Store("token-2")
+	if err := cm.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if cm.Clientset() == initial {
+		t.Fatal("clientset was not replaced")
+	}
+	if err := getNode(cm.Clientset(), "a"); err != nil {
+		t.Errorf("request with the new clientset failed: %v", err)
+	}
+
+	// The request in flight keeps the clientset it started with and completes against it
+	server.validToken.Store("token-1")
+	close(server.release)
+	if err := <-slowErr; err != nil {
+		t.Errorf("request in flight during the swap failed: %v", err)
+	}
+}
@@ -2,13 +2,62 @@ package k8sutils
 
 import (
 	"context"
+	"fmt"
+	"path/filepath"
 
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-// GetConfig retrieves the Kubernetes configuration from Rancher
+// GetConfig retrieves the Kubernetes configuration from the configured source
 func GetConfig(ctx context.Context) (*rest.Config, error) {
+	log.Infof("Retrieving Kubernetes configuration from source: %s", config.CFG.KubeconfigSource)
+	switch config.CFG.KubeconfigSource {
+	case config.KubeconfigSourceInCluster:
+		return getInClusterConfig()
+	case config.KubeconfigSourceKubeconfig:
+		return getKubeconfigFileConfig()
+	case config.KubeconfigSourceRancher:
+		return getRancherConfig(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported kubeconfig source: %s", config.CFG.KubeconfigSource)
+	}
+}
+
+// getInClusterConfig uses the service account mounted into the pod
+func getInClusterConfig() (*rest.Config, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		log.Errorf("Failed to create in-cluster Kubernetes client config: %v", err)
+		return nil, err
+	}
+
+	log.Info("Successfully configured in-cluster Kubernetes client.")
+	return restConfig, nil
+}
+
+// getKubeconfigFileConfig loads a kubeconfig file using the standard loading rules
+func getKubeconfigFileConfig() (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if config.CFG.Kubeconfig != "" {
+		loadingRules.Precedence = filepath.SplitList(config.CFG.Kubeconfig)
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: config.CFG.KubeContext}
+
+	log.Infof("Loading kubeconfig from %v (context: %q)...", loadingRules.GetLoadingPrecedence(), config.CFG.KubeContext)
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		log.Errorf("Failed to create Kubernetes client config from kubeconfig file: %v", err)
+		return nil, err
+	}
+
+	log.Infof("Successfully configured Kubernetes client for %s", restConfig.Host)
+	return restConfig, nil
+}
+
+// getRancherConfig retrieves the Kubernetes configuration from Rancher
+func getRancherConfig(ctx context.Context) (*rest.Config, error) {
 	log.Info("Retrieving cluster ID...")
 	clusterID, err := GetClusterID()
 	if err != nil {
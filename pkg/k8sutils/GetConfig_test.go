@@ -0,0 +1,127 @@
+package k8sutils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"k8s.io/client-go/rest"
+)
+
+// testKubeconfig returns a kubeconfig with one context per server, named after the server's host
+func testKubeconfig(current string, servers ...string) string {
+	var clusters, contexts strings.Builder
+	for _, server := range servers {
+		name := strings.TrimPrefix(server, "https://")
+		clusters.WriteString("- name: " + name + "\n  cluster:\n    server: " + server + "\n")
+		contexts.WriteString("- name: " + name + "\n  context:\n    cluster: " + name + "\n    user: test\n")
+	}
+	return "apiVersion: v1\nkind: Config\nclusters:\n" + clusters.String() +
+		"contexts:\n" + contexts.String() +
+		"current-context: " + current + "\nusers:\n- name: test\n  user:\n    token: abc\n"
+}
+
+// setTestConfig replaces the global configuration until the test ends
+func setTestConfig(t *testing.T, cfg config.AppConfig) {
+	t.Helper()
+	saved := config.CFG
+	config.CFG = cfg
+	t.Cleanup(func() { config.CFG = saved })
+}
+
+func TestGetConfig(t *testing.T) {
+	rancher := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic dG9rZW46c2VjcmV0" { // token:secret
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v3/clusters" && r.URL.Query().Get("name") == "local":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": []map[string]string{{"id": "c-abc12"}}})
+		case r.URL.Path == "/v3/clusters/c-abc12" && r.URL.Query().Get("action") == "generateKubeconfig":
+			json.NewEncoder(w).Encode(map[string]string{"config": testKubeconfig("rancher.example.com", "https://rancher.example.com")})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer rancher.Close()
+
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte(testKubeconfig("one.example.com", "https://one.example.com", "https://two.example.com")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      config.AppConfig
+		wantHost string
+		wantErr  error  // Checked with errors.Is
+		wantText string // Checked when wantErr is nil
+	}{
+		{
+			name:     "rancher",
+			cfg:      config.AppConfig{KubeconfigSource: config.KubeconfigSourceRancher, RancherAPI: rancher.URL, RancherKey: "token:secret", RancherCluster: "local"},
+			wantHost: "https://rancher.example.com",
+		},
+		{
+			name:     "rancher with unknown cluster",
+			cfg:      config.AppConfig{KubeconfigSource: config.KubeconfigSourceRancher, RancherAPI: rancher.URL, RancherKey: "token:secret", RancherCluster: "other"},
+			wantText: "get cluster ID, status code: 404",
+		},
+		{
+			name:     "kubeconfig current context",
+			cfg:      config.AppConfig{KubeconfigSource: config.KubeconfigSourceKubeconfig, Kubeconfig: kubeconfig, RancherAPI: rancher.URL},
+			wantHost: "https://one.example.com",
+		},
+		{
+			name:     "kubeconfig context",
+			cfg:      config.AppConfig{KubeconfigSource: config.KubeconfigSourceKubeconfig, Kubeconfig: kubeconfig, KubeContext: "two.example.com"},
+			wantHost: "https://two.example.com",
+		},
+		{
+			name:     "kubeconfig unknown context",
+			cfg:      config.AppConfig{KubeconfigSource: config.KubeconfigSourceKubeconfig, Kubeconfig: kubeconfig, KubeContext: "three.example.com"},
+			wantText: `context "three.example.com" does not exist`,
+		},
+		{
+			name:    "in-cluster outside a cluster",
+			cfg:     config.AppConfig{KubeconfigSource: config.KubeconfigSourceInCluster, Kubeconfig: kubeconfig, RancherAPI: rancher.URL},
+			wantErr: rest.ErrNotInCluster,
+		},
+		{
+			name:     "unknown source",
+			cfg:      config.AppConfig{KubeconfigSource: "file"},
+			wantText: "unsupported kubeconfig source: file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBERNETES_SERVICE_HOST", "")
+			t.Setenv("KUBERNETES_SERVICE_PORT", "")
+			setTestConfig(t, tt.cfg)
+
+			restConfig, err := GetConfig(context.Background())
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("GetConfig() error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantText) {
+					t.Fatalf("GetConfig() error = %v, want error containing %q", err, tt.wantText)
+				}
+			case err != nil:
+				t.Fatalf("GetConfig() unexpected error: %v", err)
+			case restConfig.Host != tt.wantHost:
+				t.Errorf("GetConfig() host = %s, want %s", restConfig.Host, tt.wantHost)
+			}
+		})
+	}
+}
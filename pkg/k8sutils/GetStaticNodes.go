@@ -0,0 +1,145 @@
+package k8sutils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+)
+
+// GetStaticNodes builds the backend list from BACKEND_MEMBERS and BACKEND_MEMBERS_FILE
+func GetStaticNodes() ([]NodeDetails, error) {
+	return LoadStaticNodes(config.CFG.BackendMembers, config.CFG.BackendMembersFile)
+}
+
+// LoadStaticNodes builds the backend list from a member list and an optional file of members
+func LoadStaticNodes(members, membersFile string) ([]NodeDetails, error) {
+	spec := members
+	if membersFile != "" {
+		log.Infof("Reading backend members from %s", membersFile)
+		data, err := os.ReadFile(membersFile)
+		if err != nil {
+			return nil, fmt.Errorf("read backend members file: %w", err)
+		}
+		spec += "\n" + string(data)
+	}
+
+	details, err := ParseBackendMembers(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(details) == 0 {
+		return nil, fmt.Errorf("no backend members configured")
+	}
+	log.Infof("Loaded %d static backend members", len(details))
+	return details, nil
+}
+
+// ParseBackendMembers parses a comma, whitespace or newline separated list of backend members.
+// Each entry has the form [name=]host[:port][@weight]; lines starting with # are comments.
+func ParseBackendMembers(spec string) ([]NodeDetails, error) {
+	var details []NodeDetails
+	names := make(map[string]bool)
+	addresses := make(map[string]bool)
+
+	for _, line := range strings.Split(spec, "\n") {
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		entries := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		for _, entry := range entries {
+			detail, err := parseBackendMember(entry)
+			if err != nil {
+				return nil, err
+			}
+			if names[detail.Name] {
+				return nil, fmt.Errorf("duplicate backend member name %q", detail.Name)
+			}
+			// Members on one host need their own ports
+			address := detail.IP
+			if detail.Port != 0 {
+				address = net.JoinHostPort(detail.IP, strconv.Itoa(detail.Port))
+			}
+			if addresses[address] {
+				return nil, fmt.Errorf("duplicate backend member address %q", address)
+			}
+			names[detail.Name] = true
+			addresses[address] = true
+			details = append(details, detail)
+		}
+	}
+	return details, nil
+}
+
+// parseBackendMember parses a single [name=]host[:port][@weight] entry
+func parseBackendMember(entry string) (NodeDetails, error) {
+	detail := NodeDetails{Weight: 1}
+	rest := entry
+
+	if name, value, found := strings.Cut(rest, "="); found {
+		if name == "" {
+			return NodeDetails{}, fmt.Errorf("backend member %q: empty name", entry)
+		}
+		detail.Name = name
+		rest = value
+	}
+
+	if idx := strings.LastIndex(rest, "@"); idx >= 0 {
+		weight, err := strconv.Atoi(rest[idx+1:])
+		if err != nil || weight <= 0 {
+			return NodeDetails{}, fmt.Errorf("backend member %q: invalid weight %q", entry, rest[idx+1:])
+		}
+		detail.Weight = weight
+		rest = rest[:idx]
+	}
+
+	host := rest
+	if strings.HasPrefix(rest, "[") || strings.Count(rest, ":") == 1 {
+		h, p, err := net.SplitHostPort(rest)
+		if err != nil {
+			return NodeDetails{}, fmt.Errorf("backend member %q: %w", entry, err)
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return NodeDetails{}, fmt.Errorf("backend member %q: invalid port %q", entry, p)
+		}
+		host = h
+		detail.Port = port
+	}
+	if host == "" {
+		return NodeDetails{}, fmt.Errorf("backend member %q: empty host", entry)
+	}
+	if net.ParseIP(host) == nil && !validHostname(host) {
+		return NodeDetails{}, fmt.Errorf("backend member %q: invalid host %q", entry, host)
+	}
+	detail.IP = host
+
+	if detail.Name == "" {
+		detail.Name = rest
+	}
+	return detail, nil
+}
+
+// validHostname reports whether host is a DNS name made of letters, digits and hyphens (RFC 1123)
+func validHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package k8sutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBackendMembers(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []NodeDetails
+		wantErr string
+	}{
+		{
+			name: "empty",
+			spec: "",
+		},
+		{
+			name: "plain IPs",
+			spec: "10.0.0.1,10.0.0.2",
+			want: []NodeDetails{
+				{Name: "10.0.0.1", IP: "10.0.0.1", Weight: 1},
+				{Name: "10.0.0.2", IP: "10.0.0.2", Weight: 1},
+			},
+		},
+		{
+			name: "names, ports and weights",
+			spec: "web1=10.0.0.1:8080@3 10.0.0.2@2",
+			want: []NodeDetails{
+				{Name: "web1", IP: "10.0.0.1", Port: 8080, Weight: 3},
+				{Name: "10.0.0.2", IP: "10.0.0.2", Weight: 2},
+			},
+		},
+		{
+			name: "separators and comments",
+			spec: "# backends\n10.0.0.1,\t10.0.0.2 # second\r\n\n  10.0.0.3",
+			want: []NodeDetails{
+				{Name: "10.0.0.1", IP: "10.0.0.1", Weight: 1},
+				{Name: "10.0.0.2", IP: "10.0.0.2", Weight: 1},
+				{Name: "10.0.0.3", IP: "10.0.0.3", Weight: 1},
+			},
+		},
+		{
+			name: "IPv6",
+			spec: "fd00::1 v6=[fd00::2]:8443",
+			want: []NodeDetails{
+				{Name: "fd00::1", IP: "fd00::1", Weight: 1},
+				{Name: "v6", IP: "fd00::2", Port: 8443, Weight: 1},
+			},
+		},
+		{
+			name: "hostnames",
+			spec: "node-1.example.com:80 localhost",
+			want: []NodeDetails{
+				{Name: "node-1.example.com:80", IP: "node-1.example.com", Port: 80, Weight: 1},
+				{Name: "localhost", IP: "localhost", Weight: 1},
+			},
+		},
+		{
+			name: "one host with several ports",
+			spec: "a=10.0.0.1:8080,b=10.0.0.1:8081",
+			want: []NodeDetails{
+				{Name: "a", IP: "10.0.0.1", Port: 8080, Weight: 1},
+				{Name: "b", IP: "10.0.0.1", Port: 8081, Weight: 1},
+			},
+		},
+		{name: "duplicate name", spec: "a=10.0.0.1,a=10.0.0.2", wantErr: "duplicate backend member name"},
+		{name: "duplicate host", spec: "a=10.0.0.1,b=10.0.0.1", wantErr: "duplicate backend member address"},
+		{name: "duplicate host and port", spec: "a=10.0.0.1:80,b=10.0.0.1:80", wantErr: "duplicate backend member address"},
+		{name: "empty name", spec: "=10.0.0.1", wantErr: "empty name"},
+		{name: "empty host", spec: "web=", wantErr: "empty host"},
+		{name: "empty host with port", spec: ":8080", wantErr: "empty host"},
+		{name: "zero weight", spec: "10.0.0.1@0", wantErr: "invalid weight"},
+		{name: "negative weight", spec: "10.0.0.1@-1", wantErr: "invalid weight"},
+		{name: "non-numeric weight", spec: "10.0.0.1@x", wantErr: "invalid weight"},
+		{name: "zero port", spec: "10.0.0.1:0", wantErr: "invalid port"},
+		{name: "port out of range", spec: "10.0.0.1:65536", wantErr: "invalid port"},
+		{name: "non-numeric port", spec: "10.0.0.1:http", wantErr: "invalid port"},
+		{name: "URL", spec: "http://10.0.0.1", wantErr: "backend member"},
+		{name: "unbracketed IPv6 with port", spec: "[fd00::1:80", wantErr: "backend member"},
+		{name: "invalid characters", spec: "back_end", wantErr: "invalid host"},
+		{name: "leading hyphen", spec: "-node", wantErr: "invalid host"},
+		{name: "empty label", spec: "node..example.com", wantErr: "invalid host"},
+		{name: "label too long", spec: strings.Repeat("a", 64) + ".example.com", wantErr: "invalid host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBackendMembers(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseBackendMembers(%q) error = %v, want error containing %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseBackendMembers(%q) unexpected error: %v", tt.spec, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBackendMembers(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
@@ -12,7 +12,7 @@ func IsNewNode(node *v1.Node) bool {
 	nodeAge := time.Since(node.CreationTimestamp.Time)
 	log.Debugf("Checking if node %s is new. Age: %s, Threshold: %s", node.Name, nodeAge, config.CFG.NewNodeThreshold)
 
-	if nodeAge < config.CFG.NewNodeThreshold {
+	if nodeAge < config.CFG.NewNodeThreshold.Duration {
 		log.Debugf("Node %s is considered new (age %s).", node.Name, nodeAge)
 		return true
 	}
@@ -0,0 +1,80 @@
+package k8sutils
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeDetails holds the necessary details for backend nodes
+type NodeDetails struct {
+	Name   string
+	IP     string
+	Port   int // Overrides the listener's backend port when non-zero
+	Weight int // Relative share of traffic, defaults to 1
+}
+
+// NewNodeInformer creates a shared informer for all nodes. The pools pick their nodes with the
+// configured node selector, so a reload can change it without a new informer.
+// Every list and watch uses the manager's current clientset so refreshed credentials are picked up.
+func NewNodeInformer(clients *ClientManager, resync time.Duration) cache.SharedIndexInformer {
+	log.Debug("Creating node informer")
+
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return clients.Clientset().CoreV1().Nodes().List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return clients.Clientset().CoreV1().Nodes().Watch(context.Background(), options)
+		},
+	}
+
+	return cache.NewSharedIndexInformer(listWatch, &v1.Node{}, resync, cache.Indexers{})
+}
+
+// GetNodeDetails returns the NodeDetails for a node using its first InternalIP address
+func GetNodeDetails(node *v1.Node) (NodeDetails, bool) {
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeInternalIP && address.Address != "" {
+			return NodeDetails{Name: node.Name, IP: address.Address, Weight: GetNodeWeight(node)}, true
+		}
+	}
+	return NodeDetails{}, false
+}
+
+// GetNodeWeight returns the backend weight of a node from the configured weight source, defaulting to 1
+func GetNodeWeight(node *v1.Node) int {
+	var value string
+	switch config.CFG.WeightSource {
+	case config.WeightSourceAnnotation:
+		value = node.Annotations[config.CFG.WeightKey]
+	case config.WeightSourceLabel:
+		value = node.Labels[config.CFG.WeightKey]
+	case config.WeightSourceCPU:
+		// One weight unit per allocatable core, rounded to the nearest core
+		weight := int((node.Status.Allocatable.Cpu().MilliValue() + 500) / 1000)
+		if weight < 1 {
+			weight = 1
+		}
+		return weight
+	default:
+		return 1
+	}
+
+	if value == "" {
+		return 1
+	}
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight <= 0 {
+		log.Warnf("Node %s has invalid weight %q in %s, using 1", node.Name, value, config.CFG.WeightKey)
+		return 1
+	}
+	return weight
+}
@@ -0,0 +1,37 @@
+package k8sutils
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NewSecretInformer creates an informer for a single Secret.
+// Every list and watch uses the manager's current clientset so refreshed credentials are picked up.
+func NewSecretInformer(clients *ClientManager, namespace, name string) cache.SharedIndexInformer {
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+	log.Debugf("Creating secret informer for %s/%s", namespace, name)
+
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return clients.Clientset().CoreV1().Secrets(namespace).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return clients.Clientset().CoreV1().Secrets(namespace).Watch(context.Background(), options)
+		},
+	}
+
+	return cache.NewSharedIndexInformer(listWatch, &v1.Secret{}, 0, cache.Indexers{})
+}
+
+// GetSecret fetches a Secret using the manager's current clientset
+func GetSecret(ctx context.Context, clients *ClientManager, namespace, name string) (*v1.Secret, error) {
+	return clients.Clientset().CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/supporttools/GoKubeBalancer/pkg/config"
 	"github.com/supporttools/GoKubeBalancer/pkg/health"
 	"github.com/supporttools/GoKubeBalancer/pkg/logging"
+	"github.com/supporttools/GoKubeBalancer/pkg/sockets"
 )
 
 var logger = logging.SetupLogging()
@@ -22,12 +24,36 @@ var (
 		Name: "load_balancer_healthy_backends",
 		Help: "Number of healthy backends.",
 	}, []string{"backend"})
+	kubeconfigRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "load_balancer_kubeconfig_refreshes_total",
+		Help: "Total number of Kubernetes credential refreshes, by result.",
+	}, []string{"result"})
 )
 
-func StartMetricsServer() {
+// extraHandlers holds admin handlers registered by other packages before the server starts
+var extraHandlers = make(map[string]http.Handler)
+
+// server is the running metrics server, set by StartMetricsServer
+var server *http.Server
+
+// RegisterHandler adds an admin handler to the metrics server; it must be called before StartMetricsServer
+func RegisterHandler(pattern string, handler http.Handler) {
+	extraHandlers[pattern] = handler
+}
+
+func init() {
+	prometheus.MustRegister(totalRequests, healthyBackendsGauge, kubeconfigRefreshes)
+}
+
+// RecordKubeconfigRefresh counts a Kubernetes credential refresh with the given result
+func RecordKubeconfigRefresh(result string) {
+	kubeconfigRefreshes.WithLabelValues(result).Inc()
+}
+
+// StartMetricsServer listens on the metrics port, or adopts an inherited socket for it, and serves in the background
+func StartMetricsServer() error {
 	if config.CFG.MetricsPort == 0 {
-		logger.Fatalf("Metrics server port not configured")
-		return
+		return fmt.Errorf("metrics server port not configured")
 	}
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
@@ -35,11 +61,29 @@ func StartMetricsServer() {
 	mux.Handle("/readyz", health.ReadyzHandler())
 	mux.Handle("/version", health.VersionHandler())
 	mux.HandleFunc("/node-states", health.NodeStatesHandler)
+	for pattern, handler := range extraHandlers {
+		mux.Handle(pattern, handler)
+	}
 
 	serverPortStr := strconv.Itoa(config.CFG.MetricsPort)
 	logger.Infof("Metrics server starting on port %s", serverPortStr)
 
-	if err := http.ListenAndServe(":"+serverPortStr, mux); err != nil {
-		logger.Fatalf("Metrics server failed to start: %v", err)
+	listener, err := sockets.Listen(config.MetricsSocketName, ":"+serverPortStr)
+	if err != nil {
+		return fmt.Errorf("metrics server failed to start: %w", err)
+	}
+	server = &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Metrics server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// StopMetricsServer closes the metrics port, for example once an upgraded process serves it
+func StopMetricsServer() {
+	if server != nil {
+		server.Close()
 	}
 }
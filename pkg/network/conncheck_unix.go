@@ -0,0 +1,42 @@
+//go:build unix
+
+package network
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// connCheck returns an error if a pooled connection was closed by the backend or failed. Data the
+// backend already sent, such as a greeting, is only peeked at and stays queued for the client.
+func connCheck(conn net.Conn) error {
+	syscallConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var checkErr error
+	buffer := make([]byte, 1)
+	err = rawConn.Read(func(fd uintptr) bool {
+		// Sockets of the net package are non-blocking, so this returns EAGAIN when nothing was sent
+		n, _, err := syscall.Recvfrom(int(fd), buffer, syscall.MSG_PEEK)
+		switch {
+		case n == 0 && err == nil:
+			checkErr = io.EOF
+		case errors.Is(err, syscall.EAGAIN), errors.Is(err, syscall.EWOULDBLOCK):
+			// Nothing to read, the connection is open
+		case err != nil:
+			checkErr = err
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return checkErr
+}
@@ -0,0 +1,523 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/supporttools/GoKubeBalancer/pkg/backend"
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/sockets"
+)
+
+// httpReadHeaderTimeout is how long a client gets to send the headers of a request
+const httpReadHeaderTimeout = 30 * time.Second
+
+// maxIdleConnsPerBackend limits the keep-alive connections kept open to each backend
+const maxIdleConnsPerBackend = 32
+
+// backendIdleConnTimeout closes keep-alive connections to the backends that were unused for this long
+const backendIdleConnTimeout = 90 * time.Second
+
+// httpErrorLog receives the errors net/http and httputil log themselves, such as failed TLS handshakes
+var httpErrorLog = stdlog.New(log.WriterLevel(logrus.InfoLevel), "[HTTPBalancer] ", 0)
+
+// settingsKey is the request context key of the listener settings a request is proxied with
+type settingsKey struct{}
+
+// HTTPBalancer proxies the HTTP requests of a listener to its backends, reusing backend connections
+// and passing upgraded connections such as WebSockets through
+type HTTPBalancer struct {
+	settings      listenerSettings
+	listener      net.Listener // Socket currently accepting connections, nil when stopped
+	stopped       bool         // Set by Stop; sockets served afterwards are closed right away
+	settingsMutex sync.RWMutex // Guards settings, listener and stopped
+	server        *http.Server
+	proxy         *httputil.ReverseProxy
+	activeConns   map[string]int64      // Requests in flight per backend IP
+	connections   map[net.Conn]struct{} // Client connections that are neither closed nor upgraded
+	upgrades      int                   // Upgraded connections still open
+	connsMutex    sync.Mutex
+	forceClose    context.CancelFunc // Cancels every request, ending upgraded connections
+}
+
+// NewHTTPBalancer creates an HTTPBalancer for a listener, its BackendManager, the routes to other
+// pools and its TLS configuration
+func NewHTTPBalancer(listener config.ListenerConfig, bm *backend.BackendManager, routes []Route, tlsSettings TLS) *HTTPBalancer {
+	ctx, cancel := context.WithCancel(context.Background())
+	hb := &HTTPBalancer{
+		settings:    newHTTPSettings(listener, bm, routes, tlsSettings),
+		activeConns: make(map[string]int64),
+		connections: make(map[net.Conn]struct{}),
+		forceClose:  cancel,
+	}
+	hb.proxy = &httputil.ReverseProxy{
+		Rewrite:      hb.rewrite,
+		Transport:    hb,
+		ErrorHandler: hb.proxyError,
+		ErrorLog:     httpErrorLog,
+	}
+	// The server's timeouts are read by every connection without locking, so they are fixed for the
+	// life of the listener and a changed idleTimeout only takes effect after a restart
+	hb.server = &http.Server{
+		Handler:           hb,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		IdleTimeout:       listener.IdleTimeout.Duration,
+		ConnState:         hb.trackClientConn,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		ErrorLog:          httpErrorLog,
+	}
+	return hb
+}
+
+func newHTTPSettings(listener config.ListenerConfig, bm *backend.BackendManager, routes []Route, tlsSettings TLS) listenerSettings {
+	settings := newListenerSettings(listener, bm, routes, tlsSettings)
+	settings.transport = newHTTPTransport(settings.dialTimeout, tlsSettings.Backend)
+	return settings
+}
+
+// newHTTPTransport returns the transport that keeps the connections to the backends of a listener.
+// The connections are pooled per backend address and reused for every host, so without a configured
+// server name backendTLS verifies the backend address rather than the client's server name.
+func newHTTPTransport(dialTimeout time.Duration, backendTLS *tls.Config) *http.Transport {
+	return &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: dialTimeout}).DialContext,
+		TLSClientConfig:     backendTLS,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		MaxIdleConnsPerHost: maxIdleConnsPerBackend,
+		IdleConnTimeout:     backendIdleConnTimeout,
+	}
+}
+
+// Update applies new listener settings, backend pool, routes and TLS configuration to requests
+// accepted from now on. The connections to the backends are kept unless the dial timeout or the
+// backend TLS configuration changed, in which case the idle ones are closed.
+func (hb *HTTPBalancer) Update(listener config.ListenerConfig, bm *backend.BackendManager, routes []Route, tlsSettings TLS) {
+	hb.settingsMutex.Lock()
+	previous := hb.settings
+	hb.settings = newListenerSettings(listener, bm, routes, tlsSettings)
+	var stale *http.Transport
+	if previous.dialTimeout == hb.settings.dialTimeout && sameBackendTLS(previous.tls.Backend, tlsSettings.Backend) {
+		hb.settings.transport = previous.transport
+	} else {
+		hb.settings.transport = newHTTPTransport(hb.settings.dialTimeout, tlsSettings.Backend)
+		stale = previous.transport
+	}
+	hb.settingsMutex.Unlock()
+
+	if stale != nil {
+		stale.CloseIdleConnections()
+	}
+	if listener.IdleTimeout.Duration != hb.server.IdleTimeout {
+		log.Warnf("[HTTPBalancer] The idleTimeout of listener %s changed, it will take effect after a restart.", listener.Name)
+	}
+}
+
+// sameBackendTLS reports whether two backend TLS configurations built by certs.BackendConfig verify
+// the backends the same way
+func sameBackendTLS(a, b *tls.Config) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.MinVersion == b.MinVersion &&
+		a.ServerName == b.ServerName &&
+		a.InsecureSkipVerify == b.InsecureSkipVerify &&
+		a.RootCAs.Equal(b.RootCAs)
+}
+
+// currentSettings returns a snapshot of the listener settings
+func (hb *HTTPBalancer) currentSettings() listenerSettings {
+	hb.settingsMutex.RLock()
+	defer hb.settingsMutex.RUnlock()
+	return hb.settings
+}
+
+// ActiveConnections returns the number of requests currently proxied to a backend
+func (hb *HTTPBalancer) ActiveConnections(backendIP string) int64 {
+	hb.connsMutex.Lock()
+	defer hb.connsMutex.Unlock()
+	return hb.activeConns[backendIP]
+}
+
+// trackRequest adjusts the requests in flight to a backend by delta
+func (hb *HTTPBalancer) trackRequest(backendIP string, delta int64) {
+	hb.connsMutex.Lock()
+	defer hb.connsMutex.Unlock()
+	hb.activeConns[backendIP] += delta
+	if hb.activeConns[backendIP] <= 0 {
+		delete(hb.activeConns, backendIP)
+	}
+}
+
+// trackClientConn follows the client connections of the server for draining
+func (hb *HTTPBalancer) trackClientConn(conn net.Conn, state http.ConnState) {
+	hb.connsMutex.Lock()
+	defer hb.connsMutex.Unlock()
+	switch state {
+	case http.StateNew:
+		hb.connections[conn] = struct{}{}
+	case http.StateHijacked, http.StateClosed:
+		delete(hb.connections, conn)
+	}
+}
+
+func (hb *HTTPBalancer) trackUpgrade(delta int) {
+	hb.connsMutex.Lock()
+	defer hb.connsMutex.Unlock()
+	hb.upgrades += delta
+}
+
+// OpenConnections returns the number of client connections, including upgraded ones
+func (hb *HTTPBalancer) OpenConnections() int {
+	hb.connsMutex.Lock()
+	defer hb.connsMutex.Unlock()
+	return len(hb.connections) + hb.upgrades
+}
+
+// Start listens on the listener's address, or adopts an inherited socket for it, and serves requests
+// in the background
+func (hb *HTTPBalancer) Start() error {
+	settings := hb.currentSettings()
+	listener, err := sockets.Listen(settings.name, settings.listenAddr)
+	if err != nil {
+		return fmt.Errorf("listen on %s for listener %s: %w", settings.listenAddr, settings.name, err)
+	}
+	go hb.Serve(listener)
+	return nil
+}
+
+// Serve serves requests on listener until it is closed, replacing any socket served before.
+// Connections accepted from the previous socket are not affected.
+func (hb *HTTPBalancer) Serve(listener net.Listener) {
+	hb.settingsMutex.Lock()
+	if hb.stopped {
+		// Stop ran before this goroutine got to register the socket
+		hb.settingsMutex.Unlock()
+		listener.Close()
+		return
+	}
+	previous := hb.listener
+	hb.listener = listener
+	name := hb.settings.name
+	hb.settingsMutex.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	hb.server.SetKeepAlivesEnabled(true)
+
+	log.Printf("[HTTPBalancer] HTTP Load Balancer %s started on %s", name, listener.Addr())
+	err := hb.server.Serve(newHTTPListener(listener, hb.currentSettings))
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, http.ErrServerClosed) {
+		log.Printf("[HTTPBalancer] Stopped accepting connections on %s", listener.Addr())
+		return
+	}
+	log.Printf("[HTTPBalancer] Failed to serve %s: %v", listener.Addr(), err)
+}
+
+// Stop closes the listening socket. Requests in progress are completed, after which their
+// connections are closed instead of being kept alive.
+func (hb *HTTPBalancer) Stop() {
+	hb.settingsMutex.Lock()
+	listener := hb.listener
+	hb.listener = nil
+	hb.stopped = true
+	hb.settingsMutex.Unlock()
+	if listener != nil {
+		listener.Close()
+	}
+	hb.server.SetKeepAlivesEnabled(false)
+}
+
+// Shutdown stops accepting connections and waits for requests in progress and upgraded connections to
+// finish. Connections still open when ctx is done are closed.
+func (hb *HTTPBalancer) Shutdown(ctx context.Context) DrainStats {
+	hb.Stop()
+	stats := DrainStats{Listener: hb.currentSettings().name, Connections: hb.OpenConnections()}
+	log.Printf("[HTTPBalancer] Draining %d connections of listener %s", stats.Connections, stats.Listener)
+
+	// Closes idle connections and waits for active ones; upgraded connections are waited for below
+	hb.server.Shutdown(ctx)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for hb.OpenConnections() > 0 {
+		select {
+		case <-ctx.Done():
+			stats.ForceClosed = hb.OpenConnections()
+			stats.Drained = max(stats.Connections-stats.ForceClosed, 0)
+			hb.server.Close()
+			hb.forceClose()
+			return stats
+		case <-ticker.C:
+		}
+	}
+	stats.Drained = stats.Connections
+	return stats
+}
+
+// ServeHTTP redirects a request to HTTPS, or chooses the pool for it and proxies it
+func (hb *HTTPBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	settings := hb.currentSettings()
+	if settings.redirect.HTTPS && !settings.redirectException(r.Host, r.URL.Path) {
+		redirectToHTTPS(w, r, settings.redirect)
+		return
+	}
+	if route := settings.httpRoute(r.Host, r.URL.Path); route != nil {
+		log.Debugf("[Connection] Routing %s%s from client %s to pool %s", r.Host, r.URL.Path, r.RemoteAddr, route.Pool)
+		settings.backendManager = route.BackendManager
+		settings.backendPort = route.BackendPort
+		settings.algorithm = route.Algorithm
+	}
+	hb.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), settingsKey{}, settings)))
+}
+
+// rewrite adds the X-Forwarded headers; the backend address is filled in by RoundTrip
+func (hb *HTTPBalancer) rewrite(pr *httputil.ProxyRequest) {
+	settings := pr.In.Context().Value(settingsKey{}).(listenerSettings)
+	pr.SetXForwarded()
+	pr.Out.URL.Scheme = "http"
+	if settings.tls.Backend != nil {
+		pr.Out.URL.Scheme = "https"
+	}
+	pr.Out.URL.Host = settings.name
+}
+
+// RoundTrip sends a proxied request to a backend selected by the listener's algorithm, trying up to
+// dialRetries other healthy backends when no connection can be made
+func (hb *HTTPBalancer) RoundTrip(req *http.Request) (*http.Response, error) {
+	settings := req.Context().Value(settingsKey{}).(listenerSettings)
+	if req.Body != nil && req.Body != http.NoBody {
+		// The transport closes the body when a dial fails, which would prevent the retries
+		req.Body = io.NopCloser(req.Body)
+	}
+
+	var tried []string
+	lastErr := errNoBackend
+	for attempt := 0; attempt <= settings.dialRetries; attempt++ {
+		backendIP := settings.backendManager.SelectBackend(req.RemoteAddr, settings.algorithm, hb, tried...)
+		if backendIP == "" {
+			break
+		}
+		req.URL.Host = settings.backendManager.BackendAddress(backendIP, settings.backendPort)
+
+		hb.trackRequest(backendIP, 1)
+		resp, err := settings.transport.RoundTrip(req)
+		if err != nil {
+			hb.trackRequest(backendIP, -1)
+			if !connectFailed(err) {
+				// The request may have reached the backend, so it is not sent again
+				return nil, err
+			}
+			log.Printf("[Connection] Failed to connect to backend %s for client %s (attempt %d): %v", req.URL.Host, req.RemoteAddr, attempt+1, err)
+			if !certificateRejected(err) {
+				settings.backendManager.ReportFailure(backendIP, err)
+			}
+			tried = append(tried, backendIP)
+			lastErr = err
+			continue
+		}
+		settings.backendManager.ReportSuccess(backendIP)
+
+		body := &trackedBody{ReadCloser: resp.Body, done: func() { hb.trackRequest(backendIP, -1) }}
+		resp.Body = body
+		if upgraded, ok := body.ReadCloser.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+			hb.trackUpgrade(1)
+			body.done = func() {
+				hb.trackRequest(backendIP, -1)
+				hb.trackUpgrade(-1)
+			}
+			resp.Body = &trackedUpgrade{trackedBody: body, Writer: upgraded}
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// proxyError answers requests that could not be proxied
+func (hb *HTTPBalancer) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		log.Debugf("[Connection] Client %s went away during %s %s%s", r.RemoteAddr, r.Method, r.Host, r.URL.Path)
+		return
+	}
+	log.Printf("[Connection] Failed to proxy %s %s%s for client %s: %v", r.Method, r.Host, r.URL.Path, r.RemoteAddr, err)
+	status := http.StatusBadGateway
+	if errors.Is(err, errNoBackend) {
+		status = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(status)
+}
+
+// connectFailed reports whether a request failed before anything was sent to the backend
+func connectFailed(err error) bool {
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	return (errors.As(err, &opErr) && opErr.Op == "dial") || errors.As(err, &recordErr) || certificateRejected(err)
+}
+
+// httpRoute returns the first route matching the host and path of a request, or nil if the
+// listener's own backends are used
+func (settings listenerSettings) httpRoute(host, path string) *Route {
+	for i := range settings.routes {
+		if matchRequest(settings.routes[i].Hosts, settings.routes[i].PathPrefix, host, path) {
+			return &settings.routes[i]
+		}
+	}
+	return nil
+}
+
+// redirectException reports whether a request is proxied although the listener redirects to HTTPS
+func (settings listenerSettings) redirectException(host, path string) bool {
+	for _, exception := range settings.redirect.Exceptions {
+		if matchRequest(exception.Hosts, exception.PathPrefix, host, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectToHTTPS answers a request with a redirect to the same host and path over HTTPS
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request, redirect config.RedirectConfig) {
+	host := requestHostname(r.Host)
+	if host == "" {
+		http.Error(w, "Host header required", http.StatusBadRequest)
+		return
+	}
+	if redirect.Port != 0 && redirect.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(redirect.Port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	statusCode := redirect.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusPermanentRedirect
+	}
+	log.Debugf("[Connection] Redirecting %s%s from client %s to HTTPS", r.Host, r.URL.Path, r.RemoteAddr)
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), statusCode)
+}
+
+// requestHostname returns the host of a Host header without its port
+func requestHostname(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// matchRequest reports whether a request's host matches one of hosts, or hosts is empty, and its
+// path is below pathPrefix, or pathPrefix is empty
+func matchRequest(hosts []string, pathPrefix, host, path string) bool {
+	if pathPrefix != "" && !matchPathPrefix(pathPrefix, path) {
+		return false
+	}
+	if len(hosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(requestHostname(host))
+	for _, pattern := range hosts {
+		if matchServerName(strings.ToLower(pattern), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPathPrefix reports whether path is prefix or below it; "/api" matches "/api" and "/api/v1"
+// but not "/apis"
+func matchPathPrefix(prefix, path string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// trackedBody calls done once when the response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	done func()
+	once sync.Once
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// trackedUpgrade is the body of a 101 response, which the proxy also writes the client's data to
+type trackedUpgrade struct {
+	*trackedBody
+	io.Writer
+}
+
+// httpListener accepts the client connections of an HTTP listener. PROXY protocol headers are read
+// and TLS is started outside the accept loop, with the settings current when a connection arrives.
+type httpListener struct {
+	net.Listener
+	settings func() listenerSettings
+	conns    chan net.Conn
+	done     chan struct{} // Closed when the socket is closed
+	err      error         // Set before done is closed
+}
+
+func newHTTPListener(listener net.Listener, settings func() listenerSettings) *httpListener {
+	l := &httpListener{Listener: listener, settings: settings, conns: make(chan net.Conn), done: make(chan struct{})}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *httpListener) acceptLoop() {
+	defer close(l.done)
+	for {
+		conn, err := l.Listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			l.err = err
+			return
+		}
+		if err != nil {
+			log.Printf("[HTTPBalancer] Failed to accept connection: %v", err)
+			continue
+		}
+		log.Debugf("[HTTPBalancer] Accepted new connection from %s", conn.RemoteAddr().String())
+		go l.prepare(conn)
+	}
+}
+
+func (l *httpListener) prepare(conn net.Conn) {
+	settings := l.settings()
+	if settings.acceptProxy {
+		proxiedClient, err := readProxyHeader(conn, settings.trustedProxies)
+		if err != nil {
+			log.Printf("[Connection] Rejected connection: %v", err)
+			conn.Close()
+			return
+		}
+		conn = proxiedClient
+	}
+	if settings.tls.Server != nil {
+		// The server completes the handshake
+		conn = tls.Server(conn, settings.tls.Server)
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept returns the next prepared connection
+func (l *httpListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
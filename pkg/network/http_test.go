@@ -0,0 +1,312 @@
+package network
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/backend"
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+)
+
+// httpBackend returns the port of an HTTP server on 127.0.0.1 answering every request with handler
+func httpBackend(t *testing.T, handler http.HandlerFunc) int {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// namedBackend returns the port of an HTTP server on 127.0.0.1 answering every request with its name
+func namedBackend(t *testing.T, name string) int {
+	t.Helper()
+	return httpBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	})
+}
+
+// serveHTTPBalancer serves hb on a loopback port until the test ends and returns its address
+func serveHTTPBalancer(t *testing.T, hb *HTTPBalancer) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go hb.Serve(listener)
+	t.Cleanup(hb.Stop)
+	return listener.Addr().String()
+}
+
+// get sends a request for path with the given Host header and headers to addr
+func get(t *testing.T, addr, host, path string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = host
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	client := &http.Client{
+		Timeout:       5 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s%s: %v", host, path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the response to GET %s%s: %v", host, path, err)
+	}
+	return resp, string(body)
+}
+
+func TestHTTPRoute(t *testing.T) {
+	settings := listenerSettings{routes: []Route{
+		{Pool: "api-v2", Hosts: []string{"api.example.com"}, PathPrefix: "/v2"},
+		{Pool: "api", Hosts: []string{"api.example.com"}},
+		{Pool: "static", PathPrefix: "/static/"},
+		{Pool: "wildcard", Hosts: []string{"*.example.com"}},
+	}}
+	tests := []struct {
+		host string
+		path string
+		want string
+	}{
+		{host: "api.example.com", path: "/v2", want: "api-v2"},
+		{host: "api.example.com", path: "/v2/users", want: "api-v2"},
+		{host: "api.example.com", path: "/v21", want: "api"},
+		{host: "API.Example.com:8080", path: "/v2/users", want: "api-v2"},
+		{host: "api.example.com", path: "/", want: "api"},
+		{host: "www.example.com", path: "/static/app.js", want: "static"},
+		{host: "www.example.com", path: "/static", want: "static"},
+		{host: "www.example.com", path: "/", want: "wildcard"},
+		{host: "example.com", path: "/static/app.js", want: "static"},
+		{host: "example.com", path: "/", want: ""},
+		{host: "[2001:db8::1]:80", path: "/", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			var got string
+			if route := settings.httpRoute(tt.host, tt.path); route != nil {
+				got = route.Pool
+			}
+			if got != tt.want {
+				t.Errorf("httpRoute(%q, %q) = %q, want %q", tt.host, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchPathPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		path   string
+		want   bool
+	}{
+		{prefix: "/api", path: "/api", want: true},
+		{prefix: "/api", path: "/api/v1", want: true},
+		{prefix: "/api", path: "/apis", want: false},
+		{prefix: "/api/", path: "/api", want: true},
+		{prefix: "/api/", path: "/api/v1", want: true},
+		{prefix: "/api", path: "/", want: false},
+		{prefix: "/", path: "/anything", want: true},
+		{prefix: "/", path: "/", want: true},
+	}
+	for _, tt := range tests {
+		if got := matchPathPrefix(tt.prefix, tt.path); got != tt.want {
+			t.Errorf("matchPathPrefix(%q, %q) = %t, want %t", tt.prefix, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPBalancerRoutes(t *testing.T) {
+	bm := healthyManager(t, k8sutils.NodeDetails{Name: "backend", IP: "127.0.0.1"})
+	routes := []Route{
+		{Pool: "api", Hosts: []string{"api.example.com"}, BackendManager: bm, BackendPort: namedBackend(t, "api"), Algorithm: backend.RoundRobin},
+		{Pool: "static", PathPrefix: "/static", BackendManager: bm, BackendPort: namedBackend(t, "static"), Algorithm: backend.RoundRobin},
+	}
+	hb := NewHTTPBalancer(testListener(config.ModeHTTP, namedBackend(t, "default")), bm, routes, TLS{})
+	addr := serveHTTPBalancer(t, hb)
+
+	tests := []struct {
+		host string
+		path string
+		want string
+	}{
+		{host: "api.example.com", path: "/static/app.js", want: "api"},
+		{host: "www.example.com", path: "/static/app.js", want: "static"},
+		{host: "www.example.com", path: "/statics", want: "default"},
+		{host: "www.example.com", path: "/", want: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			resp, body := get(t, addr, tt.host, tt.path, nil)
+			if resp.StatusCode != http.StatusOK || body != tt.want {
+				t.Errorf("GET %s%s answered by %q with status %d, want %q", tt.host, tt.path, body, resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPBalancerForwardedHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	backendPort := httpBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	})
+	bm := healthyManager(t, k8sutils.NodeDetails{Name: "backend", IP: "127.0.0.1"})
+	addr := serveHTTPBalancer(t, NewHTTPBalancer(testListener(config.ModeHTTP, backendPort), bm, nil, TLS{}))
+
+	// Forwarded headers sent by the client are replaced, not trusted
+	resp, _ := get(t, addr, "app.example.com", "/", http.Header{
+		"X-Forwarded-For":   {"203.0.113.9"},
+		"X-Forwarded-Host":  {"evil.example.com"},
+		"X-Forwarded-Proto": {"https"},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	header := <-received
+	want := map[string]string{
+		"X-Forwarded-For":   "127.0.0.1",
+		"X-Forwarded-Host":  "app.example.com",
+		"X-Forwarded-Proto": "http",
+	}
+	for key, value := range want {
+		if got := header.Values(key); len(got) != 1 || got[0] != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestHTTPBalancerRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		dialRetries int
+		wantAllOK   bool
+	}{
+		{name: "no retries", dialRetries: 0, wantAllOK: false},
+		{name: "retry another backend", dialRetries: 1, wantAllOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refused, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			refusedPort := refused.Addr().(*net.TCPAddr).Port
+			refused.Close()
+			bm := healthyManager(t,
+				k8sutils.NodeDetails{Name: "down", IP: "127.0.0.1", Port: refusedPort},
+				k8sutils.NodeDetails{Name: "up", IP: "127.0.0.1", Port: namedBackend(t, "up")},
+			)
+			listener := testListener(config.ModeHTTP, 80)
+			listener.DialRetries = tt.dialRetries
+			addr := serveHTTPBalancer(t, NewHTTPBalancer(listener, bm, nil, TLS{}))
+
+			// Round robin sends one of two requests to the backend that refuses connections
+			allOK := true
+			for i := 0; i < 2; i++ {
+				resp, body := get(t, addr, "app.example.com", "/", nil)
+				if resp.StatusCode == http.StatusOK && body != "up" {
+					t.Errorf("request answered by %q, want %q", body, "up")
+				}
+				if resp.StatusCode != http.StatusOK {
+					if resp.StatusCode != http.StatusBadGateway {
+						t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadGateway)
+					}
+					allOK = false
+				}
+			}
+			if allOK != tt.wantAllOK {
+				t.Errorf("all requests succeeded = %t, want %t", allOK, tt.wantAllOK)
+			}
+		})
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name         string
+		redirect     config.RedirectConfig
+		host         string
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "default", host: "app.example.com", target: "/login?next=/", wantStatus: http.StatusPermanentRedirect, wantLocation: "https://app.example.com/login?next=/"},
+		{name: "request port dropped", host: "app.example.com:8080", target: "/", wantStatus: http.StatusPermanentRedirect, wantLocation: "https://app.example.com/"},
+		{name: "moved permanently", redirect: config.RedirectConfig{StatusCode: http.StatusMovedPermanently}, host: "app.example.com", target: "/", wantStatus: http.StatusMovedPermanently, wantLocation: "https://app.example.com/"},
+		{name: "HTTPS port", redirect: config.RedirectConfig{Port: 8443}, host: "app.example.com:8080", target: "/a", wantStatus: http.StatusPermanentRedirect, wantLocation: "https://app.example.com:8443/a"},
+		{name: "default HTTPS port", redirect: config.RedirectConfig{Port: 443}, host: "app.example.com", target: "/", wantStatus: http.StatusPermanentRedirect, wantLocation: "https://app.example.com/"},
+		{name: "IPv6", host: "[2001:db8::1]:80", target: "/", wantStatus: http.StatusPermanentRedirect, wantLocation: "https://[2001:db8::1]/"},
+		{name: "IPv6 with HTTPS port", redirect: config.RedirectConfig{Port: 8443}, host: "[2001:db8::1]", target: "/", wantStatus: http.StatusPermanentRedirect, wantLocation: "https://[2001:db8::1]:8443/"},
+		{name: "no host", target: "/", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.redirect.HTTPS = true
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Host = tt.host
+			recorder := httptest.NewRecorder()
+			redirectToHTTPS(recorder, req, tt.redirect)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if location := recorder.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", location, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestRedirectException(t *testing.T) {
+	settings := listenerSettings{redirect: config.RedirectConfig{HTTPS: true, Exceptions: []config.RedirectException{
+		{PathPrefix: "/.well-known/acme-challenge"},
+		{Hosts: []string{"legacy.example.com", "*.internal.example.com"}},
+		{Hosts: []string{"api.example.com"}, PathPrefix: "/health"},
+	}}}
+	tests := []struct {
+		host string
+		path string
+		want bool
+	}{
+		{host: "app.example.com", path: "/.well-known/acme-challenge/token", want: true},
+		{host: "app.example.com", path: "/.well-known/acme-challenges", want: false},
+		{host: "LEGACY.example.com:80", path: "/", want: true},
+		{host: "db.internal.example.com", path: "/admin", want: true},
+		{host: "api.example.com", path: "/health", want: true},
+		{host: "api.example.com", path: "/v1", want: false},
+		{host: "app.example.com", path: "/health", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			if got := settings.redirectException(tt.host, tt.path); got != tt.want {
+				t.Errorf("redirectException(%q, %q) = %t, want %t", tt.host, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPBalancerRedirect(t *testing.T) {
+	bm := healthyManager(t, k8sutils.NodeDetails{Name: "backend", IP: "127.0.0.1"})
+	listener := testListener(config.ModeHTTP, namedBackend(t, "backend"))
+	listener.Redirect = config.RedirectConfig{HTTPS: true, Exceptions: []config.RedirectException{{PathPrefix: "/.well-known/acme-challenge"}}}
+	addr := serveHTTPBalancer(t, NewHTTPBalancer(listener, bm, nil, TLS{}))
+
+	resp, _ := get(t, addr, "app.example.com", "/login", nil)
+	if resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != "https://app.example.com/login" {
+		t.Errorf("GET /login = %d to %q, want a redirect to HTTPS", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp, body := get(t, addr, "app.example.com", "/.well-known/acme-challenge/token", nil)
+	if resp.StatusCode != http.StatusOK || body != "backend" {
+		t.Errorf("GET of an ACME challenge = %d from %q, want it proxied to the backend", resp.StatusCode, body)
+	}
+}
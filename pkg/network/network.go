@@ -1,51 +1,254 @@
 package network
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
-	"strconv"
-	"strings"
+	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/supporttools/GoKubeBalancer/pkg/backend"
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
 	"github.com/supporttools/GoKubeBalancer/pkg/logging"
+	"github.com/supporttools/GoKubeBalancer/pkg/proxyproto"
+	"github.com/supporttools/GoKubeBalancer/pkg/sockets"
 )
 
 var log = logging.SetupLogging()
 
 // TCPBalancer manages TCP connections and routes them to backends
 type TCPBalancer struct {
-	frontendPort   int // Port to listen for incoming client connections
-	backendPort    int // Default port for connecting to the backend servers
+	settings      listenerSettings
+	listener      net.Listener     // Socket currently accepting connections, nil when stopped
+	stopped       bool             // Set by Stop; sockets served afterwards are closed right away
+	settingsMutex sync.RWMutex     // Guards settings, listener and stopped
+	warmPool      *warmPool        // Pre-dialed backend connections, empty unless the listener enables it
+	activeConns   map[string]int64 // Active connections per backend IP
+	connections   map[*proxiedConn]struct{}
+	forceClosed   bool // Set once remaining connections were force-closed on shutdown
+	connsMutex    sync.Mutex
+}
+
+// proxiedConn is an established client connection and its backend connection
+type proxiedConn struct {
+	client  net.Conn
+	backend net.Conn
+}
+
+// DrainStats summarises how the connections of a listener ended during shutdown
+type DrainStats struct {
+	Listener    string
+	Connections int // Connections open when draining started
+	Drained     int // Connections that finished before the deadline
+	ForceClosed int // Connections closed at the deadline
+}
+
+// drainPollInterval is how often Shutdown checks whether all connections have finished
+const drainPollInterval = 100 * time.Millisecond
+
+// proxyHeaderTimeout is how long a trusted proxy gets to send the PROXY protocol header
+const proxyHeaderTimeout = 5 * time.Second
+
+// errNoBackend is returned when every backend of a pool is unhealthy or failed to connect
+var errNoBackend = errors.New("no healthy backend available")
+
+// listenerSettings are the parts of a TCPBalancer that can change while it runs. Each connection
+// uses the settings that were current when it was accepted.
+type listenerSettings struct {
+	name           string // Listener name used in logs
+	listenAddr     string // Address to listen for incoming client connections
+	backendPort    int    // Default port for connecting to the backend servers
 	backendManager *backend.BackendManager
-	pool           map[string][]net.Conn
-	poolMutex      sync.Mutex
+	algorithm      backend.Algorithm
+	dialTimeout    time.Duration         // Timeout for each backend dial attempt
+	dialRetries    int                   // Number of other backends to try after a failed dial
+	idleTimeout    time.Duration         // Close connections without traffic in either direction for this long, 0 disables
+	flowTimeout    time.Duration         // Forget UDP flows without datagrams in either direction for this long
+	proxyProtocol  int                   // PROXY protocol version sent to the backends, 0 disables
+	proxyTLVs      []proxyproto.TLV      // Extra TLVs sent in version 2 headers
+	acceptProxy    bool                  // Read the client address from a PROXY protocol header
+	trustedProxies []*net.IPNet          // Sources allowed to connect when acceptProxy is set
+	routes         []Route               // Other pools, chosen by server name or by HTTP host and path
+	tls            TLS                   // Termination and re-encryption, zero to pass TLS through
+	transport      *http.Transport       // Keeps connections to the backends of HTTP listeners, nil for TCP
+	redirect       config.RedirectConfig // Redirect HTTP requests to HTTPS
+	warmPool       config.WarmPoolConfig // Pre-dialed connections kept per backend of TCP listeners
 }
 
-// NewTCPBalancer creates a new instance of TCPBalancer with a BackendManager
-func NewTCPBalancer(frontendPort int, backendPort int, bm *backend.BackendManager) *TCPBalancer {
+// NewTCPBalancer creates a new instance of TCPBalancer for a listener, its BackendManager, the
+// routes to other pools and its TLS configuration
+func NewTCPBalancer(listener config.ListenerConfig, bm *backend.BackendManager, routes []Route, tlsSettings TLS) *TCPBalancer {
 	return &TCPBalancer{
-		frontendPort:   frontendPort,
-		backendPort:    backendPort,
+		settings:    newListenerSettings(listener, bm, routes, tlsSettings),
+		warmPool:    newWarmPool(),
+		activeConns: make(map[string]int64),
+		connections: make(map[*proxiedConn]struct{}),
+	}
+}
+
+func newListenerSettings(listener config.ListenerConfig, bm *backend.BackendManager, routes []Route, tlsSettings TLS) listenerSettings {
+	proxyTLVs := make([]proxyproto.TLV, 0, len(listener.ProxyProtocolTLVs))
+	for _, tlv := range listener.ProxyProtocolTLVs {
+		proxyTLVs = append(proxyTLVs, proxyproto.TLV{Type: byte(tlv.Type), Value: []byte(tlv.Value)})
+	}
+	trustedProxies, _ := config.ParseCIDRs(listener.TrustedProxies) // Validated with the configuration
+	return listenerSettings{
+		name:           listener.Name,
+		listenAddr:     listener.ListenAddress(),
+		backendPort:    listener.BackendPort,
 		backendManager: bm,
-		pool:           make(map[string][]net.Conn),
+		algorithm:      backend.Algorithm(listener.Algorithm),
+		dialTimeout:    listener.DialTimeout.Duration,
+		dialRetries:    listener.DialRetries,
+		idleTimeout:    listener.IdleTimeout.Duration,
+		flowTimeout:    listener.FlowTimeout.Duration,
+		proxyProtocol:  config.ProxyProtocolVersion(listener.ProxyProtocol),
+		proxyTLVs:      proxyTLVs,
+		acceptProxy:    listener.AcceptProxyProtocol,
+		trustedProxies: trustedProxies,
+		routes:         routes,
+		tls:            tlsSettings,
+		redirect:       listener.Redirect,
+		warmPool:       listener.WarmPool,
+	}
+}
+
+// Update applies new listener settings, backend pool, routes and TLS configuration to connections
+// accepted from now on. A changed listen address only takes effect on the next Start.
+func (tb *TCPBalancer) Update(listener config.ListenerConfig, bm *backend.BackendManager, routes []Route, tlsSettings TLS) {
+	tb.settingsMutex.Lock()
+	defer tb.settingsMutex.Unlock()
+	tb.settings = newListenerSettings(listener, bm, routes, tlsSettings)
+}
+
+// currentSettings returns a snapshot of the listener settings
+func (tb *TCPBalancer) currentSettings() listenerSettings {
+	tb.settingsMutex.RLock()
+	defer tb.settingsMutex.RUnlock()
+	return tb.settings
+}
+
+// ActiveConnections returns the number of connections currently proxied to a backend
+func (tb *TCPBalancer) ActiveConnections(backendIP string) int64 {
+	tb.connsMutex.Lock()
+	defer tb.connsMutex.Unlock()
+	return tb.activeConns[backendIP]
+}
+
+// trackConnection adjusts the active connection count for a backend by delta
+func (tb *TCPBalancer) trackConnection(backendIP string, delta int64) {
+	tb.connsMutex.Lock()
+	defer tb.connsMutex.Unlock()
+	tb.activeConns[backendIP] += delta
+	if tb.activeConns[backendIP] <= 0 {
+		delete(tb.activeConns, backendIP)
+	}
+}
+
+// registerConnection records an established connection so it can be drained on shutdown. It returns
+// false if the balancer has already force-closed its connections.
+func (tb *TCPBalancer) registerConnection(conn *proxiedConn) bool {
+	tb.connsMutex.Lock()
+	defer tb.connsMutex.Unlock()
+	if tb.forceClosed {
+		return false
+	}
+	tb.connections[conn] = struct{}{}
+	return true
+}
+
+func (tb *TCPBalancer) unregisterConnection(conn *proxiedConn) {
+	tb.connsMutex.Lock()
+	defer tb.connsMutex.Unlock()
+	delete(tb.connections, conn)
+}
+
+// OpenConnections returns the number of established connections
+func (tb *TCPBalancer) OpenConnections() int {
+	tb.connsMutex.Lock()
+	defer tb.connsMutex.Unlock()
+	return len(tb.connections)
+}
+
+// closeConnections closes every established connection and refuses new ones, returning how many were closed
+func (tb *TCPBalancer) closeConnections() int {
+	tb.connsMutex.Lock()
+	defer tb.connsMutex.Unlock()
+	tb.forceClosed = true
+	for conn := range tb.connections {
+		conn.client.Close()
+		conn.backend.Close()
 	}
+	return len(tb.connections)
 }
 
-// Start listens on the specified frontend port and handles incoming connections
-func (tb *TCPBalancer) Start() {
-	listenAddr := "0.0.0.0:" + strconv.Itoa(tb.frontendPort) // Listen on the frontend port
-	listener, err := net.Listen("tcp", listenAddr)
+// Shutdown stops accepting connections and waits for the established ones to finish. Connections
+// still open when ctx is done are closed.
+func (tb *TCPBalancer) Shutdown(ctx context.Context) DrainStats {
+	tb.Stop()
+	stats := DrainStats{Listener: tb.currentSettings().name, Connections: tb.OpenConnections()}
+	log.Printf("[TCPBalancer] Draining %d connections of listener %s", stats.Connections, stats.Listener)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for tb.OpenConnections() > 0 {
+		select {
+		case <-ctx.Done():
+			stats.ForceClosed = tb.closeConnections()
+			stats.Drained = max(stats.Connections-stats.ForceClosed, 0)
+			return stats
+		case <-ticker.C:
+		}
+	}
+	stats.Drained = stats.Connections
+	return stats
+}
+
+// Start listens on the listener's address, or adopts an inherited socket for it, and handles incoming
+// connections in the background
+func (tb *TCPBalancer) Start() error {
+	settings := tb.currentSettings()
+	listener, err := sockets.Listen(settings.name, settings.listenAddr)
 	if err != nil {
-		log.Fatalf("[TCPBalancer] Failed to listen on port %d: %v", tb.frontendPort, err)
+		return fmt.Errorf("listen on %s for listener %s: %w", settings.listenAddr, settings.name, err)
+	}
+	go tb.Serve(listener)
+	return nil
+}
+
+// Serve accepts connections on listener until it is closed, replacing any socket served before.
+// Connections accepted from the previous socket are not affected.
+func (tb *TCPBalancer) Serve(listener net.Listener) {
+	tb.settingsMutex.Lock()
+	if tb.stopped {
+		// Stop ran before this goroutine got to register the socket
+		tb.settingsMutex.Unlock()
+		listener.Close()
+		return
+	}
+	previous := tb.listener
+	tb.listener = listener
+	name := tb.settings.name
+	tb.warmPool.start(tb.currentSettings)
+	tb.settingsMutex.Unlock()
+	if previous != nil {
+		previous.Close()
 	}
-	defer listener.Close()
 
-	log.Printf("[TCPBalancer] TCP Load Balancer started on port %d", tb.frontendPort)
+	log.Printf("[TCPBalancer] TCP Load Balancer %s started on %s", name, listener.Addr())
 
 	for {
 		clientConn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			log.Printf("[TCPBalancer] Stopped accepting connections on %s", listener.Addr())
+			return
+		}
 		if err != nil {
 			log.Printf("[TCPBalancer] Failed to accept connection: %v", err)
 			continue
@@ -55,43 +258,93 @@ func (tb *TCPBalancer) Start() {
 	}
 }
 
+// Stop closes the listening socket and the pooled backend connections; connections already accepted keep running
+func (tb *TCPBalancer) Stop() {
+	tb.settingsMutex.Lock()
+	listener := tb.listener
+	tb.listener = nil
+	tb.stopped = true
+	tb.warmPool.close()
+	tb.settingsMutex.Unlock()
+	if listener != nil {
+		listener.Close()
+	}
+}
+
 func (tb *TCPBalancer) handleConnection(clientConn net.Conn) {
 	defer clientConn.Close()
-	clientIP, _, _ := net.SplitHostPort(clientConn.RemoteAddr().String())
+	settings := tb.currentSettings()
 
-	// Set a read timeout to detect if the connection is idle and should be closed
-	err := clientConn.SetReadDeadline(time.Now().Add(30 * time.Second)) // Adjust as needed
-	if err != nil {
-		log.Printf("[Connection] Failed to set read deadline for client %s: %v", clientIP, err)
-		return
+	// Behind another proxy the client address comes from its PROXY protocol header
+	if settings.acceptProxy {
+		proxiedClient, err := readProxyHeader(clientConn, settings.trustedProxies)
+		if err != nil {
+			log.Printf("[Connection] Rejected connection: %v", err)
+			return
+		}
+		clientConn = proxiedClient
 	}
+	clientIP, _, _ := net.SplitHostPort(clientConn.RemoteAddr().String())
 
-	// Acquire a connection from the pool or create a new one if not available
-	tb.poolMutex.Lock()
-	backendIP := tb.backendManager.GetBackendByIP(clientIP)
-	if backendIP == "" {
-		log.Printf("[Connection] No healthy backend available for client %s", clientIP)
-		return
+	// Route TLS connections to another pool by the server name of their ClientHello
+	var serverName string
+	if settings.tls.Server != nil {
+		tlsConn, err := terminateTLS(clientConn, settings.tls.Server)
+		if err != nil {
+			log.Printf("[Connection] TLS handshake with client %s failed: %v", clientIP, err)
+			return
+		}
+		clientConn = tlsConn
+		serverName = tlsConn.ConnectionState().ServerName
+	} else if len(settings.routes) > 0 {
+		var err error
+		if serverName, clientConn, err = readServerName(clientConn, clientHelloTimeout); err != nil {
+			log.Printf("[Connection] Failed to read TLS ClientHello from client %s: %v", clientIP, err)
+			return
+		}
 	}
-
-	backendAddr := backendIP + ":" + strconv.Itoa(tb.backendPort)
-	if strings.Contains(backendIP, ":") {
-		backendAddr = backendIP // Use the IP:Port directly if it's already formatted
+	if serverName != "" {
+		if route := settings.route(serverName); route != nil {
+			log.Debugf("[Connection] Routing client %s for %s to pool %s", clientIP, serverName, route.Pool)
+			settings.backendManager = route.BackendManager
+			settings.backendPort = route.BackendPort
+			settings.algorithm = route.Algorithm
+		}
 	}
 
-	var backendConn net.Conn
-	if conn, ok := tb.pool[backendAddr]; ok && len(conn) > 0 {
-		backendConn = conn[0]
-		tb.pool[backendAddr] = conn[1:] // Remove the used connection from the pool
-	} else {
+	var proxyHeader []byte
+	if settings.proxyProtocol != 0 {
+		header := &proxyproto.Header{
+			Version:     settings.proxyProtocol,
+			Source:      clientConn.RemoteAddr(),
+			Destination: clientConn.LocalAddr(),
+			TLVs:        settings.proxyTLVs,
+		}
+		if serverName != "" {
+			header.TLVs = append(slices.Clip(header.TLVs), proxyproto.TLV{Type: proxyproto.TypeAuthority, Value: []byte(serverName)})
+		}
 		var err error
-		backendConn, err = net.Dial("tcp", backendAddr)
-		if err != nil {
-			log.Printf("[Connection] Failed to connect to backend %s for client %s: %v", backendAddr, clientIP, err)
+		if proxyHeader, err = header.Bytes(); err != nil {
+			log.Printf("[Connection] Failed to build PROXY protocol header for client %s: %v", clientIP, err)
 			return
 		}
 	}
-	tb.poolMutex.Unlock()
+
+	backendIP, backendAddr, backendConn, err := tb.connectBackend(settings, clientConn.RemoteAddr().String(), clientIP, serverName, proxyHeader)
+	if err != nil {
+		log.Printf("[Connection] No backend available for client %s: %v", clientIP, err)
+		return
+	}
+
+	conn := &proxiedConn{client: clientConn, backend: backendConn}
+	if !tb.registerConnection(conn) {
+		backendConn.Close()
+		return
+	}
+	defer tb.unregisterConnection(conn)
+
+	tb.trackConnection(backendIP, 1)
+	defer tb.trackConnection(backendIP, -1)
 
 	// Use the acquired or newly created connection for data transfer
 	var wg sync.WaitGroup
@@ -100,23 +353,181 @@ func (tb *TCPBalancer) handleConnection(clientConn net.Conn) {
 	clientToBackendBytes := make(chan int64)
 	backendToClientBytes := make(chan int64)
 
+	// Close the connection once neither side has sent anything for the idle timeout
+	if settings.idleTimeout > 0 {
+		activity := &atomic.Int64{}
+		activity.Store(time.Now().UnixNano())
+		clientConn = &idleConn{Conn: clientConn, timeout: settings.idleTimeout, activity: activity}
+		backendConn = &idleConn{Conn: backendConn, timeout: settings.idleTimeout, activity: activity}
+	}
+
+	// Record read errors on the backend side so resets can be reported as passive health signals
+	backendReader := &readErrorConn{Conn: backendConn}
+
 	go tb.copyAndClose(clientConn, backendConn, &wg, clientToBackendBytes)
-	go tb.copyAndClose(backendConn, clientConn, &wg, backendToClientBytes)
+	go tb.copyAndClose(backendReader, clientConn, &wg, backendToClientBytes)
 
 	clientDataSize := <-clientToBackendBytes
 	backendDataSize := <-backendToClientBytes
 
+	if errors.Is(backendReader.readErr, syscall.ECONNRESET) {
+		log.Printf("[Connection] Backend %s reset the connection for client %s", backendAddr, clientIP)
+		settings.backendManager.ReportFailure(backendIP, backendReader.readErr)
+	}
+
 	log.Debugf("[Connection] Transfered %d bytes from client %s to backend and back", clientDataSize+backendDataSize, clientIP)
 }
 
+// connectBackend selects a backend and dials it, retrying up to dialRetries other healthy backends
+// when the dial fails. The PROXY protocol header, if any, is sent and TLS to the backend started
+// before the connection is returned. It returns the backend IP, its address and the connection.
+func (tb *TCPBalancer) connectBackend(settings listenerSettings, clientAddr, clientIP, serverName string, proxyHeader []byte) (string, string, net.Conn, error) {
+	var tried []string
+	var lastErr error = errNoBackend
+
+	for attempt := 0; attempt <= settings.dialRetries; attempt++ {
+		backendIP := settings.backendManager.SelectBackend(clientAddr, settings.algorithm, tb, tried...)
+		if backendIP == "" {
+			break
+		}
+		backendAddr := settings.backendManager.BackendAddress(backendIP, settings.backendPort)
+
+		// Use a pre-dialed connection from the warm pool. One the backend closed meanwhile says nothing
+		// about its health, so a new connection is dialed instead.
+		var backendConn net.Conn
+		var err error
+		if pooled := tb.warmPool.take(backendAddr); pooled != nil {
+			if backendConn, err = setupBackendConn(pooled, settings, backendAddr, serverName, proxyHeader); err != nil {
+				log.Debugf("[Connection] Pooled connection to backend %s failed, dialing a new one: %v", backendAddr, err)
+			}
+		}
+		if backendConn == nil {
+			if backendConn, err = net.DialTimeout("tcp", backendAddr, settings.dialTimeout); err == nil {
+				backendConn, err = setupBackendConn(backendConn, settings, backendAddr, serverName, proxyHeader)
+			}
+		}
+		if err != nil {
+			log.Printf("[Connection] Failed to connect to backend %s for client %s (attempt %d): %v", backendAddr, clientIP, attempt+1, err)
+			if !certificateRejected(err) {
+				settings.backendManager.ReportFailure(backendIP, err)
+			}
+			tried = append(tried, backendIP)
+			lastErr = err
+			continue
+		}
+		settings.backendManager.ReportSuccess(backendIP)
+		return backendIP, backendAddr, backendConn, nil
+	}
+	return "", "", nil, lastErr
+}
+
+// setupBackendConn sends the PROXY protocol header, if any, over a backend connection and starts TLS
+// to the backend if the listener re-encrypts. The connection is closed if either fails.
+func setupBackendConn(conn net.Conn, settings listenerSettings, backendAddr, serverName string, proxyHeader []byte) (net.Conn, error) {
+	if err := sendProxyHeader(conn, proxyHeader, settings.dialTimeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if settings.tls.Backend == nil {
+		return conn, nil
+	}
+	tlsConn, err := encryptBackend(conn, settings.tls.Backend, serverName, backendAddr, settings.dialTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// readProxyHeader checks that conn comes from a trusted proxy and returns a connection reporting the
+// client address from its PROXY protocol header
+func readProxyHeader(conn net.Conn, trustedProxies []*net.IPNet) (net.Conn, error) {
+	proxyIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if !trusted(trustedProxies, net.ParseIP(proxyIP)) {
+		return nil, fmt.Errorf("%s is not a trusted proxy", proxyIP)
+	}
+	proxiedClient, err := proxyproto.NewConn(conn, proxyHeaderTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("read PROXY protocol header from %s: %w", proxyIP, err)
+	}
+	return proxiedClient, nil
+}
+
+// trusted reports whether ip belongs to one of the networks
+func trusted(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendProxyHeader writes the PROXY protocol header, if any, ahead of the client's data
+func sendProxyHeader(conn net.Conn, header []byte, timeout time.Duration) error {
+	if len(header) == 0 {
+		return nil
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(header); err != nil {
+		return fmt.Errorf("send PROXY protocol header: %w", err)
+	}
+	return conn.SetWriteDeadline(time.Time{})
+}
+
 func (tb *TCPBalancer) copyAndClose(src net.Conn, dst net.Conn, wg *sync.WaitGroup, transferBytes chan<- int64) {
-	defer src.Close()
-	defer dst.Close()
 	bytesWritten, err := io.Copy(dst, src)
-	if err != nil {
+	// Close both sides before reporting, which waits for the other direction; otherwise the peer of a
+	// reset backend would not notice until it sent something itself
+	src.Close()
+	dst.Close()
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		log.Debugf("[Connection] Closing idle connection from %s", src.RemoteAddr())
+	} else if err != nil {
 		log.Printf("[Connection] Failed to copy data between client and backend: %v", err)
-	} else {
-		transferBytes <- bytesWritten
 	}
+	// Always report so handleConnection returns and the connection stops counting as active
+	transferBytes <- bytesWritten
 	wg.Done()
 }
+
+// readErrorConn remembers the first error returned by Read
+type readErrorConn struct {
+	net.Conn
+	readErr error
+}
+
+func (c *readErrorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil && c.readErr == nil {
+		c.readErr = err
+	}
+	return n, err
+}
+
+// idleConn refreshes the read deadline before every read and only lets a read time out when
+// neither direction of the proxied connection has carried traffic for the timeout
+type idleConn struct {
+	net.Conn
+	timeout  time.Duration
+	activity *atomic.Int64 // Last transfer in either direction as Unix nanoseconds, shared by both sides
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	for {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			c.activity.Store(time.Now().UnixNano())
+		}
+		var netErr net.Error
+		if n == 0 && errors.As(err, &netErr) && netErr.Timeout() &&
+			time.Since(time.Unix(0, c.activity.Load())) < c.timeout {
+			continue // The other direction is still active
+		}
+		return n, err
+	}
+}
@@ -0,0 +1,425 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/backend"
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+)
+
+// pipeConn is one end of a net.Pipe with TCP addresses, like an accepted connection
+type pipeConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+// healthyManager returns a BackendManager whose members on 127.0.0.1 are in rotation, their health
+// check being answered by a test server
+func healthyManager(t *testing.T, members ...k8sutils.NodeDetails) *backend.BackendManager {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	healthPort, _ := strconv.Atoi(port)
+	healthCheck, err := backend.NewHealthCheck(config.HealthCheckConfig{
+		Scheme:         "http",
+		Port:           healthPort,
+		Path:           "/healthz",
+		ExpectedStatus: "200",
+		Timeout:        config.Seconds(1),
+		Rise:           1,
+		Fall:           1,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bm := backend.NewManager(members, time.Minute, healthCheck, 0, 0, config.OutlierDetectionConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go bm.HealthChecker(ctx)
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	if !bm.WaitHealthy(waitCtx) {
+		t.Fatal("backends did not become healthy")
+	}
+	for len(bm.HealthyBackends()) < len(members) {
+		if waitCtx.Err() != nil {
+			t.Fatalf("%d of %d backends healthy", len(bm.HealthyBackends()), len(members))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return bm
+}
+
+// serveTCPBalancer serves tb on a port of 127.0.0.1 until the test ends and returns its address
+func serveTCPBalancer(t *testing.T, tb *TCPBalancer) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go tb.Serve(listener)
+	t.Cleanup(tb.Stop)
+	return listener.Addr().String()
+}
+
+// closedPort returns a port on 127.0.0.1 that refuses connections
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// stickyBinding returns the backend the client is bound to, as reported by the sticky admin API
+func stickyBinding(t *testing.T, bm *backend.BackendManager, client string) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	bm.StickyHandler("").ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?client="+client, nil))
+	if recorder.Code != http.StatusOK {
+		return ""
+	}
+	var binding backend.StickyBinding
+	if err := json.NewDecoder(recorder.Body).Decode(&binding); err != nil {
+		t.Fatal(err)
+	}
+	return binding.Backend
+}
+
+// testListener returns the settings of a listener sending its connections to backendPort
+func testListener(mode string, backendPort int) config.ListenerConfig {
+	return config.ListenerConfig{
+		Name:        "test",
+		Mode:        mode,
+		BindAddress: "127.0.0.1",
+		BackendPort: backendPort,
+		Algorithm:   config.AlgorithmRoundRobin,
+		DialTimeout: config.Seconds(1),
+		IdleTimeout: config.Seconds(60),
+		FlowTimeout: config.Seconds(60),
+	}
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		proxy          string
+		header         string
+		wantClient     string
+		wantErr        string
+	}{
+		{
+			name:           "trusted CIDR",
+			trustedProxies: []string{"10.0.0.0/8"},
+			proxy:          "10.1.2.3:40000",
+			header:         "PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n",
+			wantClient:     "192.0.2.1:56324",
+		},
+		{
+			name:           "trusted address",
+			trustedProxies: []string{"192.168.0.0/16", "10.1.2.3"},
+			proxy:          "10.1.2.3:40000",
+			header:         "PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n",
+			wantClient:     "192.0.2.1:56324",
+		},
+		{
+			name:           "trusted IPv6 CIDR",
+			trustedProxies: []string{"fd00::/8"},
+			proxy:          "[fd00::1]:40000",
+			header:         "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n",
+			wantClient:     "[2001:db8::1]:56324",
+		},
+		{
+			name:           "IPv4-mapped proxy address",
+			trustedProxies: []string{"10.1.2.3"},
+			proxy:          "[::ffff:10.1.2.3]:40000",
+			header:         "PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n",
+			wantClient:     "192.0.2.1:56324",
+		},
+		{
+			name:           "untrusted proxy",
+			trustedProxies: []string{"10.0.0.0/8"},
+			proxy:          "192.168.1.1:40000",
+			header:         "PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n",
+			wantErr:        "192.168.1.1 is not a trusted proxy",
+		},
+		{
+			name:           "address outside trusted address",
+			trustedProxies: []string{"10.1.2.3"},
+			proxy:          "10.1.2.4:40000",
+			header:         "PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n",
+			wantErr:        "10.1.2.4 is not a trusted proxy",
+		},
+		{
+			name:    "no trusted proxies",
+			proxy:   "10.1.2.3:40000",
+			header:  "PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n",
+			wantErr: "is not a trusted proxy",
+		},
+		{
+			name:           "trusted proxy without header",
+			trustedProxies: []string{"10.0.0.0/8"},
+			proxy:          "10.1.2.3:40000",
+			header:         "GET / HTTP/1.1\r\n",
+			wantErr:        "read PROXY protocol header from 10.1.2.3",
+		},
+		{
+			name:           "trusted proxy with malformed header",
+			trustedProxies: []string{"10.0.0.0/8"},
+			proxy:          "10.1.2.3:40000",
+			header:         "PROXY TCP4 192.0.2.1\r\n",
+			wantErr:        "malformed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trustedProxies, err := config.ParseCIDRs(tt.trustedProxies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxyAddr, err := net.ResolveTCPAddr("tcp", tt.proxy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, client := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go client.Write([]byte(tt.header))
+
+			conn, err := readProxyHeader(&pipeConn{Conn: server, local: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 443}, remote: proxyAddr}, trustedProxies)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readProxyHeader() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readProxyHeader() unexpected error: %v", err)
+			}
+			if got := conn.RemoteAddr().String(); got != tt.wantClient {
+				t.Errorf("RemoteAddr() = %s, want %s", got, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestTCPBalancerLeastConnections(t *testing.T) {
+	first, second := newTCPBackend(t), newTCPBackend(t)
+	firstKey := net.JoinHostPort("127.0.0.1", strconv.Itoa(first.port))
+	secondKey := net.JoinHostPort("127.0.0.1", strconv.Itoa(second.port))
+	bm := healthyManager(t,
+		k8sutils.NodeDetails{Name: "first", IP: "127.0.0.1", Port: first.port},
+		k8sutils.NodeDetails{Name: "second", IP: "127.0.0.1", Port: second.port},
+	)
+	listenerConfig := testListener(config.ModeTCP, 0)
+	listenerConfig.Algorithm = config.AlgorithmLeastConnections
+	tb := NewTCPBalancer(listenerConfig, bm, nil, TLS{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go tb.Serve(listener)
+	defer tb.Stop()
+
+	// waitCounts waits until the balancer counts the given connections per backend
+	waitCounts := func(wantFirst, wantSecond int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for tb.ActiveConnections(firstKey) != wantFirst || tb.ActiveConnections(secondKey) != wantSecond {
+			if time.Now().After(deadline) {
+				t.Fatalf("active connections = %d and %d, want %d and %d", tb.ActiveConnections(firstKey), tb.ActiveConnections(secondKey), wantFirst, wantSecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	connect := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	connect()
+	waitCounts(1, 0)
+	secondConn := connect()
+	waitCounts(1, 1)
+	connect()
+	waitCounts(2, 1)
+
+	// Closing a connection lowers the count of its backend, which then gets the next client
+	secondConn.Close()
+	waitCounts(2, 0)
+	connect()
+	waitCounts(2, 1)
+	if got := first.accepted(2); got != 2 {
+		t.Errorf("first backend accepted %d connections, want 2", got)
+	}
+	if got := second.accepted(2); got != 2 {
+		t.Errorf("second backend accepted %d connections, want 2", got)
+	}
+}
+
+func TestTCPBalancerDialRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		dialRetries int
+		wantServed  bool // The client is connected to the accepting backend
+	}{
+		{name: "retry another backend", dialRetries: 1, wantServed: true},
+		{name: "no retries", dialRetries: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refusing, accepting := closedPort(t), newTCPBackend(t)
+			refusingKey := net.JoinHostPort("127.0.0.1", strconv.Itoa(refusing))
+			acceptingKey := net.JoinHostPort("127.0.0.1", strconv.Itoa(accepting.port))
+			// Round-robin tries the backends in name order, so the refusing one is dialed first
+			bm := healthyManager(t,
+				k8sutils.NodeDetails{Name: "a-refusing", IP: "127.0.0.1", Port: refusing},
+				k8sutils.NodeDetails{Name: "b-accepting", IP: "127.0.0.1", Port: accepting.port},
+			)
+			listenerConfig := testListener(config.ModeTCP, 0)
+			listenerConfig.DialRetries = tt.dialRetries
+			addr := serveTCPBalancer(t, NewTCPBalancer(listenerConfig, bm, nil, TLS{}))
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if !tt.wantServed {
+				// The balancer closes the client connection once the only attempt failed
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+					t.Errorf("read from the client connection = %v, want EOF", err)
+				}
+				if got := accepting.accepted(1); got != 0 {
+					t.Errorf("accepting backend got %d connections without a retry", got)
+				}
+				if got := stickyBinding(t, bm, "127.0.0.1"); got != refusingKey {
+					t.Errorf("client bound to %q, want %s", got, refusingKey)
+				}
+				return
+			}
+
+			if got := accepting.accepted(1); got != 1 {
+				t.Fatalf("accepting backend got %d connections, want 1", got)
+			}
+			// The binding moved to the backend that worked, so the next connection goes there directly
+			if got := stickyBinding(t, bm, "127.0.0.1"); got != acceptingKey {
+				t.Errorf("client bound to %q, want %s", got, acceptingKey)
+			}
+			again, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer again.Close()
+			if got := accepting.accepted(2); got != 2 {
+				t.Errorf("accepting backend got %d connections, want 2", got)
+			}
+		})
+	}
+}
+
+// resettingBackend returns the port of a TCP server on 127.0.0.1 that resets every connection it accepts
+func resettingBackend(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestTCPBalancerOutlierDetection(t *testing.T) {
+	certificate, _ := testCertificate(t, "app.example.com")
+	tests := []struct {
+		name              string
+		backendPort       func(t *testing.T) int
+		backendTLS        *tls.Config
+		consecutiveErrors int
+		connections       int
+		wantEjected       bool
+	}{
+		{name: "dial failures", backendPort: closedPort, consecutiveErrors: 3, connections: 3, wantEjected: true},
+		{name: "fewer dial failures than the threshold", backendPort: closedPort, consecutiveErrors: 3, connections: 2},
+		// Every connection to the backend succeeds, which clears the count, so only a threshold of one ejects
+		{name: "resets", backendPort: resettingBackend, consecutiveErrors: 1, connections: 1, wantEjected: true},
+		{
+			name:              "certificate rejected",
+			backendPort:       func(t *testing.T) int { return tlsBackend(t, certificate) },
+			backendTLS:        &tls.Config{ServerName: "app.example.com", RootCAs: x509.NewCertPool()},
+			consecutiveErrors: 1,
+			connections:       3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := healthyManager(t, k8sutils.NodeDetails{Name: "backend", IP: "127.0.0.1", Port: tt.backendPort(t)})
+			bm.SetOutlierDetection(config.OutlierDetectionConfig{
+				ConsecutiveErrors:  tt.consecutiveErrors,
+				BaseEjectionTime:   config.Seconds(30),
+				MaxEjectionTime:    config.Seconds(300),
+				MaxEjectionPercent: 100,
+			})
+			addr := serveTCPBalancer(t, NewTCPBalancer(testListener(config.ModeTCP, 0), bm, nil, TLS{Backend: tt.backendTLS}))
+
+			for i := 0; i < tt.connections; i++ {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+					t.Errorf("connection %d: read = %v, want EOF", i+1, err)
+				}
+				conn.Close()
+			}
+
+			// A reset is reported once the balancer closed both sides of the connection
+			deadline := time.Now().Add(time.Second)
+			ejected := len(bm.HealthyBackends()) == 0
+			for tt.wantEjected && !ejected && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+				ejected = len(bm.HealthyBackends()) == 0
+			}
+			if ejected != tt.wantEjected {
+				t.Errorf("backend ejected = %t, want %t", ejected, tt.wantEjected)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package network
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/backend"
+)
+
+// Route sends TLS connections whose server name matches one of Hosts, or HTTP requests whose host
+// matches one of Hosts and whose path starts with PathPrefix, to another backend pool
+type Route struct {
+	Pool           string   // Pool name used in logs
+	Hosts          []string // Server names; "*.example.com" matches every name below example.com, "*" any name
+	PathPrefix     string   // HTTP listeners only; empty matches any path
+	BackendManager *backend.BackendManager
+	BackendPort    int
+	Algorithm      backend.Algorithm
+}
+
+// clientHelloTimeout is how long a client gets to send its TLS ClientHello on listeners with routes
+const clientHelloTimeout = 5 * time.Second
+
+// errClientHelloRead stops the TLS handshake as soon as the ClientHello has been parsed
+var errClientHelloRead = errors.New("client hello read")
+
+// readServerName reads the TLS ClientHello from conn and returns the server name the client asked
+// for, together with a connection that replays everything read so far. The server name is empty for
+// connections that are not TLS or do not use SNI.
+func readServerName(conn net.Conn, timeout time.Duration) (string, net.Conn, error) {
+	var consumed bytes.Buffer
+	var serverName string
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	err := tls.Server(&helloConn{Conn: conn, reader: io.TeeReader(conn, &consumed)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "", nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", nil, err
+	}
+	if !errors.Is(err, errClientHelloRead) {
+		// Not TLS; the bytes are passed on unchanged
+		log.Debugf("[Connection] No TLS ClientHello from %s: %v", conn.RemoteAddr(), err)
+	}
+	return serverName, &replayConn{Conn: conn, reader: io.MultiReader(&consumed, conn)}, nil
+}
+
+// route returns the first route matching serverName, or nil if the listener's own backends are used
+func (settings listenerSettings) route(serverName string) *Route {
+	if serverName == "" {
+		return nil
+	}
+	serverName = strings.ToLower(serverName)
+	for i := range settings.routes {
+		for _, host := range settings.routes[i].Hosts {
+			if matchServerName(strings.ToLower(host), serverName) {
+				return &settings.routes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// matchServerName reports whether a lower case server name matches a lower case host pattern
+func matchServerName(pattern, serverName string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(serverName, pattern[1:])
+	}
+	return pattern == serverName
+}
+
+// helloConn feeds a TLS handshake from reader and discards everything the handshake writes, so the
+// client never sees the aborted handshake
+type helloConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *helloConn) Read(b []byte) (int, error)  { return c.reader.Read(b) }
+func (c *helloConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c *helloConn) Close() error                { return nil }
+
+// replayConn returns the bytes consumed while inspecting a connection before reading from it again
+type replayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,172 @@
+package network
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// recordConn records what a TLS client writes and fails its reads, so the handshake stops after the ClientHello
+type recordConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordConn) Write(b []byte) (int, error) { return c.written.Write(b) }
+func (c *recordConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (c *recordConn) Close() error                { return nil }
+
+// clientHello returns the ClientHello a TLS client sends for the given configuration
+func clientHello(t *testing.T, cfg *tls.Config) []byte {
+	t.Helper()
+	conn := &recordConn{}
+	tls.Client(conn, cfg).Handshake()
+	if conn.written.Len() == 0 {
+		t.Fatal("TLS client did not send a ClientHello")
+	}
+	return conn.written.Bytes()
+}
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		client.Close()
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestReadServerName(t *testing.T) {
+	hello := clientHello(t, &tls.Config{ServerName: "app.example.com"})
+	tests := []struct {
+		name           string
+		input          []byte
+		wantServerName string
+	}{
+		{name: "SNI", input: hello, wantServerName: "app.example.com"},
+		{name: "SNI with ALPN", input: clientHello(t, &tls.Config{ServerName: "h2.example.com", NextProtos: []string{"h2", "http/1.1"}}), wantServerName: "h2.example.com"},
+		{name: "no SNI", input: clientHello(t, &tls.Config{InsecureSkipVerify: true})},
+		{name: "IP address", input: clientHello(t, &tls.Config{ServerName: "192.0.2.1"})},
+		{name: "plain HTTP", input: []byte("GET / HTTP/1.1\r\nHost: app.example.com\r\n\r\n")},
+		{name: "SSH banner", input: []byte("SSH-2.0-OpenSSH_9.6\r\n")},
+		{name: "shorter than a record header", input: []byte{0x16, 0x03}},
+		{name: "truncated record header", input: hello[:4]},
+		{name: "truncated ClientHello", input: hello[:len(hello)/2]},
+		{name: "empty"},
+		{name: "wrong record type", input: append([]byte{0x17}, hello[1:]...)},
+		{name: "garbage handshake", input: []byte{0x16, 0x03, 0x01, 0x00, 0x08, 0x01, 0x00, 0x00, 0x04, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{name: "oversized record", input: []byte{0x16, 0x03, 0x01, 0xFF, 0xFF, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := tcpPair(t)
+			defer server.Close()
+			go func() {
+				client.Write(tt.input)
+				client.Write([]byte("trailer"))
+				client.Close()
+			}()
+
+			serverName, conn, err := readServerName(server, time.Second)
+			if err != nil {
+				t.Fatalf("readServerName() unexpected error: %v", err)
+			}
+			if serverName != tt.wantServerName {
+				t.Errorf("server name = %q, want %q", serverName, tt.wantServerName)
+			}
+			// Everything the client sent reaches the backend unchanged
+			replayed, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("reading the replayed connection: %v", err)
+			}
+			if want := append(append([]byte{}, tt.input...), "trailer"...); !bytes.Equal(replayed, want) {
+				t.Errorf("replayed %q, want %q", replayed, want)
+			}
+		})
+	}
+}
+
+func TestReadServerNameTimeout(t *testing.T) {
+	hello := clientHello(t, &tls.Config{ServerName: "app.example.com"})
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go client.Write(hello[:10])
+
+	_, conn, err := readServerName(server, 50*time.Millisecond)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("readServerName() error = %v, want a timeout", err)
+	}
+	if conn != nil {
+		t.Errorf("readServerName() returned a connection after a timeout")
+	}
+}
+
+func TestRoute(t *testing.T) {
+	settings := listenerSettings{routes: []Route{
+		{Pool: "exact", Hosts: []string{"app.example.com"}},
+		{Pool: "wildcard", Hosts: []string{"*.Example.com", "other.test"}},
+		{Pool: "any", Hosts: []string{"*"}},
+	}}
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{serverName: "app.example.com", want: "exact"},
+		{serverName: "APP.example.COM", want: "exact"},
+		{serverName: "api.example.com", want: "wildcard"},
+		{serverName: "a.b.example.com", want: "wildcard"},
+		{serverName: "other.test", want: "wildcard"},
+		{serverName: "example.com", want: "any"},
+		{serverName: "badexample.com", want: "any"},
+		{serverName: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			var got string
+			if route := settings.route(tt.serverName); route != nil {
+				got = route.Pool
+			}
+			if got != tt.want {
+				t.Errorf("route(%q) = %q, want %q", tt.serverName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchServerName(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		serverName string
+		want       bool
+	}{
+		{pattern: "example.com", serverName: "example.com", want: true},
+		{pattern: "example.com", serverName: "www.example.com", want: false},
+		{pattern: "*.example.com", serverName: "www.example.com", want: true},
+		{pattern: "*.example.com", serverName: "a.b.example.com", want: true},
+		{pattern: "*.example.com", serverName: "example.com", want: false},
+		{pattern: "*.example.com", serverName: "badexample.com", want: false},
+		{pattern: "*", serverName: "anything", want: true},
+	}
+	for _, tt := range tests {
+		if got := matchServerName(tt.pattern, tt.serverName); got != tt.want {
+			t.Errorf("matchServerName(%q, %q) = %t, want %t", tt.pattern, tt.serverName, got, tt.want)
+		}
+	}
+}
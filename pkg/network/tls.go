@@ -0,0 +1,55 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// TLS is the TLS configuration of a listener that terminates TLS
+type TLS struct {
+	Server  *tls.Config // Terminates client connections, nil to pass TLS through to the backends
+	Backend *tls.Config // Re-encrypts connections to the backends, nil for plain TCP
+}
+
+// tlsHandshakeTimeout is how long a TLS handshake with a client or backend may take
+const tlsHandshakeTimeout = 10 * time.Second
+
+// terminateTLS completes the TLS handshake with a client and returns the decrypted connection
+func terminateTLS(conn net.Conn, config *tls.Config) (*tls.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+	tlsConn := tls.Server(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// encryptBackend starts TLS on a backend connection. Without a configured server name the client's
+// server name is sent and verified, or the backend host if the client sent none.
+func encryptBackend(conn net.Conn, config *tls.Config, serverName, backendAddr string, timeout time.Duration) (net.Conn, error) {
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(backendAddr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// certificateRejected reports whether a backend handshake failed because its certificate could not
+// be verified, which says nothing about the backend's health
+func certificateRejected(err error) bool {
+	var verificationErr *tls.CertificateVerificationError
+	return errors.As(err, &verificationErr)
+}
@@ -0,0 +1,180 @@
+package network
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+)
+
+// testCertificate returns a self-signed certificate for the given DNS names and IP addresses and a
+// pool that trusts it
+func testCertificate(t *testing.T, names ...string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// tlsBackend returns the port of a TLS server on 127.0.0.1 that answers every connection with the
+// server name the balancer sent, followed by a newline
+func tlsBackend(t *testing.T, certificate tls.Certificate) int {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tlsConn := conn.(*tls.Conn)
+				if tlsConn.Handshake() == nil {
+					io.WriteString(conn, tlsConn.ConnectionState().ServerName+"\n")
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestTCPBalancerTLS(t *testing.T) {
+	frontendCertificate, frontendRoots := testCertificate(t, "app.example.com", "other.example.com")
+	tests := []struct {
+		name        string
+		backendCert []string // Names on the backend certificate
+		serverName  string   // Configured backend server name
+		clientName  string   // Server name sent by the client
+		wantSent    string   // Server name the backend receives, if the handshake succeeds
+		wantFailure bool     // The backend certificate is rejected and the client disconnected
+	}{
+		{name: "client server name", backendCert: []string{"app.example.com"}, clientName: "app.example.com", wantSent: "app.example.com"},
+		{name: "client server name not on backend certificate", backendCert: []string{"app.example.com"}, clientName: "other.example.com", wantFailure: true},
+		{name: "configured server name", backendCert: []string{"app.example.com"}, serverName: "app.example.com", clientName: "other.example.com", wantSent: "app.example.com"},
+		// No SNI is sent for an IP address, which is verified against the certificate's addresses
+		{name: "backend address without client server name", backendCert: []string{"127.0.0.1"}, wantSent: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendCertificate, backendRoots := testCertificate(t, tt.backendCert...)
+			port := tlsBackend(t, backendCertificate)
+			bm := healthyManager(t, k8sutils.NodeDetails{Name: "tls", IP: "127.0.0.1", Port: port})
+			tlsSettings := TLS{
+				Server:  &tls.Config{Certificates: []tls.Certificate{frontendCertificate}},
+				Backend: &tls.Config{MinVersion: tls.VersionTLS12, ServerName: tt.serverName, RootCAs: backendRoots},
+			}
+			tb := NewTCPBalancer(testListener(config.ModeTCP, 0), bm, nil, tlsSettings)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go tb.Serve(listener)
+			defer tb.Stop()
+
+			// The client verifies the balancer's certificate; without a server name it checks nothing
+			clientConfig := &tls.Config{ServerName: tt.clientName, RootCAs: frontendRoots, InsecureSkipVerify: tt.clientName == ""}
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", listener.Addr().String(), clientConfig)
+			if err != nil {
+				t.Fatalf("handshake with the balancer failed: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			sent, err := bufio.NewReader(conn).ReadString('\n')
+			if tt.wantFailure {
+				if err == nil {
+					t.Errorf("backend answered %q although its certificate is not valid for the server name", sent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("no answer from the backend: %v", err)
+			}
+			if sent = sent[:len(sent)-1]; sent != tt.wantSent {
+				t.Errorf("backend received server name %q, want %q", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestHTTPBalancerBackendTLS(t *testing.T) {
+	tests := []struct {
+		name        string
+		backendCert []string
+		serverName  string
+		wantStatus  int
+		wantSent    string
+	}{
+		// Connections are shared between hosts, so the backend address is verified rather than the Host
+		{name: "backend address", backendCert: []string{"127.0.0.1"}, wantStatus: http.StatusOK, wantSent: ""},
+		{name: "host not verified", backendCert: []string{"app.example.com"}, wantStatus: http.StatusBadGateway},
+		{name: "configured server name", backendCert: []string{"app.example.com"}, serverName: "app.example.com", wantStatus: http.StatusOK, wantSent: "app.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendCertificate, backendRoots := testCertificate(t, tt.backendCert...)
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, r.TLS.ServerName)
+			}))
+			server.TLS = &tls.Config{Certificates: []tls.Certificate{backendCertificate}}
+			server.StartTLS()
+			t.Cleanup(server.Close)
+			port := server.Listener.Addr().(*net.TCPAddr).Port
+
+			bm := healthyManager(t, k8sutils.NodeDetails{Name: "tls", IP: "127.0.0.1", Port: port})
+			tlsSettings := TLS{Backend: &tls.Config{MinVersion: tls.VersionTLS12, ServerName: tt.serverName, RootCAs: backendRoots}}
+			hb := NewHTTPBalancer(testListener(config.ModeHTTP, 0), bm, nil, tlsSettings)
+			addr := serveHTTPBalancer(t, hb)
+
+			resp, body := get(t, addr, "app.example.com", "/", nil)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && body != tt.wantSent {
+				t.Errorf("backend received server name %q, want %q", body, tt.wantSent)
+			}
+		})
+	}
+}
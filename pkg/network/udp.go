@@ -0,0 +1,294 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/backend"
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/sockets"
+)
+
+// maxDatagramSize is the largest UDP payload that can be relayed
+const maxDatagramSize = 64 * 1024
+
+// UDPBalancer relays UDP datagrams between clients and backends. The datagrams of a client address
+// form a flow that stays with one backend until nothing was sent in either direction for the flow timeout.
+type UDPBalancer struct {
+	settings      listenerSettings
+	conn          net.PacketConn      // Socket currently receiving datagrams, nil when stopped
+	stopped       bool                // Set by Stop; sockets served afterwards are closed right away
+	settingsMutex sync.RWMutex        // Guards settings, conn and stopped
+	flows         map[string]*udpFlow // Flows by client address
+	activeConns   map[string]int64    // Active flows per backend IP
+	flowsMutex    sync.Mutex
+}
+
+// udpFlow is a client address and the backend socket its datagrams are relayed through
+type udpFlow struct {
+	clientAddr net.Addr
+	conn       net.PacketConn // Socket the client's datagrams arrived on; replies are sent from it
+	backend    net.Conn
+	backendIP  string
+	activity   atomic.Int64 // Unix nanoseconds of the last datagram in either direction
+}
+
+func (f *udpFlow) touch() {
+	f.activity.Store(time.Now().UnixNano())
+}
+
+// idleSince returns when the last datagram passed through the flow
+func (f *udpFlow) idleSince() time.Time {
+	return time.Unix(0, f.activity.Load())
+}
+
+// NewUDPBalancer creates a new instance of UDPBalancer for a listener and its BackendManager
+func NewUDPBalancer(listener config.ListenerConfig, bm *backend.BackendManager) *UDPBalancer {
+	return &UDPBalancer{
+		settings:    newListenerSettings(listener, bm, nil, TLS{}),
+		flows:       make(map[string]*udpFlow),
+		activeConns: make(map[string]int64),
+	}
+}
+
+// Update applies new listener settings and backend pool to flows started from now on. UDP listeners
+// have no routes or TLS, so those are ignored. A changed listen address only takes effect on the next Start.
+func (ub *UDPBalancer) Update(listener config.ListenerConfig, bm *backend.BackendManager, _ []Route, _ TLS) {
+	ub.settingsMutex.Lock()
+	defer ub.settingsMutex.Unlock()
+	ub.settings = newListenerSettings(listener, bm, nil, TLS{})
+}
+
+// currentSettings returns a snapshot of the listener settings
+func (ub *UDPBalancer) currentSettings() listenerSettings {
+	ub.settingsMutex.RLock()
+	defer ub.settingsMutex.RUnlock()
+	return ub.settings
+}
+
+// ActiveConnections returns the number of flows currently relayed to a backend
+func (ub *UDPBalancer) ActiveConnections(backendIP string) int64 {
+	ub.flowsMutex.Lock()
+	defer ub.flowsMutex.Unlock()
+	return ub.activeConns[backendIP]
+}
+
+// OpenConnections returns the number of flows
+func (ub *UDPBalancer) OpenConnections() int {
+	ub.flowsMutex.Lock()
+	defer ub.flowsMutex.Unlock()
+	return len(ub.flows)
+}
+
+// Start listens on the listener's address, or adopts an inherited socket for it, and relays incoming
+// datagrams in the background
+func (ub *UDPBalancer) Start() error {
+	settings := ub.currentSettings()
+	conn, err := sockets.ListenPacket(settings.name, settings.listenAddr)
+	if err != nil {
+		return fmt.Errorf("listen on %s for listener %s: %w", settings.listenAddr, settings.name, err)
+	}
+	go ub.Serve(conn)
+	return nil
+}
+
+// Serve reads datagrams from conn until it is closed, replacing any socket served before
+func (ub *UDPBalancer) Serve(conn net.PacketConn) {
+	ub.settingsMutex.Lock()
+	if ub.stopped {
+		// Stop ran before this goroutine got to register the socket
+		ub.settingsMutex.Unlock()
+		conn.Close()
+		return
+	}
+	previous := ub.conn
+	ub.conn = conn
+	name := ub.settings.name
+	ub.settingsMutex.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+
+	log.Printf("[UDPBalancer] UDP Load Balancer %s started on %s", name, conn.LocalAddr())
+
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, clientAddr, err := conn.ReadFrom(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			log.Printf("[UDPBalancer] Stopped receiving datagrams on %s", conn.LocalAddr())
+			return
+		}
+		if err != nil {
+			log.Printf("[UDPBalancer] Failed to receive datagram: %v", err)
+			continue
+		}
+		ub.forward(conn, clientAddr, buffer[:n])
+	}
+}
+
+// Stop closes the listening socket and the established flows, since their replies are sent from it
+func (ub *UDPBalancer) Stop() {
+	if closed := ub.stop(); closed > 0 {
+		log.Printf("[UDPBalancer] Closed %d flows of listener %s", closed, ub.currentSettings().name)
+	}
+}
+
+// Shutdown stops receiving datagrams and closes the remaining flows. Without a connection to finish
+// there is nothing to wait for, so every flow still open is counted as force-closed.
+func (ub *UDPBalancer) Shutdown(_ context.Context) DrainStats {
+	closed := ub.stop()
+	stats := DrainStats{Listener: ub.currentSettings().name, Connections: closed, ForceClosed: closed}
+	log.Printf("[UDPBalancer] Closed %d flows of listener %s", stats.Connections, stats.Listener)
+	return stats
+}
+
+// stop closes the listening socket, then closes and forgets every flow, returning how many there were
+func (ub *UDPBalancer) stop() int {
+	ub.settingsMutex.Lock()
+	conn := ub.conn
+	ub.conn = nil
+	ub.stopped = true
+	ub.settingsMutex.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+
+	ub.flowsMutex.Lock()
+	defer ub.flowsMutex.Unlock()
+	closed := len(ub.flows)
+	for key, flow := range ub.flows {
+		// The relay goroutine ends on the closed socket; endFlow finds the flow already gone
+		flow.backend.Close()
+		delete(ub.flows, key)
+	}
+	clear(ub.activeConns)
+	return closed
+}
+
+// forward sends a client datagram to the backend of its flow, starting a flow for new clients
+func (ub *UDPBalancer) forward(conn net.PacketConn, clientAddr net.Addr, datagram []byte) {
+	ub.flowsMutex.Lock()
+	flow, exists := ub.flows[clientAddr.String()]
+	ub.flowsMutex.Unlock()
+	if !exists {
+		var err error
+		if flow, err = ub.startFlow(conn, clientAddr); err != nil {
+			log.Printf("[UDPBalancer] Dropped datagram from client %s: %v", clientAddr, err)
+			return
+		}
+	}
+
+	flow.touch()
+	if _, err := flow.backend.Write(datagram); err != nil {
+		log.Debugf("[UDPBalancer] Failed to send datagram from client %s to backend %s: %v", clientAddr, flow.backend.RemoteAddr(), err)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			ub.currentSettings().backendManager.ReportFailure(flow.backendIP, err)
+			flow.backend.Close()
+		}
+	}
+}
+
+// startFlow selects a backend for a new client and opens the socket its datagrams are relayed
+// through, trying up to dialRetries other healthy backends when that fails
+func (ub *UDPBalancer) startFlow(conn net.PacketConn, clientAddr net.Addr) (*udpFlow, error) {
+	settings := ub.currentSettings()
+	var tried []string
+	var lastErr error = errNoBackend
+
+	for attempt := 0; attempt <= settings.dialRetries; attempt++ {
+		backendIP := settings.backendManager.SelectBackend(clientAddr.String(), settings.algorithm, ub, tried...)
+		if backendIP == "" {
+			break
+		}
+		backendAddr := settings.backendManager.BackendAddress(backendIP, settings.backendPort)
+		backendConn, err := net.DialTimeout("udp", backendAddr, settings.dialTimeout)
+		if err != nil {
+			log.Printf("[UDPBalancer] Failed to open flow to backend %s for client %s (attempt %d): %v", backendAddr, clientAddr, attempt+1, err)
+			settings.backendManager.ReportFailure(backendIP, err)
+			tried = append(tried, backendIP)
+			lastErr = err
+			continue
+		}
+
+		flow := &udpFlow{clientAddr: clientAddr, conn: conn, backend: backendConn, backendIP: backendIP}
+		flow.touch()
+		ub.flowsMutex.Lock()
+		if existing, exists := ub.flows[clientAddr.String()]; exists {
+			// Another socket of this listener started the flow in the meantime
+			ub.flowsMutex.Unlock()
+			backendConn.Close()
+			return existing, nil
+		}
+		ub.flows[clientAddr.String()] = flow
+		ub.activeConns[backendIP]++
+		ub.flowsMutex.Unlock()
+
+		log.Debugf("[UDPBalancer] Started flow from client %s to backend %s", clientAddr, backendAddr)
+		go ub.relay(flow, settings)
+		return flow, nil
+	}
+	return nil, lastErr
+}
+
+// relay sends the backend's datagrams to the client until the flow has been idle for the flow
+// timeout or the backend refuses it, then removes the flow
+func (ub *UDPBalancer) relay(flow *udpFlow, settings listenerSettings) {
+	defer ub.endFlow(flow)
+	buffer := make([]byte, maxDatagramSize)
+	replied := false
+	for {
+		flow.backend.SetReadDeadline(flow.idleSince().Add(settings.flowTimeout))
+		n, err := flow.backend.Read(buffer)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// The client may have sent datagrams while this read was waiting
+			if time.Since(flow.idleSince()) < settings.flowTimeout {
+				continue
+			}
+			log.Debugf("[UDPBalancer] Flow from client %s to backend %s timed out", flow.clientAddr, flow.backend.RemoteAddr())
+			return
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			// The backend answered with ICMP port unreachable
+			log.Printf("[UDPBalancer] Backend %s refused datagrams from client %s", flow.backend.RemoteAddr(), flow.clientAddr)
+			settings.backendManager.ReportFailure(flow.backendIP, err)
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		flow.touch()
+		if !replied {
+			settings.backendManager.ReportSuccess(flow.backendIP)
+			replied = true
+		}
+		if _, err := flow.conn.WriteTo(buffer[:n], flow.clientAddr); err != nil {
+			log.Debugf("[UDPBalancer] Failed to send datagram to client %s: %v", flow.clientAddr, err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+		}
+	}
+}
+
+// endFlow closes a flow and forgets it, so the client's next datagram starts a new one
+func (ub *UDPBalancer) endFlow(flow *udpFlow) {
+	flow.backend.Close()
+	ub.flowsMutex.Lock()
+	defer ub.flowsMutex.Unlock()
+	if ub.flows[flow.clientAddr.String()] != flow {
+		return
+	}
+	delete(ub.flows, flow.clientAddr.String())
+	ub.activeConns[flow.backendIP]--
+	if ub.activeConns[flow.backendIP] <= 0 {
+		delete(ub.activeConns, flow.backendIP)
+	}
+}
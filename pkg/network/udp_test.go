@@ -0,0 +1,131 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+)
+
+// udpEchoServer returns the port of a UDP server on 127.0.0.1 that sends every datagram back
+func udpEchoServer(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buffer[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestUDPBalancerClosesFlows(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(ub *UDPBalancer)
+	}{
+		{name: "stop", stop: func(ub *UDPBalancer) { ub.Stop() }},
+		{
+			name: "shutdown",
+			stop: func(ub *UDPBalancer) {
+				want := DrainStats{Listener: "test", Connections: 1, ForceClosed: 1}
+				if stats := ub.Shutdown(context.Background()); stats != want {
+					t.Errorf("Shutdown() = %+v, want %+v", stats, want)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := healthyManager(t, k8sutils.NodeDetails{Name: "backend", IP: "127.0.0.1"})
+			ub := NewUDPBalancer(testListener(config.ModeUDP, udpEchoServer(t)), bm)
+			socket, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go ub.Serve(socket)
+
+			client, err := net.Dial("udp", socket.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := client.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			reply := make([]byte, 16)
+			if n, err := client.Read(reply); err != nil || string(reply[:n]) != "ping" {
+				t.Fatalf("Read() = %q, %v, want %q", reply[:n], err, "ping")
+			}
+			ub.flowsMutex.Lock()
+			flow := ub.flows[client.LocalAddr().String()]
+			ub.flowsMutex.Unlock()
+			if flow == nil || ub.ActiveConnections("127.0.0.1") != 1 {
+				t.Fatalf("no flow for client %s", client.LocalAddr())
+			}
+
+			tt.stop(ub)
+			ub.flowsMutex.Lock()
+			flows := len(ub.flows)
+			ub.flowsMutex.Unlock()
+			if flows != 0 {
+				t.Errorf("%d flows left, want none", flows)
+			}
+			if active := ub.ActiveConnections("127.0.0.1"); active != 0 {
+				t.Errorf("ActiveConnections() = %d, want 0", active)
+			}
+			if _, err := flow.backend.Write([]byte("late")); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("backend socket of the flow is still open: %v", err)
+			}
+			if _, err := socket.WriteTo([]byte("late"), client.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("listening socket is still open: %v", err)
+			}
+		})
+	}
+}
+
+func TestUDPBalancerFlowTimeout(t *testing.T) {
+	bm := healthyManager(t, k8sutils.NodeDetails{Name: "backend", IP: "127.0.0.1"})
+	listener := testListener(config.ModeUDP, udpEchoServer(t))
+	listener.FlowTimeout = config.Duration{Duration: 200 * time.Millisecond}
+	ub := NewUDPBalancer(listener, bm)
+	socket, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ub.Serve(socket)
+	defer ub.Stop()
+
+	client, err := net.Dial("udp", socket.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte("ping"))
+	if _, err := client.Read(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ub.ActiveConnections("127.0.0.1") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("flow of client %s did not time out", client.LocalAddr())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
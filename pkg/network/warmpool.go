@@ -0,0 +1,159 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// warmPoolInterval is how often warm pools are topped up and cleared of idle and unhealthy backends' connections
+const warmPoolInterval = time.Second
+
+// warmPool keeps pre-dialed connections to the healthy backends of a TCP listener, so client
+// connections skip the TCP handshake with backends that are far away
+type warmPool struct {
+	mutex   sync.Mutex
+	conns   map[string][]warmConn // Idle connections by backend address, oldest first
+	dialing map[string]bool       // Backend addresses being topped up
+	stop    context.CancelFunc    // Stops topping up, nil when the pool is closed
+}
+
+// warmConn is a pooled backend connection that has not carried any data yet
+type warmConn struct {
+	net.Conn
+	dialed time.Time
+}
+
+func newWarmPool() *warmPool {
+	return &warmPool{
+		conns:   make(map[string][]warmConn),
+		dialing: make(map[string]bool),
+	}
+}
+
+// start keeps the pool filled according to the current listener settings until close is called
+func (p *warmPool) start(settings func() listenerSettings) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stop != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, p.stop = context.WithCancel(context.Background())
+	go p.run(ctx, settings)
+}
+
+// close stops topping up the pool and closes every pooled connection
+func (p *warmPool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stop != nil {
+		p.stop()
+		p.stop = nil
+	}
+	for address, conns := range p.conns {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		delete(p.conns, address)
+	}
+}
+
+func (p *warmPool) run(ctx context.Context, settings func() listenerSettings) {
+	ticker := time.NewTicker(warmPoolInterval)
+	defer ticker.Stop()
+	for {
+		p.refill(ctx, settings())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refill closes the connections to backends that are no longer healthy, those idle for longer than
+// maxIdle or closed by the backend and those beyond the pool size, then dials the connections missing
+func (p *warmPool) refill(ctx context.Context, settings listenerSettings) {
+	size := settings.warmPool.Size
+	wanted := make(map[string]bool)
+	if size > 0 {
+		for _, backendIP := range settings.backendManager.HealthyBackends() {
+			wanted[settings.backendManager.BackendAddress(backendIP, settings.backendPort)] = true
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for address, conns := range p.conns {
+		var kept []warmConn
+		for i, conn := range conns {
+			if !wanted[address] || i < len(conns)-size || time.Since(conn.dialed) > settings.warmPool.MaxIdle.Duration || connCheck(conn.Conn) != nil {
+				conn.Close()
+				continue
+			}
+			kept = append(kept, conn)
+		}
+		if len(kept) == 0 {
+			delete(p.conns, address)
+		} else {
+			p.conns[address] = kept
+		}
+	}
+	for address := range wanted {
+		if missing := size - len(p.conns[address]); missing > 0 && !p.dialing[address] {
+			p.dialing[address] = true
+			go p.dial(ctx, address, missing, settings.dialTimeout)
+		}
+	}
+}
+
+// dial adds count new connections to a backend to the pool, stopping at the first failure
+func (p *warmPool) dial(ctx context.Context, address string, count int, timeout time.Duration) {
+	defer func() {
+		p.mutex.Lock()
+		delete(p.dialing, address)
+		p.mutex.Unlock()
+	}()
+	dialer := net.Dialer{Timeout: timeout}
+	for i := 0; i < count; i++ {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			// The health checks and client connections decide about the backend's health
+			log.Debugf("[TCPBalancer] Failed to pre-dial backend %s: %v", address, err)
+			return
+		}
+		p.mutex.Lock()
+		if ctx.Err() != nil {
+			p.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		p.conns[address] = append(p.conns[address], warmConn{Conn: conn, dialed: time.Now()})
+		p.mutex.Unlock()
+	}
+}
+
+// take removes the most recently dialed connection to a backend from the pool, skipping those the
+// backend has closed. It returns nil if there is none.
+func (p *warmPool) take(address string) net.Conn {
+	for {
+		p.mutex.Lock()
+		conns := p.conns[address]
+		if len(conns) == 0 {
+			p.mutex.Unlock()
+			return nil
+		}
+		conn := conns[len(conns)-1]
+		p.conns[address] = conns[:len(conns)-1]
+		p.mutex.Unlock()
+
+		if err := connCheck(conn.Conn); err != nil {
+			log.Debugf("[TCPBalancer] Discarding pooled connection to backend %s: %v", address, err)
+			conn.Close()
+			continue
+		}
+		return conn.Conn
+	}
+}
@@ -0,0 +1,214 @@
+package network
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/config"
+	"github.com/supporttools/GoKubeBalancer/pkg/k8sutils"
+)
+
+// tcpBackend is a TCP server on 127.0.0.1 that keeps every connection it accepts open
+type tcpBackend struct {
+	port  int
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func newTCPBackend(t *testing.T) *tcpBackend {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &tcpBackend{port: listener.Addr().(*net.TCPAddr).Port}
+	t.Cleanup(func() {
+		listener.Close()
+		b.closeAll()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.mutex.Lock()
+			b.conns = append(b.conns, conn)
+			b.mutex.Unlock()
+		}
+	}()
+	return b
+}
+
+// accepted returns the number of connections accepted once it reaches want or a second passed, as
+// the accept loop may lag behind the dials
+func (b *tcpBackend) accepted(want int) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mutex.Lock()
+		count := len(b.conns)
+		b.mutex.Unlock()
+		if count >= want || time.Now().After(deadline) {
+			return count
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (b *tcpBackend) closeAll() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+}
+
+// pooled returns the number of connections to address in the pool once no dial is in progress
+func pooled(t *testing.T, p *warmPool, address string) int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mutex.Lock()
+		dialing, count := p.dialing[address], len(p.conns[address])
+		p.mutex.Unlock()
+		if !dialing {
+			return count
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still dialing %s", address)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// warmPoolSettings returns the settings of a TCP listener keeping size connections to backendPort
+func warmPoolSettings(t *testing.T, backendPort, size int) listenerSettings {
+	t.Helper()
+	listener := testListener(config.ModeTCP, backendPort)
+	listener.WarmPool = config.WarmPoolConfig{Size: size, MaxIdle: config.Seconds(60)}
+	return newListenerSettings(listener, healthyManager(t, k8sutils.NodeDetails{Name: "backend", IP: "127.0.0.1"}), nil, TLS{})
+}
+
+func TestWarmPoolRefill(t *testing.T) {
+	backend := newTCPBackend(t)
+	settings := warmPoolSettings(t, backend.port, 2)
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(backend.port))
+	p := newWarmPool()
+	defer p.close()
+	ctx := context.Background()
+
+	p.refill(ctx, settings)
+	if got := pooled(t, p, address); got != 2 {
+		t.Fatalf("pooled connections = %d, want 2", got)
+	}
+	p.refill(ctx, settings)
+	if got, dialed := pooled(t, p, address), backend.accepted(2); got != 2 || dialed != 2 {
+		t.Errorf("full pool refilled to %d connections with %d dialed, want 2 and 2", got, dialed)
+	}
+
+	if conn := p.take(address); conn == nil {
+		t.Fatal("take() = nil, want a pooled connection")
+	} else {
+		conn.Close()
+	}
+	p.refill(ctx, settings)
+	if got, dialed := pooled(t, p, address), backend.accepted(3); got != 2 || dialed != 3 {
+		t.Errorf("after take() refilled to %d connections with %d dialed, want 2 and 3", got, dialed)
+	}
+
+	settings.warmPool.Size = 1
+	p.refill(ctx, settings)
+	if got := pooled(t, p, address); got != 1 {
+		t.Errorf("after shrinking the pool %d connections, want 1", got)
+	}
+
+	// Connections to a backend address that is no longer used are closed
+	settings.backendPort++
+	p.refill(ctx, settings)
+	if got := pooled(t, p, address); got != 0 {
+		t.Errorf("after changing the backend port %d connections, want 0", got)
+	}
+}
+
+func TestWarmPoolMaxIdle(t *testing.T) {
+	backend := newTCPBackend(t)
+	settings := warmPoolSettings(t, backend.port, 1)
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(backend.port))
+	p := newWarmPool()
+	defer p.close()
+	ctx := context.Background()
+
+	p.refill(ctx, settings)
+	if got := pooled(t, p, address); got != 1 {
+		t.Fatalf("pooled connections = %d, want 1", got)
+	}
+	p.mutex.Lock()
+	first := p.conns[address][0].Conn
+	p.conns[address][0].dialed = time.Now().Add(-2 * settings.warmPool.MaxIdle.Duration)
+	p.mutex.Unlock()
+
+	p.refill(ctx, settings)
+	if got, dialed := pooled(t, p, address), backend.accepted(2); got != 1 || dialed != 2 {
+		t.Fatalf("after expiry %d connections with %d dialed, want 1 and 2", got, dialed)
+	}
+	if conn := p.take(address); conn == first {
+		t.Errorf("take() returned the expired connection")
+	}
+}
+
+func TestConnectBackendStalePooledConnection(t *testing.T) {
+	tests := []struct {
+		name        string
+		backendUp   bool
+		wantErr     bool
+		wantEjected bool // The failure counts toward outlier detection
+	}{
+		{name: "new connection dialed", backendUp: true},
+		{name: "backend down", wantErr: true, wantEjected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backend *tcpBackend
+			port := closedPort(t)
+			if tt.backendUp {
+				backend = newTCPBackend(t)
+				port = backend.port
+			}
+			address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+			bm := healthyManager(t, k8sutils.NodeDetails{Name: "backend", IP: "127.0.0.1", Port: port})
+			bm.SetOutlierDetection(config.OutlierDetectionConfig{ConsecutiveErrors: 1, BaseEjectionTime: config.Seconds(30), MaxEjectionTime: config.Seconds(300), MaxEjectionPercent: 100})
+			tb := NewTCPBalancer(testListener(config.ModeTCP, 0), bm, nil, TLS{})
+
+			// The pool holds a connection whose peer is gone, which the pool's own check cannot tell
+			stale, peer := net.Pipe()
+			peer.Close()
+			tb.warmPool.conns[address] = []warmConn{{Conn: stale, dialed: time.Now()}}
+
+			_, backendAddr, conn, err := tb.connectBackend(tb.currentSettings(), "192.0.2.1:40000", "192.0.2.1", "", []byte("PROXY UNKNOWN\r\n"))
+			if tt.wantErr {
+				if err == nil {
+					conn.Close()
+					t.Fatal("connectBackend() succeeded without a backend")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("connectBackend() unexpected error: %v", err)
+				}
+				defer conn.Close()
+				if backendAddr != address || conn == stale {
+					t.Errorf("connected to %s with the stale connection %t, want a new connection to %s", backendAddr, conn == stale, address)
+				}
+				if got := backend.accepted(1); got != 1 {
+					t.Errorf("backend accepted %d connections, want 1", got)
+				}
+			}
+			if ejected := len(bm.HealthyBackends()) == 0; ejected != tt.wantEjected {
+				t.Errorf("backend ejected = %t, want %t", ejected, tt.wantEjected)
+			}
+		})
+	}
+}
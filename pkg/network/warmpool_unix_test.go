@@ -0,0 +1,62 @@
+//go:build unix
+
+package network
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWarmPoolClosedByBackend(t *testing.T) {
+	backend := newTCPBackend(t)
+	settings := warmPoolSettings(t, backend.port, 2)
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(backend.port))
+	p := newWarmPool()
+	defer p.close()
+
+	p.refill(context.Background(), settings)
+	if got := pooled(t, p, address); got != 2 {
+		t.Fatalf("pooled connections = %d, want 2", got)
+	}
+	backend.accepted(2)
+	backend.closeAll()
+	time.Sleep(100 * time.Millisecond) // Let the FINs arrive
+
+	if conn := p.take(address); conn != nil {
+		conn.Close()
+		t.Errorf("take() returned a connection the backend closed")
+	}
+	if got := pooled(t, p, address); got != 0 {
+		t.Errorf("%d connections left, want 0", got)
+	}
+}
+
+func TestWarmPoolRefillEvictsClosed(t *testing.T) {
+	backend := newTCPBackend(t)
+	settings := warmPoolSettings(t, backend.port, 1)
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(backend.port))
+	p := newWarmPool()
+	defer p.close()
+	ctx := context.Background()
+
+	p.refill(ctx, settings)
+	if got := pooled(t, p, address); got != 1 {
+		t.Fatalf("pooled connections = %d, want 1", got)
+	}
+	backend.accepted(1)
+	backend.closeAll()
+	time.Sleep(100 * time.Millisecond) // Let the FIN arrive
+
+	p.refill(ctx, settings)
+	if got, dialed := pooled(t, p, address), backend.accepted(2); got != 1 || dialed != 2 {
+		t.Errorf("after the backend closed its connection %d connections with %d dialed, want 1 and 2", got, dialed)
+	}
+	if conn := p.take(address); conn == nil {
+		t.Errorf("take() = nil, want the new connection")
+	} else {
+		conn.Close()
+	}
+}
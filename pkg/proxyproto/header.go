@@ -0,0 +1,143 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// TLV types defined by the PROXY protocol v2 specification; 0xE0 to 0xEF are free for custom use
+const (
+	TypeALPN      byte = 0x01
+	TypeAuthority byte = 0x02 // Host name the client asked for, e.g. the TLS SNI
+	TypeCRC32C    byte = 0x03
+	TypeNoop      byte = 0x04
+	TypeUniqueID  byte = 0x05
+	TypeSSL       byte = 0x20
+	TypeNetNS     byte = 0x30
+)
+
+// signatureV2 starts every version 2 header
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	commandLocal = 0x20 // Version 2, LOCAL: connection opened by the proxy itself, addresses are ignored
+	commandProxy = 0x21 // Version 2, PROXY: connection relayed on behalf of a client
+
+	familyUnspec = 0x00
+	familyTCP4   = 0x11
+	familyTCP6   = 0x21
+
+	maxLength = 0xFFFF // Largest address and TLV block a version 2 header can carry
+)
+
+// TLV is a type-length-value extension of a version 2 header
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header describes the original client connection to a backend
+type Header struct {
+	Version     int      // 1 for the text format, 2 for the binary format
+	Local       bool     // Connection opened by the proxy itself, such as a health check, rather than relayed
+	Source      net.Addr // Client address
+	Destination net.Addr // Address the client connected to
+	TLVs        []TLV    // Extensions, only sent in version 2
+}
+
+// Bytes encodes the header. Connections that are not TCP, or whose addresses belong to different
+// families, are sent as UNKNOWN in version 1 and UNSPEC in version 2.
+func (h *Header) Bytes() ([]byte, error) {
+	switch h.Version {
+	case 1:
+		return h.v1(), nil
+	case 2:
+		return h.v2()
+	}
+	return nil, fmt.Errorf("unsupported PROXY protocol version %d", h.Version)
+}
+
+// WriteTo writes the encoded header to w
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	data, err := h.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// addresses returns the source and destination as TCP addresses and whether they are IPv4, or nil if
+// the header cannot carry them
+func (h *Header) addresses() (*net.TCPAddr, *net.TCPAddr, bool) {
+	if h.Local {
+		return nil, nil, false
+	}
+	source, ok := h.Source.(*net.TCPAddr)
+	if !ok {
+		return nil, nil, false
+	}
+	destination, ok := h.Destination.(*net.TCPAddr)
+	if !ok {
+		return nil, nil, false
+	}
+	sourceV4 := source.IP.To4() != nil
+	if sourceV4 != (destination.IP.To4() != nil) {
+		return nil, nil, false
+	}
+	return source, destination, sourceV4
+}
+
+func (h *Header) v1() []byte {
+	source, destination, ipv4 := h.addresses()
+	if source == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	protocol := "TCP6"
+	if ipv4 {
+		protocol = "TCP4"
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", protocol, source.IP, destination.IP, source.Port, destination.Port))
+}
+
+func (h *Header) v2() ([]byte, error) {
+	var body bytes.Buffer
+	command := byte(commandProxy)
+	family := byte(familyUnspec)
+	if h.Local {
+		command = commandLocal
+	}
+	if source, destination, ipv4 := h.addresses(); source != nil {
+		if ipv4 {
+			family = familyTCP4
+			body.Write(source.IP.To4())
+			body.Write(destination.IP.To4())
+		} else {
+			family = familyTCP6
+			body.Write(source.IP.To16())
+			body.Write(destination.IP.To16())
+		}
+		binary.Write(&body, binary.BigEndian, uint16(source.Port))
+		binary.Write(&body, binary.BigEndian, uint16(destination.Port))
+	}
+	for _, tlv := range h.TLVs {
+		if len(tlv.Value) > maxLength {
+			return nil, fmt.Errorf("TLV 0x%02x is too long: %d bytes", tlv.Type, len(tlv.Value))
+		}
+		body.WriteByte(tlv.Type)
+		binary.Write(&body, binary.BigEndian, uint16(len(tlv.Value)))
+		body.Write(tlv.Value)
+	}
+	if body.Len() > maxLength {
+		return nil, fmt.Errorf("PROXY protocol header is too long: %d bytes", body.Len())
+	}
+
+	header := make([]byte, 0, len(signatureV2)+4+body.Len())
+	header = append(header, signatureV2...)
+	header = append(header, command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(body.Len()))
+	return append(header, body.Bytes()...), nil
+}
@@ -0,0 +1,182 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tcpAddr(s string) *net.TCPAddr {
+	addr, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+// v2Header builds an expected version 2 header from its command, family and body
+func v2Header(command, family byte, body ...[]byte) []byte {
+	joined := bytes.Join(body, nil)
+	header := append([]byte{}, signatureV2...)
+	header = append(header, command, family, byte(len(joined)>>8), byte(len(joined)))
+	return append(header, joined...)
+}
+
+func TestHeaderBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  Header
+		want    []byte
+		wantErr string
+	}{
+		{
+			name:   "v1 TCP4",
+			header: Header{Version: 1, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443")},
+			want:   []byte("PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n"),
+		},
+		{
+			name:   "v1 TCP6",
+			header: Header{Version: 1, Source: tcpAddr("[2001:db8::1]:56324"), Destination: tcpAddr("[2001:db8::2]:443")},
+			want:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+		},
+		{
+			name:   "v1 mixed families",
+			header: Header{Version: 1, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("[2001:db8::2]:443")},
+			want:   []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:   "v1 UDP",
+			header: Header{Version: 1, Source: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}, Destination: tcpAddr("198.51.100.2:53")},
+			want:   []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:   "v1 local",
+			header: Header{Version: 1, Local: true, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443")},
+			want:   []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:   "v1 ignores TLVs",
+			header: Header{Version: 1, Source: tcpAddr("192.0.2.1:1"), Destination: tcpAddr("198.51.100.2:2"), TLVs: []TLV{{Type: 0xE0, Value: []byte("x")}}},
+			want:   []byte("PROXY TCP4 192.0.2.1 198.51.100.2 1 2\r\n"),
+		},
+		{
+			name:   "v2 TCP4",
+			header: Header{Version: 2, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443")},
+			want:   v2Header(commandProxy, familyTCP4, []byte{192, 0, 2, 1, 198, 51, 100, 2, 0xDC, 0x04, 0x01, 0xBB}),
+		},
+		{
+			name:   "v2 TCP6",
+			header: Header{Version: 2, Source: tcpAddr("[2001:db8::1]:1"), Destination: tcpAddr("[2001:db8::2]:2")},
+			want: v2Header(commandProxy, familyTCP6,
+				net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), []byte{0, 1, 0, 2}),
+		},
+		{
+			name: "v2 TLVs",
+			header: Header{Version: 2, Source: tcpAddr("192.0.2.1:1"), Destination: tcpAddr("198.51.100.2:2"), TLVs: []TLV{
+				{Type: TypeAuthority, Value: []byte("example.com")},
+				{Type: 0xE0, Value: []byte("edge-1")},
+				{Type: TypeNoop},
+			}},
+			want: v2Header(commandProxy, familyTCP4,
+				[]byte{192, 0, 2, 1, 198, 51, 100, 2, 0, 1, 0, 2},
+				[]byte{TypeAuthority, 0, 11}, []byte("example.com"),
+				[]byte{0xE0, 0, 6}, []byte("edge-1"),
+				[]byte{TypeNoop, 0, 0}),
+		},
+		{
+			name:   "v2 local",
+			header: Header{Version: 2, Local: true, Source: tcpAddr("192.0.2.1:1"), Destination: tcpAddr("198.51.100.2:2")},
+			want:   v2Header(commandLocal, familyUnspec),
+		},
+		{
+			name:   "v2 local with TLVs",
+			header: Header{Version: 2, Local: true, TLVs: []TLV{{Type: 0xE0, Value: []byte("hc")}}},
+			want:   v2Header(commandLocal, familyUnspec, []byte{0xE0, 0, 2}, []byte("hc")),
+		},
+		{
+			name:   "v2 mixed families",
+			header: Header{Version: 2, Source: tcpAddr("192.0.2.1:1"), Destination: tcpAddr("[2001:db8::2]:2")},
+			want:   v2Header(commandProxy, familyUnspec),
+		},
+		{
+			name:   "v2 missing addresses",
+			header: Header{Version: 2},
+			want:   v2Header(commandProxy, familyUnspec),
+		},
+		{
+			name:    "v2 TLV too long",
+			header:  Header{Version: 2, TLVs: []TLV{{Type: 0xE0, Value: make([]byte, maxLength+1)}}},
+			wantErr: "TLV 0xe0 is too long",
+		},
+		{
+			name: "v2 header too long",
+			header: Header{Version: 2, TLVs: []TLV{
+				{Type: 0xE0, Value: make([]byte, maxLength/2)},
+				{Type: 0xE1, Value: make([]byte, maxLength/2)},
+			}},
+			wantErr: "header is too long",
+		},
+		{
+			name:    "unsupported version",
+			header:  Header{Version: 3},
+			wantErr: "unsupported PROXY protocol version 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.header.Bytes()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Bytes() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Bytes() unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+
+			var buffer bytes.Buffer
+			n, err := tt.header.WriteTo(&buffer)
+			if err != nil || n != int64(len(tt.want)) || !bytes.Equal(buffer.Bytes(), tt.want) {
+				t.Errorf("WriteTo() = %d, %v, wrote %q, want %q", n, err, buffer.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	tests := []Header{
+		{Version: 1, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443")},
+		{Version: 1, Source: tcpAddr("[2001:db8::1]:56324"), Destination: tcpAddr("[2001:db8::2]:443")},
+		{Version: 2, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443"), TLVs: []TLV{{Type: 0xE0, Value: []byte("edge-1")}}},
+		{Version: 2, Source: tcpAddr("[2001:db8::1]:56324"), Destination: tcpAddr("[2001:db8::2]:443")},
+		{Version: 2, Local: true, TLVs: []TLV{{Type: TypeAuthority, Value: []byte("example.com")}}},
+	}
+	for _, want := range tests {
+		data, err := want.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes() unexpected error: %v", err)
+		}
+		got, err := Read(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("Read(%q) unexpected error: %v", data, err)
+		}
+		if got.Version != want.Version || got.Local != want.Local || !reflect.DeepEqual(got.TLVs, want.TLVs) ||
+			addrString(got.Source) != addrString(want.Source) || addrString(got.Destination) != addrString(want.Destination) {
+			t.Errorf("Read(%q) = %+v, want %+v", data, got, want)
+		}
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
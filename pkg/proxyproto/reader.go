@@ -0,0 +1,193 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLineV1 is the longest version 1 header allowed by the specification, including the CRLF
+const maxLineV1 = 107
+
+// readBufferSize holds a complete version 1 header; longer version 2 headers are read through it
+const readBufferSize = 256
+
+// ErrNoHeader is returned when a connection does not start with a PROXY protocol header
+var ErrNoHeader = errors.New("connection does not start with a PROXY protocol header")
+
+// Read parses a version 1 or version 2 header from r. Addresses of connections other than TCP are
+// left out of the returned header.
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readV1(r)
+	case signatureV2[0]:
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > maxLineV1 {
+		return nil, fmt.Errorf("PROXY protocol v1 header is longer than %d bytes", maxLineV1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY protocol v1 header does not end with CRLF")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" {
+		return nil, ErrNoHeader
+	}
+	header := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY protocol v1 header %q", line)
+	}
+	if header.Source, err = parseV1Address(fields[2], fields[4], fields[1] == "TCP4"); err != nil {
+		return nil, fmt.Errorf("PROXY protocol v1 source: %w", err)
+	}
+	if header.Destination, err = parseV1Address(fields[3], fields[5], fields[1] == "TCP4"); err != nil {
+		return nil, fmt.Errorf("PROXY protocol v1 destination: %w", err)
+	}
+	return header, nil
+}
+
+func parseV1Address(host, port string, ipv4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != ipv4 {
+		return nil, fmt.Errorf("invalid address %q", host)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(portNumber)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	prefix, err := r.Peek(len(signatureV2) + 4)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:len(signatureV2)], signatureV2) {
+		return nil, ErrNoHeader
+	}
+	command := prefix[len(signatureV2)]
+	family := prefix[len(signatureV2)+1]
+	length := binary.BigEndian.Uint16(prefix[len(signatureV2)+2:])
+	if command != commandLocal && command != commandProxy {
+		return nil, fmt.Errorf("unsupported PROXY protocol v2 version or command 0x%02x", command)
+	}
+	if _, err := r.Discard(len(prefix)); err != nil {
+		return nil, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("read PROXY protocol v2 header: %w", err)
+	}
+
+	header := &Header{Version: 2, Local: command == commandLocal}
+	var addressLength int
+	switch family {
+	case familyTCP4:
+		addressLength = 12
+	case familyTCP6:
+		addressLength = 36
+	case familyUnspec:
+	default:
+		// UDP and UNIX socket addresses are skipped through the TLV offset below
+		addressLength = -1
+	}
+	if addressLength > len(body) {
+		return nil, fmt.Errorf("PROXY protocol v2 header too short for its addresses")
+	}
+	if addressLength > 0 {
+		ipLength := (addressLength - 4) / 2
+		header.Source = &net.TCPAddr{
+			IP:   net.IP(body[:ipLength]),
+			Port: int(binary.BigEndian.Uint16(body[2*ipLength:])),
+		}
+		header.Destination = &net.TCPAddr{
+			IP:   net.IP(body[ipLength : 2*ipLength]),
+			Port: int(binary.BigEndian.Uint16(body[2*ipLength+2:])),
+		}
+	}
+	if addressLength < 0 {
+		// Without knowing the address block the TLVs cannot be located
+		return header, nil
+	}
+
+	tlvs := body[addressLength:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, fmt.Errorf("truncated PROXY protocol v2 TLV")
+		}
+		valueLength := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+valueLength {
+			return nil, fmt.Errorf("truncated PROXY protocol v2 TLV 0x%02x", tlvs[0])
+		}
+		header.TLVs = append(header.TLVs, TLV{Type: tlvs[0], Value: tlvs[3 : 3+valueLength]})
+		tlvs = tlvs[3+valueLength:]
+	}
+	return header, nil
+}
+
+// Conn is a client connection whose PROXY protocol header has been read. RemoteAddr and LocalAddr
+// report the addresses from the header when it carries them.
+type Conn struct {
+	net.Conn
+	Header *Header
+	reader *bufio.Reader
+}
+
+// NewConn reads the PROXY protocol header that must start conn, waiting at most timeout for it
+func NewConn(conn net.Conn, timeout time.Duration) (*Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	reader := bufio.NewReaderSize(conn, readBufferSize)
+	header, err := Read(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, Header: header, reader: reader}, nil
+}
+
+// Read returns the data following the header, starting with what was buffered while reading it
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the original client address
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.Header.Source != nil && !c.Header.Local {
+		return c.Header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client originally connected to
+func (c *Conn) LocalAddr() net.Addr {
+	if c.Header.Destination != nil && !c.Header.Local {
+		return c.Header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,204 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	tcp4Body := []byte{192, 0, 2, 1, 198, 51, 100, 2, 0xDC, 0x04, 0x01, 0xBB}
+	tests := []struct {
+		name      string
+		input     []byte
+		want      *Header
+		wantErr   string
+		wantErrIs error
+	}{
+		{
+			name:  "v1 TCP4",
+			input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n"),
+			want:  &Header{Version: 1, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443")},
+		},
+		{
+			name:  "v1 TCP6",
+			input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			want:  &Header{Version: 1, Source: tcpAddr("[2001:db8::1]:56324"), Destination: tcpAddr("[2001:db8::2]:443")},
+		},
+		{name: "v1 UNKNOWN", input: []byte("PROXY UNKNOWN\r\n"), want: &Header{Version: 1}},
+		{name: "v1 UNKNOWN with addresses", input: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"), want: &Header{Version: 1}},
+		{name: "v1 missing CR", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 1 2\n"), wantErr: "does not end with CRLF"},
+		{name: "v1 too long", input: []byte("PROXY UNKNOWN " + strings.Repeat("x", maxLineV1) + "\r\n"), wantErr: "longer than 107 bytes"},
+		{name: "v1 line without end", input: []byte("PROXY TCP4 192.0.2.1"), wantErrIs: io.EOF},
+		{name: "v1 too few fields", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 1\r\n"), wantErr: "malformed"},
+		{name: "v1 too many fields", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 1 2 3\r\n"), wantErr: "malformed"},
+		{name: "v1 double space", input: []byte("PROXY TCP4  192.0.2.1 198.51.100.2 1 2\r\n"), wantErr: "malformed"},
+		{name: "v1 unknown protocol", input: []byte("PROXY UDP4 192.0.2.1 198.51.100.2 1 2\r\n"), wantErr: "malformed"},
+		{name: "v1 family mismatch", input: []byte("PROXY TCP4 2001:db8::1 198.51.100.2 1 2\r\n"), wantErr: "source: invalid address"},
+		{name: "v1 invalid destination", input: []byte("PROXY TCP4 192.0.2.1 example.com 1 2\r\n"), wantErr: "destination: invalid address"},
+		{name: "v1 port out of range", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 65536 2\r\n"), wantErr: "invalid port"},
+		{name: "v1 negative port", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 1 -2\r\n"), wantErr: "invalid port"},
+		{name: "not PROXY", input: []byte("POST / HTTP/1.1\r\n"), wantErrIs: ErrNoHeader},
+		{name: "no header", input: []byte("GET / HTTP/1.1\r\n"), wantErrIs: ErrNoHeader},
+		{name: "empty", input: nil, wantErrIs: io.EOF},
+		{
+			name:  "v2 TCP4",
+			input: v2Header(commandProxy, familyTCP4, tcp4Body),
+			want:  &Header{Version: 2, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443")},
+		},
+		{
+			name:  "v2 TCP6",
+			input: v2Header(commandProxy, familyTCP6, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), []byte{0, 1, 0, 2}),
+			want:  &Header{Version: 2, Source: tcpAddr("[2001:db8::1]:1"), Destination: tcpAddr("[2001:db8::2]:2")},
+		},
+		{
+			name:  "v2 TLVs",
+			input: v2Header(commandProxy, familyTCP4, tcp4Body, []byte{TypeAuthority, 0, 3}, []byte("a.b"), []byte{TypeNoop, 0, 0}, []byte{0xE0, 0, 1, 'x'}),
+			want: &Header{Version: 2, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443"), TLVs: []TLV{
+				{Type: TypeAuthority, Value: []byte("a.b")},
+				{Type: TypeNoop, Value: []byte{}},
+				{Type: 0xE0, Value: []byte("x")},
+			}},
+		},
+		{name: "v2 local", input: v2Header(commandLocal, familyUnspec), want: &Header{Version: 2, Local: true}},
+		{
+			name:  "v2 local with addresses",
+			input: v2Header(commandLocal, familyTCP4, tcp4Body),
+			want:  &Header{Version: 2, Local: true, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443")},
+		},
+		{
+			name:  "v2 unspec with TLVs",
+			input: v2Header(commandProxy, familyUnspec, []byte{0xE0, 0, 2}, []byte("hc")),
+			want:  &Header{Version: 2, TLVs: []TLV{{Type: 0xE0, Value: []byte("hc")}}},
+		},
+		{
+			name:  "v2 UDP skips addresses and TLVs",
+			input: v2Header(commandProxy, 0x12, tcp4Body, []byte{0xE0, 0, 2}, []byte("hc")),
+			want:  &Header{Version: 2},
+		},
+		{name: "v2 bad signature", input: append([]byte("\r\n\r\n\x00\r\nQUIX\n"), commandProxy, familyUnspec, 0, 0), wantErrIs: ErrNoHeader},
+		{name: "v2 version 1", input: v2Header(0x11, familyUnspec), wantErr: "unsupported PROXY protocol v2 version or command 0x11"},
+		{name: "v2 unknown command", input: v2Header(0x22, familyUnspec), wantErr: "unsupported PROXY protocol v2 version or command 0x22"},
+		{name: "v2 truncated prefix", input: append(append([]byte{}, signatureV2...), commandProxy), wantErrIs: io.EOF},
+		{name: "v2 truncated body", input: v2Header(commandProxy, familyTCP4, tcp4Body)[:len(signatureV2)+4+6], wantErrIs: io.ErrUnexpectedEOF},
+		{name: "v2 body too short for addresses", input: v2Header(commandProxy, familyTCP6, tcp4Body), wantErr: "too short for its addresses"},
+		{name: "v2 truncated TLV header", input: v2Header(commandProxy, familyTCP4, tcp4Body, []byte{0xE0, 0}), wantErr: "truncated PROXY protocol v2 TLV"},
+		{name: "v2 truncated TLV value", input: v2Header(commandProxy, familyTCP4, tcp4Body, []byte{0xE0, 0, 5, 'a', 'b'}), wantErr: "truncated PROXY protocol v2 TLV 0xe0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := append(append([]byte{}, tt.input...), "payload"...)
+			if tt.want == nil {
+				// Malformed headers are checked without trailing data, which could complete them
+				input = tt.input
+			}
+			reader := bufio.NewReaderSize(bytes.NewReader(input), readBufferSize)
+			got, err := Read(reader)
+			if tt.wantErr != "" || tt.wantErrIs != nil {
+				if err == nil {
+					t.Fatalf("Read(%q) = %+v, want an error", tt.input, got)
+				}
+				if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+					t.Fatalf("Read(%q) error = %v, want %v", tt.input, err, tt.wantErrIs)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Read(%q) error = %v, want error containing %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read(%q) unexpected error: %v", tt.input, err)
+			}
+			if got.Version != tt.want.Version || got.Local != tt.want.Local || !reflect.DeepEqual(got.TLVs, tt.want.TLVs) ||
+				addrString(got.Source) != addrString(tt.want.Source) || addrString(got.Destination) != addrString(tt.want.Destination) {
+				t.Errorf("Read(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+			if rest, _ := io.ReadAll(reader); string(rest) != "payload" {
+				t.Errorf("data after the header = %q, want %q", rest, "payload")
+			}
+		})
+	}
+}
+
+// pipeConn is one end of a net.Pipe with TCP addresses, like an accepted connection
+type pipeConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestNewConn(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     Header
+		wantRemote string
+		wantLocal  string
+	}{
+		{
+			name:       "proxied",
+			header:     Header{Version: 2, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("198.51.100.2:443")},
+			wantRemote: "192.0.2.1:56324",
+			wantLocal:  "198.51.100.2:443",
+		},
+		{
+			name:       "local",
+			header:     Header{Version: 2, Local: true},
+			wantRemote: "10.0.0.1:40000",
+			wantLocal:  "10.0.0.2:443",
+		},
+		{
+			name:       "unknown",
+			header:     Header{Version: 1},
+			wantRemote: "10.0.0.1:40000",
+			wantLocal:  "10.0.0.2:443",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			data, err := tt.header.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			go client.Write(append(data, "hello"...))
+
+			conn, err := NewConn(&pipeConn{Conn: server, local: tcpAddr("10.0.0.2:443"), remote: tcpAddr("10.0.0.1:40000")}, time.Second)
+			if err != nil {
+				t.Fatalf("NewConn() unexpected error: %v", err)
+			}
+			defer conn.Close()
+			if got := conn.RemoteAddr().String(); got != tt.wantRemote {
+				t.Errorf("RemoteAddr() = %s, want %s", got, tt.wantRemote)
+			}
+			if got := conn.LocalAddr().String(); got != tt.wantLocal {
+				t.Errorf("LocalAddr() = %s, want %s", got, tt.wantLocal)
+			}
+			payload := make([]byte, 5)
+			if _, err := io.ReadFull(conn, payload); err != nil || string(payload) != "hello" {
+				t.Errorf("Read() = %q, %v, want %q", payload, err, "hello")
+			}
+		})
+	}
+}
+
+func TestNewConnTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go client.Write([]byte("PROXY TCP4"))
+
+	_, err := NewConn(server, 50*time.Millisecond)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("NewConn() error = %v, want a timeout", err)
+	}
+}
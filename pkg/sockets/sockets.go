@@ -0,0 +1,302 @@
+package sockets
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/supporttools/GoKubeBalancer/pkg/logging"
+)
+
+var log = logging.SetupLogging()
+
+// Environment variables used to pass listening sockets to a process, compatible with systemd socket activation
+const (
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+	envUpgrade       = "GOKUBEBALANCER_UPGRADE"  // Set by Upgrade, which cannot know the child's PID for LISTEN_PID
+	envReadyFD       = "GOKUBEBALANCER_READY_FD" // Pipe the child writes to once it accepts connections
+	listenFDsStart   = 3                         // First passed file descriptor, after stdin, stdout and stderr
+)
+
+var (
+	mutex     sync.Mutex
+	inherited []namedSocket       // Passed sockets not adopted yet
+	active    = map[socket]bool{} // Sockets opened through Listen or ListenPacket and not closed
+	readyFile *os.File            // Readiness pipe of an upgrade, nil otherwise
+)
+
+// namedSocket is an inherited stream or datagram socket
+type namedSocket struct {
+	name       string
+	listener   net.Listener   // Nil for datagram sockets
+	packetConn net.PacketConn // Nil for stream sockets
+}
+
+func (s namedSocket) addr() net.Addr {
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
+	return s.packetConn.LocalAddr()
+}
+
+func (s namedSocket) close() error {
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return s.packetConn.Close()
+}
+
+// socket is a socket returned by Listen or ListenPacket; it is handed to the new process on Upgrade until it is closed
+type socket interface {
+	socketName() string
+	file() (*os.File, error)
+}
+
+// trackedListener is a stream socket returned by Listen
+type trackedListener struct {
+	net.Listener
+	name string
+}
+
+func (l *trackedListener) Close() error {
+	mutex.Lock()
+	delete(active, l)
+	mutex.Unlock()
+	return l.Listener.Close()
+}
+
+func (l *trackedListener) socketName() string { return l.name }
+
+func (l *trackedListener) file() (*os.File, error) { return fileOf(l.Listener) }
+
+// trackedPacketConn is a datagram socket returned by ListenPacket
+type trackedPacketConn struct {
+	net.PacketConn
+	name string
+}
+
+func (c *trackedPacketConn) Close() error {
+	mutex.Lock()
+	delete(active, c)
+	mutex.Unlock()
+	return c.PacketConn.Close()
+}
+
+func (c *trackedPacketConn) socketName() string { return c.name }
+
+func (c *trackedPacketConn) file() (*os.File, error) { return fileOf(c.PacketConn) }
+
+// fileOf duplicates the descriptor of a socket
+func fileOf(s any) (*os.File, error) {
+	filer, ok := s.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("socket cannot be passed on")
+	}
+	return filer.File()
+}
+
+// Init adopts the listening sockets passed by systemd socket activation or by a process upgrading to
+// this one. It must be called once at startup, before Listen.
+func Init() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	defer os.Unsetenv(envListenPID)
+	defer os.Unsetenv(envListenFDs)
+	defer os.Unsetenv(envListenFDNames)
+	defer os.Unsetenv(envUpgrade)
+	defer os.Unsetenv(envReadyFD)
+
+	if fd := os.Getenv(envReadyFD); fd != "" {
+		readyFD, err := strconv.Atoi(fd)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", envReadyFD, fd)
+		}
+		readyFile = os.NewFile(uintptr(readyFD), "ready")
+	}
+
+	count := os.Getenv(envListenFDs)
+	if count == "" {
+		return nil
+	}
+	if os.Getenv(envUpgrade) == "" && os.Getenv(envListenPID) != strconv.Itoa(os.Getpid()) {
+		return nil // Meant for another process
+	}
+	fds, err := strconv.Atoi(count)
+	if err != nil || fds < 0 {
+		return fmt.Errorf("invalid %s %q", envListenFDs, count)
+	}
+	names := strings.Split(os.Getenv(envListenFDNames), ":")
+
+	for i := 0; i < fds; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		inheritedSocket, err := adopt(file, name)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("adopt inherited socket %d (%s): %w", listenFDsStart+i, name, err)
+		}
+		log.Infof("[Sockets] Inherited socket %s listening on %s/%s", name, inheritedSocket.addr().Network(), inheritedSocket.addr())
+		inherited = append(inherited, inheritedSocket)
+	}
+	return nil
+}
+
+// adopt duplicates an inherited stream or datagram socket with close-on-exec set
+func adopt(file *os.File, name string) (namedSocket, error) {
+	listener, err := net.FileListener(file)
+	if err == nil {
+		return namedSocket{name: name, listener: listener}, nil
+	}
+	packetConn, packetErr := net.FilePacketConn(file)
+	if packetErr != nil {
+		return namedSocket{}, err
+	}
+	return namedSocket{name: name, packetConn: packetConn}, nil
+}
+
+// Listen returns an inherited stream socket matching the name or address, or opens a new TCP socket
+func Listen(name, address string) (net.Listener, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	listener := takeInheritedLocked(name, address, true).listener
+	if listener != nil {
+		log.Infof("[Sockets] Using inherited socket on %s for %s", listener.Addr(), name)
+	} else {
+		var err error
+		listener, err = net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tracked := &trackedListener{Listener: listener, name: name}
+	active[tracked] = true
+	return tracked, nil
+}
+
+// ListenPacket returns an inherited datagram socket matching the name or address, or opens a new UDP socket
+func ListenPacket(name, address string) (net.PacketConn, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	packetConn := takeInheritedLocked(name, address, false).packetConn
+	if packetConn != nil {
+		log.Infof("[Sockets] Using inherited socket on udp/%s for %s", packetConn.LocalAddr(), name)
+	} else {
+		var err error
+		packetConn, err = net.ListenPacket("udp", address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tracked := &trackedPacketConn{PacketConn: packetConn, name: name}
+	active[tracked] = true
+	return tracked, nil
+}
+
+// takeInheritedLocked removes and returns the inherited stream or datagram socket with the given name
+// or, failing that, the given address; callers must hold mutex
+func takeInheritedLocked(name, address string, stream bool) namedSocket {
+	match := -1
+	for i, candidate := range inherited {
+		if (candidate.listener != nil) != stream {
+			continue
+		}
+		if candidate.name != "" && candidate.name == name {
+			match = i
+			break
+		}
+		if match < 0 && sameAddress(candidate.addr().String(), address) {
+			match = i
+		}
+	}
+	if match < 0 {
+		return namedSocket{}
+	}
+	taken := inherited[match]
+	inherited = append(inherited[:match], inherited[match+1:]...)
+	return taken
+}
+
+// sameAddress compares two host:port addresses, treating every unspecified host as equal
+func sameAddress(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	unspecified := func(host string) bool {
+		ip := net.ParseIP(host)
+		return host == "" || (ip != nil && ip.IsUnspecified())
+	}
+	if unspecified(hostA) || unspecified(hostB) {
+		return unspecified(hostA) && unspecified(hostB)
+	}
+	return net.ParseIP(hostA).Equal(net.ParseIP(hostB)) || hostA == hostB
+}
+
+// CloseInherited closes the inherited sockets that no listener adopted
+func CloseInherited() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, unused := range inherited {
+		log.Warnf("[Sockets] Closing unused inherited socket %s on %s/%s", unused.name, unused.addr().Network(), unused.addr())
+		unused.close()
+	}
+	inherited = nil
+}
+
+// NotifyReady tells the process that started this one for an upgrade, and systemd, that this process
+// accepts connections and is now the main process of the service
+func NotifyReady() {
+	mutex.Lock()
+	if readyFile != nil {
+		if _, err := readyFile.Write([]byte{1}); err != nil {
+			log.Warnf("[Sockets] Failed to report readiness to the previous process: %v", err)
+		}
+		readyFile.Close()
+		readyFile = nil
+	}
+	mutex.Unlock()
+
+	if err := sdNotify(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid())); err != nil {
+		log.Warnf("[Sockets] Failed to notify systemd: %v", err)
+	}
+}
+
+// NotifyStatus tells systemd what the service is doing, shown by systemctl status while it starts
+func NotifyStatus(status string) {
+	if err := sdNotify("STATUS=" + status); err != nil {
+		log.Warnf("[Sockets] Failed to notify systemd: %v", err)
+	}
+}
+
+// NotifyStopping tells systemd that the service is shutting down
+func NotifyStopping() {
+	if err := sdNotify("STOPPING=1"); err != nil {
+		log.Warnf("[Sockets] Failed to notify systemd: %v", err)
+	}
+}
+
+// sdNotify sends a state to systemd when the service runs with Type=notify
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
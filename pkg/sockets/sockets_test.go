@@ -0,0 +1,197 @@
+package sockets
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperEnv makes the test binary act as a process started with inherited sockets rather than run the tests
+const helperEnv = "SOCKETS_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(helperEnv) {
+	case "serve":
+		os.Exit(serveInherited())
+	case "fail":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Minute)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// serveInherited adopts the inherited socket named web, reports ready and answers one connection with
+// its PID. Had the socket not been inherited, Listen would open a new one nobody connects to.
+func serveInherited() int {
+	if err := Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	listener, err := Listen("web", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	NotifyReady()
+	conn, err := listener.Accept()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%d", os.Getpid())
+	return 0
+}
+
+// answeringPID connects to address and returns the PID the helper process answers with
+func answeringPID(t *testing.T, address string) int {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no answer on %s: %v", address, err)
+	}
+	pid, err := strconv.Atoi(string(buf[:n]))
+	if err != nil {
+		t.Fatalf("unexpected answer %q", buf[:n])
+	}
+	return pid
+}
+
+func TestInitEnvironment(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "nothing passed", env: map[string]string{}},
+		{name: "meant for another process", env: map[string]string{envListenFDs: "1", envListenPID: "1"}},
+		{name: "invalid count", env: map[string]string{envListenFDs: "many", envListenPID: strconv.Itoa(os.Getpid())}, wantErr: "invalid LISTEN_FDS"},
+		{name: "negative count", env: map[string]string{envListenFDs: "-1", envUpgrade: "1"}, wantErr: "invalid LISTEN_FDS"},
+		{name: "invalid ready pipe", env: map[string]string{envReadyFD: "pipe"}, wantErr: "invalid GOKUBEBALANCER_READY_FD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{envListenPID, envListenFDs, envListenFDNames, envUpgrade, envReadyFD} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			err := Init()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Init() unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Init() error = %v, want error containing %q", err, tt.wantErr)
+			}
+			if len(inherited) != 0 {
+				t.Errorf("Init() adopted %d sockets", len(inherited))
+			}
+			// The variables are not passed on to processes started later
+			for name := range tt.env {
+				if value, set := os.LookupEnv(name); set {
+					t.Errorf("%s left set to %q", name, value)
+				}
+			}
+		})
+	}
+}
+
+func TestListenInherited(t *testing.T) {
+	listen := func(network string) namedSocket {
+		t.Helper()
+		if network == "udp" {
+			packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { packetConn.Close() })
+			return namedSocket{packetConn: packetConn}
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		return namedSocket{listener: listener}
+	}
+	named, unnamed, datagram := listen("tcp"), listen("tcp"), listen("udp")
+	named.name = "web"
+	inherited = []namedSocket{named, unnamed, datagram}
+	defer func() { inherited = nil }()
+
+	tests := []struct {
+		name     string
+		listener string
+		address  string
+		want     net.Addr // Nil for a new socket
+	}{
+		{name: "by name", listener: "web", address: "127.0.0.1:0", want: named.addr()},
+		{name: "by address", listener: "api", address: unnamed.addr().String(), want: unnamed.addr()},
+		{name: "stream only", listener: "dns", address: datagram.addr().String()},
+		{name: "already taken", listener: "web", address: "127.0.0.1:0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := Listen(tt.listener, tt.address)
+			if tt.want == nil && err != nil {
+				// The address of the datagram socket may be taken for TCP; either way it was not adopted
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			if tt.want != nil && listener.Addr().String() != tt.want.String() {
+				t.Errorf("Listen() on %s, want the inherited socket on %s", listener.Addr(), tt.want)
+			}
+			if tt.want == nil && (listener.Addr().String() == named.addr().String() || listener.Addr().String() == unnamed.addr().String()) {
+				t.Errorf("Listen() adopted the inherited socket on %s", listener.Addr())
+			}
+		})
+	}
+
+	packetConn, err := ListenPacket("dns", datagram.addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packetConn.Close()
+	if packetConn.LocalAddr().String() != datagram.addr().String() {
+		t.Errorf("ListenPacket() on %s, want the inherited socket on %s", packetConn.LocalAddr(), datagram.addr())
+	}
+	if len(inherited) != 0 {
+		t.Errorf("%d inherited sockets left, want 0", len(inherited))
+	}
+}
+
+func TestSameAddress(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "127.0.0.1:80", b: "127.0.0.1:80", want: true},
+		{a: "127.0.0.1:80", b: "127.0.0.1:81"},
+		{a: "[::]:80", b: ":80", want: true},
+		{a: "0.0.0.0:80", b: ":80", want: true},
+		{a: "0.0.0.0:80", b: "127.0.0.1:80"},
+		{a: "[::ffff:127.0.0.1]:80", b: "127.0.0.1:80", want: true},
+		{a: "localhost:80", b: "localhost:80", want: true},
+		{a: "127.0.0.1", b: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := sameAddress(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameAddress(%q, %q) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
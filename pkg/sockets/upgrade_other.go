@@ -0,0 +1,17 @@
+//go:build !unix
+
+package sockets
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// UpgradeSignals are the signals that request a binary upgrade; upgrades need Unix
+var UpgradeSignals []os.Signal
+
+// Upgrade is not supported on this platform
+func Upgrade(timeout time.Duration) (*os.Process, error) {
+	return nil, errors.New("binary upgrades are only supported on Unix")
+}
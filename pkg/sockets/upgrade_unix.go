@@ -0,0 +1,97 @@
+//go:build unix
+
+package sockets
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UpgradeSignals are the signals that request a binary upgrade
+var UpgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// Upgrade starts a new instance of the running executable, passing it every socket opened through
+// Listen or ListenPacket, and waits until it reports that it accepts connections. The caller should
+// then drain its connections and exit. If the new process fails to become ready it is killed and an
+// error returned.
+func Upgrade(timeout time.Duration) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("find executable: %w", err)
+	}
+
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	mutex.Lock()
+	for socket := range active {
+		file, err := socket.file()
+		if err != nil {
+			mutex.Unlock()
+			return nil, fmt.Errorf("duplicate socket %s: %w", socket.socketName(), err)
+		}
+		files = append(files, file)
+		names = append(names, socket.socketName())
+	}
+	mutex.Unlock()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create readiness pipe: %w", err)
+	}
+	defer readyReader.Close()
+
+	var env []string
+	for _, variable := range os.Environ() {
+		switch name, _, _ := strings.Cut(variable, "="); name {
+		case envListenPID, envListenFDs, envListenFDNames, envUpgrade, envReadyFD:
+		default:
+			env = append(env, variable)
+		}
+	}
+	env = append(env,
+		envListenFDs+"="+strconv.Itoa(len(files)),
+		envListenFDNames+"="+strings.Join(names, ":"),
+		envUpgrade+"=1",
+		envReadyFD+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+
+	processFiles := append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...)
+	processFiles = append(processFiles, readyWriter)
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{Env: env, Files: processFiles})
+	readyWriter.Close()
+	if err != nil {
+		return nil, fmt.Errorf("start %s: %w", executable, err)
+	}
+	log.Infof("[Sockets] Started new process %d with %d sockets, waiting for it to become ready", process.Pid, len(files))
+
+	ready := make(chan error, 1)
+	go func() {
+		// The read fails with EOF if the new process exits without reporting ready
+		if _, err := readyReader.Read(make([]byte, 1)); err != nil {
+			ready <- fmt.Errorf("new process %d exited before it was ready", process.Pid)
+			return
+		}
+		ready <- nil
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process %d not ready after %s", process.Pid, timeout)
+	}
+	if err != nil {
+		process.Kill()
+		go process.Wait()
+		return nil, err
+	}
+	return process, nil
+}
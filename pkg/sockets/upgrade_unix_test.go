@@ -0,0 +1,108 @@
+//go:build unix
+
+package sockets
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInitInheritedSocket(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readyReader.Close()
+
+	// The socket is passed as descriptor 3 and the readiness pipe as 4, as Upgrade does
+	var stderr bytes.Buffer
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), helperEnv+"=serve", envListenFDs+"=1", envListenFDNames+"=web", envUpgrade+"=1", envReadyFD+"=4")
+	cmd.ExtraFiles = []*os.File{file, readyWriter}
+	cmd.Stderr = &stderr
+	err = cmd.Start()
+	file.Close()
+	readyWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyReader.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			t.Fatalf("helper process did not report ready: %v: %s", err, stderr.String())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("helper process not ready: %s", stderr.String())
+	}
+
+	if pid := answeringPID(t, listener.Addr().String()); pid != cmd.Process.Pid {
+		t.Errorf("connection answered by process %d, want %d", pid, cmd.Process.Pid)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("helper process failed: %v: %s", err, stderr.String())
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	tests := []struct {
+		name    string
+		helper  string
+		timeout time.Duration
+		wantErr string
+	}{
+		{name: "ready", helper: "serve", timeout: 10 * time.Second},
+		{name: "exits before ready", helper: "fail", timeout: 10 * time.Second, wantErr: "exited before it was ready"},
+		{name: "not ready in time", helper: "hang", timeout: 200 * time.Millisecond, wantErr: "not ready after 200ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := Listen("web", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			t.Setenv(helperEnv, tt.helper)
+
+			process, err := Upgrade(tt.timeout)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Upgrade() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Upgrade() unexpected error: %v", err)
+			}
+			defer process.Kill()
+
+			// The new process accepts on the socket the old one still holds open
+			if pid := answeringPID(t, listener.Addr().String()); pid != process.Pid {
+				t.Errorf("connection answered by process %d, want %d", pid, process.Pid)
+			}
+			if state, err := process.Wait(); err != nil || !state.Success() {
+				t.Errorf("new process exited with %v, %v", state, err)
+			}
+		})
+	}
+}